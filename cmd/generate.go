@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/collector"
 	"github.com/ThomasCrouzet/inframap-d2/internal/config"
@@ -24,6 +27,13 @@ var (
 	autoRender       bool
 	renderFormat     string
 	themeName        string
+	keepGoing        bool
+	partialExitCode  int
+	statsJSON        string
+	recordDir        string
+	replayDir        string
+	refreshCache     bool
+	noCache          bool
 )
 
 var generateCmd = &cobra.Command{
@@ -38,7 +48,7 @@ func init() {
 	rootCmd.AddCommand(generateCmd)
 
 	generateCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output D2 file path")
-	generateCmd.Flags().StringVar(&ansibleInventory, "ansible-inventory", "", "path to Ansible hosts.yml")
+	generateCmd.Flags().StringVar(&ansibleInventory, "ansible-inventory", "", "path to Ansible inventory file or directory")
 	generateCmd.Flags().StringVar(&ansibleGroupVars, "ansible-group-vars", "", "path to Ansible group_vars/")
 	generateCmd.Flags().StringSliceVar(&composeScanDirs, "compose-scan-dir", nil, "directories to scan for compose files (format: path:server)")
 	generateCmd.Flags().StringSliceVar(&composeFiles, "compose-file", nil, "compose files (format: path:server)")
@@ -48,6 +58,15 @@ func init() {
 	generateCmd.Flags().BoolVar(&autoRender, "render", false, "auto-render to SVG/PNG after generating D2 (requires d2)")
 	generateCmd.Flags().StringVar(&renderFormat, "format", "", "output format for --render: svg, png (default: svg)")
 	generateCmd.Flags().StringVar(&themeName, "theme", "", "color theme: default, dark, monochrome, ocean")
+	generateCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "render what was collected even if some sources fail")
+	generateCmd.Flags().IntVar(&partialExitCode, "partial-exit-code", 0, "exit code when --keep-going skipped failed sources")
+	generateCmd.Flags().StringVar(&statsJSON, "stats-json", "", "write per-collector statistics to this JSON file")
+	generateCmd.Flags().StringVar(&recordDir, "record", "", "save every command output and API response collectors read to this directory")
+	generateCmd.Flags().StringVar(&replayDir, "replay", "", "rebuild the diagram offline from a --record directory")
+	generateCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	generateCmd.Flags().BoolVar(&refreshCache, "refresh", false, "query every source again and update the cache")
+	generateCmd.Flags().BoolVar(&noCache, "no-cache", false, "neither read nor write the source cache")
+	generateCmd.MarkFlagsMutuallyExclusive("refresh", "no-cache")
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
@@ -58,10 +77,22 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 	}
 
 	applyFlagOverrides(cfg)
+	if cmd.Flags().Changed("partial-exit-code") {
+		cfg.Collect.PartialExitCode = partialExitCode
+	}
+	if cfg.Collect.OnError != config.OnErrorFail && cfg.Collect.OnError != config.OnErrorContinue {
+		err := fmt.Errorf("invalid collect.on_error %q", cfg.Collect.OnError)
+		fmt.Fprint(os.Stderr, ui.FormatError("Invalid config", err.Error(), "use 'fail' or 'continue'"))
+		return err
+	}
 
 	fmt.Println(ui.Bold("Collecting infrastructure data..."))
 
-	infra, results, err := collector.Collect(cfg)
+	// Ctrl-C cancels in-flight collectors instead of waiting on them
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	infra, results, err := collector.Collect(ctx, cfg)
 
 	// Print collector results
 	for _, r := range results {
@@ -72,6 +103,24 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		} else {
 			ui.CollectorDone(r.Name, r.Detail)
 		}
+		for _, w := range r.Stats.Warnings {
+			ui.Warn(fmt.Sprintf("%s: %s", r.Name, w))
+		}
+	}
+	if infra != nil {
+		for _, c := range infra.Conflicts {
+			ui.Warn("conflict: " + c.String())
+		}
+	}
+
+	if cfg.Collect.Record != "" && results != nil {
+		ui.Success(fmt.Sprintf("Recorded source data to %s", cfg.Collect.Record))
+	}
+
+	if statsJSON != "" {
+		if werr := writeStatsJSON(statsJSON, results, infra); werr != nil {
+			fmt.Fprint(os.Stderr, ui.FormatError("Failed to write stats", werr.Error(), ""))
+		}
 	}
 
 	if err != nil {
@@ -92,6 +141,14 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 
 	ui.Success(fmt.Sprintf("Generated %s (%d servers, %d services)", output, len(infra.Servers), countServices(infra)))
 
+	if len(infra.FailedSources) > 0 {
+		names := make([]string, len(infra.FailedSources))
+		for i, fs := range infra.FailedSources {
+			names[i] = fs.Name
+		}
+		ui.Warn(fmt.Sprintf("partial diagram, %d source(s) failed: %s", len(names), strings.Join(names, ", ")))
+	}
+
 	// Auto-render if requested
 	if cfg.Render.AutoRender {
 		if err := autoRenderD2(output, cfg.Render.Format); err != nil {
@@ -99,6 +156,14 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if len(infra.FailedSources) > 0 && cfg.Collect.PartialExitCode != 0 {
+		cmd.SilenceUsage = true
+		return &exitError{
+			code: cfg.Collect.PartialExitCode,
+			err:  fmt.Errorf("%d source(s) failed", len(infra.FailedSources)),
+		}
+	}
+
 	return nil
 }
 
@@ -145,6 +210,21 @@ func applyFlagOverrides(cfg *config.Config) {
 	if themeName != "" {
 		cfg.Theme = themeName
 	}
+	if keepGoing {
+		cfg.Collect.OnError = config.OnErrorContinue
+	}
+	if recordDir != "" {
+		cfg.Collect.Record = recordDir
+	}
+	if replayDir != "" {
+		cfg.Collect.Replay = replayDir
+	}
+	if refreshCache {
+		cfg.Cache.Refresh = true
+	}
+	if noCache {
+		cfg.Cache.TTL = 0
+	}
 }
 
 func splitColonPair(s string) [2]string {
@@ -156,6 +236,58 @@ func splitColonPair(s string) [2]string {
 	return [2]string{s, ""}
 }
 
+// collectorReport is one collector's entry in the --stats-json file.
+type collectorReport struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // ok, skipped, failed
+	Error  string `json:"error,omitempty"`
+	collector.CollectStats
+	ElapsedMS int64 `json:"elapsed_ms"`
+}
+
+// statsReport is the document written by --stats-json.
+type statsReport struct {
+	Collectors []collectorReport `json:"collectors"`
+	Servers    int               `json:"servers"`
+	Services   int               `json:"services"`
+	Devices    int               `json:"devices"`
+	Conflicts  []string          `json:"conflicts,omitempty"`
+}
+
+func writeStatsJSON(path string, results []collector.CollectResult, infra *model.Infrastructure) error {
+	report := statsReport{Collectors: make([]collectorReport, 0, len(results))}
+	for _, r := range results {
+		cr := collectorReport{
+			Name:         r.Name,
+			Status:       "ok",
+			CollectStats: r.Stats,
+			ElapsedMS:    r.Stats.Elapsed.Milliseconds(),
+		}
+		switch {
+		case r.Skipped:
+			cr.Status = "skipped"
+		case r.Err != nil:
+			cr.Status = "failed"
+			cr.Error = r.Err.Error()
+		}
+		report.Collectors = append(report.Collectors, cr)
+	}
+	if infra != nil {
+		report.Servers = len(infra.Servers)
+		report.Services = countServices(infra)
+		report.Devices = len(infra.Devices)
+		for _, c := range infra.Conflicts {
+			report.Conflicts = append(report.Conflicts, c.String())
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
 func countServices(infra *model.Infrastructure) int {
 	count := 0
 	for _, s := range infra.Servers {
@@ -31,11 +31,11 @@ func runValidate(cmd *cobra.Command, args []string) error {
 
 	fmt.Println(ui.Bold("Validating inframap.yml..."))
 
-	rawSources := cfg.RawSources
+	collectors, rawSources := collector.AllWithPlugins(cfg.RawSources)
 	passed := 0
 	failed := 0
 
-	for _, c := range collector.All() {
+	for _, c := range collectors {
 		meta := c.Metadata()
 
 		if !c.Enabled(rawSources) {
@@ -52,6 +52,7 @@ func runValidate(cmd *cobra.Command, args []string) error {
 
 		// Run validation
 		errs := c.Validate()
+		meta = c.Metadata() // plugins report their own name once validated
 		if len(errs) == 0 {
 			ui.ValidationOK(meta.DisplayName, "configuration valid")
 			passed++
@@ -63,6 +64,16 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if len(cfg.Merge.Precedence) > 0 {
+		if err := collector.ValidatePrecedence(cfg.Merge, collectors); err != nil {
+			ui.ValidationErr("merge.precedence", err.Error(), "list fields (or default) mapped to collector names")
+			failed++
+		} else {
+			ui.ValidationOK("Merge precedence", "configuration valid")
+			passed++
+		}
+	}
+
 	fmt.Println()
 	if failed == 0 {
 		ui.Success(fmt.Sprintf("%d checks passed, 0 errors", passed))
@@ -1,31 +1,35 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
-	"path/filepath"
+	"os/exec"
 	"strings"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
-	"gopkg.in/yaml.v3"
 )
 
 func init() {
 	Register(func() RegisteredCollector { return &AnsibleCollector{} })
 }
 
-// AnsibleCollector parses Ansible YAML inventory and group_vars.
+// AnsibleCollector parses an Ansible inventory (YAML, INI, JSON, a dynamic
+// inventory script or a directory of them), group_vars and host_vars.
 type AnsibleCollector struct {
-	InventoryPath string
-	GroupVarsPath string
-	PrimaryGroup  string
+	InventoryPath       string
+	GroupVarsPath       string
+	HostVarsPath        string // defaults to host_vars next to GroupVarsPath
+	PrimaryGroup        string
+	UseAnsibleInventory bool // resolve the inventory with ansible-inventory --list
 }
 
 func (ac *AnsibleCollector) Metadata() CollectorMetadata {
 	return CollectorMetadata{
 		Name:        "ansible",
 		DisplayName: "Ansible Inventory",
-		Description: "Parses Ansible YAML inventory and group_vars for servers and system services",
+		Description: "Parses Ansible inventories (YAML, INI, JSON, scripts), group_vars and host_vars for servers and system services",
 		ConfigKey:   "ansible",
 		DetectHint:  "hosts.yml",
 	}
@@ -50,9 +54,15 @@ func (ac *AnsibleCollector) Configure(section map[string]any) error {
 	if v, ok := section["group_vars"].(string); ok {
 		ac.GroupVarsPath = v
 	}
+	if v, ok := section["host_vars"].(string); ok {
+		ac.HostVarsPath = v
+	}
 	if v, ok := section["primary_group"].(string); ok {
 		ac.PrimaryGroup = v
 	}
+	if v, ok := section["use_ansible_inventory"].(bool); ok {
+		ac.UseAnsibleInventory = v
+	}
 	return nil
 }
 
@@ -62,11 +72,20 @@ func (ac *AnsibleCollector) Validate() []ValidationError {
 		if _, err := os.Stat(ac.InventoryPath); err != nil {
 			errs = append(errs, ValidationError{
 				Field:      "sources.ansible.inventory",
-				Message:    fmt.Sprintf("file not found: %s", ac.InventoryPath),
+				Message:    fmt.Sprintf("inventory not found: %s", ac.InventoryPath),
 				Suggestion: "check the path or run 'inframap-d2 init' to reconfigure",
 			})
 		}
 	}
+	if ac.UseAnsibleInventory {
+		if _, err := exec.LookPath("ansible-inventory"); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources.ansible.use_ansible_inventory",
+				Message:    "ansible-inventory not found in PATH",
+				Suggestion: "install Ansible or set use_ansible_inventory: false to parse the inventory directly",
+			})
+		}
+	}
 	if ac.GroupVarsPath != "" {
 		if info, err := os.Stat(ac.GroupVarsPath); err != nil || !info.IsDir() {
 			errs = append(errs, ValidationError{
@@ -76,58 +95,67 @@ func (ac *AnsibleCollector) Validate() []ValidationError {
 			})
 		}
 	}
+	if ac.HostVarsPath != "" {
+		if info, err := os.Stat(ac.HostVarsPath); err != nil || !info.IsDir() {
+			errs = append(errs, ValidationError{
+				Field:      "sources.ansible.host_vars",
+				Message:    fmt.Sprintf("directory not found: %s", ac.HostVarsPath),
+				Suggestion: "check the path to your host_vars directory",
+			})
+		}
+	}
 	return errs
 }
 
-// inventoryData is a parsed Ansible inventory.
-type inventoryData = map[string]interface{}
-
 // hostEntry represents a single host's variables.
 type hostEntry struct {
-	AnsibleHost       string `yaml:"ansible_host"`
-	AnsibleUser       string `yaml:"ansible_user"`
-	ServerType        string `yaml:"server_type"`
-	Hostname          string `yaml:"hostname"`
-	TailscaleHostname string `yaml:"tailscale_hostname"`
+	AnsibleHost       string
+	AnsibleUser       string
+	ServerType        string
+	Hostname          string
+	TailscaleHostname string
 }
 
-func (ac *AnsibleCollector) Collect(infra *model.Infrastructure) error {
+func newHostEntry(vars map[string]any) hostEntry {
+	return hostEntry{
+		AnsibleHost:       toString(vars["ansible_host"]),
+		AnsibleUser:       toString(vars["ansible_user"]),
+		ServerType:        toString(vars["server_type"]),
+		Hostname:          toString(vars["hostname"]),
+		TailscaleHostname: toString(vars["tailscale_hostname"]),
+	}
+}
+
+func (ac *AnsibleCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
 	if ac.InventoryPath == "" {
 		return nil
 	}
 
-	if err := ac.parseInventory(infra); err != nil {
+	inv, err := loadInventory(ctx, ac.InventoryPath, ac.UseAnsibleInventory)
+	if err != nil {
 		return fmt.Errorf("parsing ansible inventory: %w", err)
 	}
 
-	if ac.GroupVarsPath != "" {
-		if err := ac.parseGroupVars(infra); err != nil {
-			return fmt.Errorf("parsing group_vars: %w", err)
-		}
+	if err := inv.loadVars(ctx, ac.GroupVarsPath, ac.HostVarsPath); err != nil {
+		return err
 	}
 
+	ac.buildServers(infra, inv)
 	return nil
 }
 
-func (ac *AnsibleCollector) parseInventory(infra *model.Infrastructure) error {
-	data, err := os.ReadFile(ac.InventoryPath)
-	if err != nil {
-		return err
-	}
-
-	var inv inventoryData
-	if err := yaml.Unmarshal(data, &inv); err != nil {
-		return fmt.Errorf("unmarshal inventory: %w", err)
+func (ac *AnsibleCollector) buildServers(infra *model.Infrastructure, inv *inventory) {
+	vars := make(map[string]*hostVars)
+	for _, name := range inv.hostNames() {
+		vars[name] = inv.resolve(name)
 	}
 
 	// Build a map of bootstrap hosts (to get public IPs)
 	bootstrapIPs := make(map[string]string) // tailscale_hostname → public IP
-	if bootstrapGroup, ok := inv["bootstrap"]; ok {
-		hosts := extractHosts(bootstrapGroup)
-		for _, h := range hosts {
-			if h.TailscaleHostname != "" && h.AnsibleHost != "" {
-				bootstrapIPs[h.TailscaleHostname] = h.AnsibleHost
-			}
+	for _, name := range inv.members("bootstrap") {
+		h := newHostEntry(vars[name].values)
+		if h.TailscaleHostname != "" && h.AnsibleHost != "" {
+			bootstrapIPs[h.TailscaleHostname] = h.AnsibleHost
 		}
 	}
 
@@ -137,81 +165,73 @@ func (ac *AnsibleCollector) parseInventory(infra *model.Infrastructure) error {
 		primaryGroup = "tailnet"
 	}
 
-	if group, ok := inv[primaryGroup]; ok {
-		hosts := extractHosts(group)
-		for name, h := range hosts {
-			hostname := strings.ToLower(name)
-			if h.Hostname != "" {
-				hostname = strings.ToLower(h.Hostname)
-			}
+	// An ansible_host shared by several hosts (a jump host or NAT address
+	// with different ports) says nothing about which machine is which.
+	hostAddrs := make(map[string]int)
+	for _, name := range inv.hostNames() {
+		hostAddrs[toString(vars[name].values["ansible_host"])]++
+	}
 
-			stype := model.ServerType(h.ServerType)
-			if stype == "" {
-				stype = model.ServerTypeLab
-			}
+	for _, name := range inv.members(primaryGroup) {
+		h := newHostEntry(vars[name].values)
+		hostname := strings.ToLower(name)
+		if h.Hostname != "" {
+			hostname = strings.ToLower(h.Hostname)
+		}
 
-			server := &model.Server{
-				Hostname: hostname,
-				Label:    hostname,
-				Type:     stype,
-				Online:   true,
-			}
+		stype := model.ServerType(h.ServerType)
+		if stype == "" {
+			stype = model.ServerTypeLab
+		}
 
-			if ip, ok := bootstrapIPs[hostname]; ok {
-				server.PublicIP = ip
-			}
+		server := &model.Server{
+			Hostname: hostname,
+			Label:    hostname,
+			Type:     stype,
+			Online:   true,
+		}
 
-			server.AnsibleGroups = ac.findGroups(inv, name)
-			infra.Servers[hostname] = server
+		if ip, ok := bootstrapIPs[hostname]; ok {
+			server.PublicIP = ip
 		}
+
+		server.AddAlias(strings.ToLower(name))
+		server.AddAlias(strings.ToLower(h.TailscaleHostname))
+		if h.AnsibleHost != "" && hostAddrs[h.AnsibleHost] == 1 {
+			if net.ParseIP(h.AnsibleHost) != nil {
+				server.AddAddress(h.AnsibleHost)
+			} else {
+				server.AddAlias(strings.ToLower(h.AnsibleHost))
+			}
+		}
+
+		server.AnsibleGroups = inv.groupsOf(name)
+		server.AddSource(fmt.Sprintf("%s [%s]", inv.hostFile[name], primaryGroup))
+		extractSystemServices(server, vars[name])
+		extractHealthChecks(server, vars[name])
+		infra.Servers[hostname] = server
 	}
 
 	// Build server groups
-	for groupName, groupData := range inv {
-		if groupName == "all" {
+	for _, groupName := range sortedKeys(inv.groups) {
+		if groupName == "all" || groupName == "ungrouped" {
 			continue
 		}
-		hosts := extractHosts(groupData)
+		hosts := inv.members(groupName)
 		if len(hosts) == 0 {
 			continue
 		}
-		sg := &model.ServerGroup{
-			Name:  groupName,
-			Label: groupName,
-		}
-		for name := range hosts {
-			sg.Servers = append(sg.Servers, name)
-		}
-		infra.ServerGroups[groupName] = sg
-	}
-
-	return nil
-}
-
-func (ac *AnsibleCollector) parseGroupVars(infra *model.Infrastructure) error {
-	// Parse all.yml for global vars
-	allPath := filepath.Join(ac.GroupVarsPath, "all.yml")
-	if data, err := os.ReadFile(allPath); err == nil {
-		var allVars map[string]interface{}
-		if err := yaml.Unmarshal(data, &allVars); err == nil {
-			ac.extractSystemServices(infra, allVars)
-		}
-	}
-
-	// Parse tailnet/vars.yml for service_health_checks
-	tailnetVarsPath := filepath.Join(ac.GroupVarsPath, "tailnet", "vars.yml")
-	if data, err := os.ReadFile(tailnetVarsPath); err == nil {
-		var tailnetVars map[string]interface{}
-		if err := yaml.Unmarshal(data, &tailnetVars); err == nil {
-			ac.extractHealthChecks(infra, tailnetVars)
+		infra.ServerGroups[groupName] = &model.ServerGroup{
+			Name:    groupName,
+			Label:   groupName,
+			Servers: hosts,
 		}
 	}
-
-	return nil
 }
 
-func (ac *AnsibleCollector) extractSystemServices(infra *model.Infrastructure, vars map[string]interface{}) {
-	// Extract netdata and cockpit ports as system services for all servers
+// extractSystemServices adds the netdata and cockpit services a host's
+// variables give a port for.
+func extractSystemServices(server *model.Server, vars *hostVars) {
 	type sysService struct {
 		name string
 		key  string
@@ -222,32 +242,26 @@ func (ac *AnsibleCollector) extractSystemServices(infra *model.Infrastructure, v
 	}
 
 	for _, ss := range services {
-		if portVal, ok := vars[ss.key]; ok {
-			port := toInt(portVal)
-			if port == 0 {
-				continue
-			}
-			for _, server := range infra.Servers {
-				svc := &model.Service{
-					Name: ss.name,
-					Type: model.ServiceTypeSystem,
-					Ports: []model.PortMapping{
-						{HostPort: port, ContainerPort: port, Protocol: "tcp"},
-					},
-				}
-				server.AddService(svc)
-			}
+		port := toInt(vars.values[ss.key])
+		if port == 0 {
+			continue
 		}
+		svc := &model.Service{
+			Name: ss.name,
+			Type: model.ServiceTypeSystem,
+			Ports: []model.PortMapping{
+				{HostPort: port, ContainerPort: port, Protocol: "tcp"},
+			},
+		}
+		svc.AddSource(vars.origins[ss.key])
+		server.AddService(svc)
 	}
 }
 
-func (ac *AnsibleCollector) extractHealthChecks(infra *model.Infrastructure, vars map[string]interface{}) {
-	checks, ok := vars["service_health_checks"]
-	if !ok {
-		return
-	}
-
-	checksMap, ok := checks.(map[string]interface{})
+// extractHealthChecks attaches the host's service_health_checks to its
+// services.
+func extractHealthChecks(server *model.Server, vars *hostVars) {
+	checksMap, ok := vars.values["service_health_checks"].(map[string]interface{})
 	if !ok {
 		return
 	}
@@ -265,73 +279,14 @@ func (ac *AnsibleCollector) extractHealthChecks(infra *model.Infrastructure, var
 			Timeout:        toInt(checkMap["timeout"]),
 		}
 
-		for _, server := range infra.Servers {
-			for _, svc := range server.Services {
-				if svc.Name == name {
-					svc.HealthCheck = hc
-				}
+		for _, svc := range server.Services {
+			if svc.Name == name {
+				svc.HealthCheck = hc
 			}
 		}
 	}
 }
 
-func (ac *AnsibleCollector) findGroups(inv inventoryData, hostName string) []string {
-	var groups []string
-	for groupName, groupData := range inv {
-		if groupName == "all" {
-			continue
-		}
-		hosts := extractHosts(groupData)
-		if _, ok := hosts[hostName]; ok {
-			groups = append(groups, groupName)
-		}
-	}
-	return groups
-}
-
-// extractHosts pulls host entries from an Ansible group structure.
-func extractHosts(group interface{}) map[string]hostEntry {
-	result := make(map[string]hostEntry)
-
-	groupMap, ok := group.(map[string]interface{})
-	if !ok {
-		return result
-	}
-
-	hostsRaw, ok := groupMap["hosts"]
-	if !ok {
-		return result
-	}
-
-	hostsMap, ok := hostsRaw.(map[string]interface{})
-	if !ok {
-		return result
-	}
-
-	for name, hostData := range hostsMap {
-		var entry hostEntry
-		if hostData == nil {
-			result[name] = entry
-			continue
-		}
-
-		hostMap, ok := hostData.(map[string]interface{})
-		if !ok {
-			result[name] = entry
-			continue
-		}
-
-		entry.AnsibleHost = toString(hostMap["ansible_host"])
-		entry.AnsibleUser = toString(hostMap["ansible_user"])
-		entry.ServerType = toString(hostMap["server_type"])
-		entry.Hostname = toString(hostMap["hostname"])
-		entry.TailscaleHostname = toString(hostMap["tailscale_hostname"])
-		result[name] = entry
-	}
-
-	return result
-}
-
 func toString(v interface{}) string {
 	if v == nil {
 		return ""
@@ -0,0 +1,545 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventory is an Ansible inventory read from any of the formats Ansible
+// accepts: YAML, INI, the JSON of `ansible-inventory --list` or a dynamic
+// inventory script, or a directory mixing them.
+type inventory struct {
+	groups   map[string]*inventoryGroup
+	hostVars map[string]map[string]any // inline host variables, by inventory hostname
+	hostFile map[string]string         // where each host was first declared
+
+	groupFiles map[string][]varLayer // group_vars files, by group
+	hostFiles  map[string][]varLayer // host_vars files, by host
+}
+
+// inventoryGroup is one group with its direct hosts, child groups and vars.
+type inventoryGroup struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]any
+	varsFile string // where vars were first set
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		groups:   make(map[string]*inventoryGroup),
+		hostVars: make(map[string]map[string]any),
+		hostFile: make(map[string]string),
+
+		groupFiles: make(map[string][]varLayer),
+		hostFiles:  make(map[string][]varLayer),
+	}
+}
+
+// group returns the named group, creating it if needed.
+func (inv *inventory) group(name string) *inventoryGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &inventoryGroup{name: name, vars: make(map[string]any)}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// addHost declares host in group. Variables given for the same host in
+// several places are merged, the last one winning.
+func (inv *inventory) addHost(group, host string, vars map[string]any, file string) {
+	g := inv.group(group)
+	if !containsStr(g.hosts, host) {
+		g.hosts = append(g.hosts, host)
+	}
+	if _, ok := inv.hostVars[host]; !ok {
+		inv.hostVars[host] = make(map[string]any)
+		inv.hostFile[host] = file
+	}
+	for k, v := range vars {
+		inv.hostVars[host][k] = v
+	}
+}
+
+// setGroupVar sets an inline group variable.
+func (inv *inventory) setGroupVar(group, key string, value any, file string) {
+	g := inv.group(group)
+	g.vars[key] = value
+	if g.varsFile == "" {
+		g.varsFile = file
+	}
+}
+
+func (inv *inventory) addChild(parent, child string) {
+	g := inv.group(parent)
+	inv.group(child)
+	if !containsStr(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+// members returns the hosts of a group and of all its descendants, sorted.
+func (inv *inventory) members(group string) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	var walk func(name string)
+	walk = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		g, ok := inv.groups[name]
+		if !ok {
+			return
+		}
+		for _, h := range g.hosts {
+			if !containsStr(hosts, h) {
+				hosts = append(hosts, h)
+			}
+		}
+		for _, c := range g.children {
+			walk(c)
+		}
+	}
+	walk(group)
+	sort.Strings(hosts)
+	return hosts
+}
+
+// groupsOf returns every group host belongs to, directly or through a child
+// group, leaving out the implicit all and ungrouped groups.
+func (inv *inventory) groupsOf(host string) []string {
+	var groups []string
+	for _, name := range sortedKeys(inv.groups) {
+		if name == "all" || name == "ungrouped" {
+			continue
+		}
+		if containsStr(inv.members(name), host) {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// hostNames returns every host in the inventory, sorted.
+func (inv *inventory) hostNames() []string {
+	return sortedKeys(inv.hostVars)
+}
+
+// loadInventory reads an inventory file or directory. With useCommand, the
+// inventory is resolved by `ansible-inventory --list` instead, which also
+// covers inventory plugins and anything else Ansible itself understands.
+func loadInventory(ctx context.Context, path string, useCommand bool) (*inventory, error) {
+	inv := newInventory()
+	if useCommand {
+		out, err := runCommand(ctx, nil, "ansible-inventory", "-i", path, "--list")
+		if err != nil {
+			return nil, fmt.Errorf("ansible-inventory: %w", err)
+		}
+		if err := inv.parseListJSON(out, "ansible-inventory -i "+path); err != nil {
+			return nil, err
+		}
+		return inv, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return inv, inv.parseFile(ctx, path, info)
+	}
+
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path {
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if strings.HasPrefix(name, ".") || name == "group_vars" || name == "host_vars" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || ignoredInventoryFile(name) {
+			return nil
+		}
+		return inv.parseFile(ctx, p, info)
+	})
+	return inv, err
+}
+
+// ignoredInventoryFile reports whether Ansible skips a file found in an
+// inventory directory (its default inventory_ignore_extensions).
+func ignoredInventoryFile(name string) bool {
+	if strings.HasSuffix(name, "~") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".orig", ".bak", ".ini", ".cfg", ".retry", ".pyc", ".pyo", ".swp", ".rpm", ".md", ".txt", ".rst":
+		return true
+	}
+	return false
+}
+
+// parseFile reads one inventory source, choosing the format as Ansible does:
+// by extension, running executables as dynamic inventory scripts, and
+// reading anything else as INI.
+func (inv *inventory) parseFile(ctx context.Context, path string, info os.FileInfo) error {
+	stats := statsFrom(ctx)
+	ext := strings.ToLower(filepath.Ext(path))
+
+	if ext != ".yml" && ext != ".yaml" && ext != ".json" && info.Mode()&0o111 != 0 {
+		out, err := runCommand(ctx, nil, path, "--list")
+		if err != nil {
+			return fmt.Errorf("inventory script %s: %w", path, err)
+		}
+		return inv.parseListJSON(out, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext {
+	case ".yml", ".yaml":
+		err = inv.parseYAML(data, path)
+	case ".json":
+		err = inv.parseJSON(data, path)
+	default:
+		err = inv.parseINI(data, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	stats.fileParsed()
+	return nil
+}
+
+// parseYAML reads a YAML inventory: top-level keys are groups, each with
+// optional hosts, vars and children.
+func (inv *inventory) parseYAML(data []byte, file string) error {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("unmarshal inventory: %w", err)
+	}
+	for _, name := range sortedKeys(raw) {
+		inv.parseYAMLGroup(name, raw[name], file)
+	}
+	return nil
+}
+
+func (inv *inventory) parseYAMLGroup(name string, node any, file string) {
+	inv.group(name)
+	m, _ := node.(map[string]any)
+
+	if hosts, ok := m["hosts"].(map[string]any); ok {
+		for _, pattern := range sortedKeys(hosts) {
+			vars, _ := hosts[pattern].(map[string]any)
+			for _, host := range expandHostPattern(pattern) {
+				inv.addHost(name, host, vars, file)
+			}
+		}
+	}
+	if vars, ok := m["vars"].(map[string]any); ok {
+		for k, v := range vars {
+			inv.setGroupVar(name, k, v, file)
+		}
+	}
+	if children, ok := m["children"].(map[string]any); ok {
+		for _, child := range sortedKeys(children) {
+			inv.addChild(name, child)
+			inv.parseYAMLGroup(child, children[child], file)
+		}
+	}
+}
+
+// parseJSON reads a .json inventory, either in the YAML inventory layout or
+// as saved `ansible-inventory --list` output.
+func (inv *inventory) parseJSON(data []byte, file string) error {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("unmarshal inventory: %w", err)
+	}
+	if isListJSON(raw) {
+		return inv.parseListJSON(data, file)
+	}
+	for _, name := range sortedKeys(raw) {
+		inv.parseYAMLGroup(name, raw[name], file)
+	}
+	return nil
+}
+
+// isListJSON reports whether raw is in the dynamic inventory format: a _meta
+// section, or groups listing their hosts and children as arrays.
+func isListJSON(raw map[string]any) bool {
+	if _, ok := raw["_meta"]; ok {
+		return true
+	}
+	for _, node := range raw {
+		switch g := node.(type) {
+		case []any:
+			return true
+		case map[string]any:
+			if _, ok := g["hosts"].([]any); ok {
+				return true
+			}
+			if _, ok := g["children"].([]any); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseListJSON reads the dynamic inventory format produced by
+// `ansible-inventory --list` and inventory scripts.
+func (inv *inventory) parseListJSON(data []byte, source string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parsing inventory JSON from %s: %w", source, err)
+	}
+
+	var meta struct {
+		HostVars map[string]map[string]any `json:"hostvars"`
+	}
+	if m, ok := raw["_meta"]; ok {
+		if err := json.Unmarshal(m, &meta); err != nil {
+			return fmt.Errorf("parsing _meta from %s: %w", source, err)
+		}
+	}
+
+	for _, name := range sortedKeys(raw) {
+		if name == "_meta" {
+			continue
+		}
+		var group struct {
+			Hosts    []string       `json:"hosts"`
+			Children []string       `json:"children"`
+			Vars     map[string]any `json:"vars"`
+		}
+		// Old-style scripts may give a group as a bare list of hosts
+		if err := json.Unmarshal(raw[name], &group.Hosts); err != nil {
+			if err := json.Unmarshal(raw[name], &group); err != nil {
+				return fmt.Errorf("parsing group %s from %s: %w", name, source, err)
+			}
+		}
+
+		inv.group(name)
+		for _, host := range group.Hosts {
+			inv.addHost(name, host, meta.HostVars[host], source)
+		}
+		for _, child := range group.Children {
+			inv.addChild(name, child)
+		}
+		for k, v := range group.Vars {
+			inv.setGroupVar(name, k, v, source)
+		}
+	}
+	return nil
+}
+
+// parseINI reads an INI inventory: [group], [group:vars] and
+// [group:children] sections, hosts before any section being ungrouped.
+func (inv *inventory) parseINI(data []byte, file string) error {
+	group, kind := "ungrouped", "hosts"
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return fmt.Errorf("line %d: invalid section %q", lineNo, line)
+			}
+			name, suffix, _ := strings.Cut(line[1:end], ":")
+			group, kind = name, "hosts"
+			switch suffix {
+			case "":
+			case "vars", "children":
+				kind = suffix
+			default:
+				return fmt.Errorf("line %d: invalid section suffix %q", lineNo, suffix)
+			}
+			inv.group(group)
+			continue
+		}
+
+		switch kind {
+		case "hosts":
+			fields := splitINIFields(line)
+			pattern, port := splitHostPort(fields[0])
+			vars := make(map[string]any)
+			if port != "" {
+				vars["ansible_port"] = iniValue(port)
+			}
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected key=value, got %q", lineNo, f)
+				}
+				vars[k] = iniValue(v)
+			}
+			for _, host := range expandHostPattern(pattern) {
+				inv.addHost(group, host, vars, file)
+			}
+		case "children":
+			inv.addChild(group, line)
+		case "vars":
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected key=value, got %q", lineNo, line)
+			}
+			inv.setGroupVar(group, strings.TrimSpace(k), iniValue(strings.TrimSpace(v)), file)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitINIFields splits a host line on whitespace, keeping quoted values
+// ("ansible_ssh_common_args='-o ProxyJump=bastion'") together.
+func splitINIFields(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		case r == '#' && cur.Len() == 0:
+			return fields // trailing comment
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// splitHostPort separates "host:2222" into host and port. Colons inside
+// range brackets ("web[01:03]:2222") don't count, and IPv6 addresses are
+// left alone.
+func splitHostPort(host string) (string, string) {
+	colon, colons, depth := -1, 0, 0
+	for i, r := range host {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				colon = i
+				colons++
+			}
+		}
+	}
+	if colons != 1 {
+		return host, ""
+	}
+	if _, err := strconv.Atoi(host[colon+1:]); err != nil {
+		return host, ""
+	}
+	return host[:colon], host[colon+1:]
+}
+
+// iniValue unquotes an INI value and reads bare numbers and booleans as
+// such, so ports from INI and YAML inventories compare alike.
+func iniValue(s string) any {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
+
+// expandHostPattern expands Ansible host ranges: "web[01:03]" gives web01,
+// web02 and web03, "db-[a:c]" gives db-a, db-b and db-c, and an optional
+// third number is a stride.
+func expandHostPattern(pattern string) []string {
+	start := strings.Index(pattern, "[")
+	end := strings.Index(pattern, "]")
+	if start < 0 || end < start {
+		return []string{pattern}
+	}
+	parts := strings.Split(pattern[start+1:end], ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return []string{pattern}
+	}
+	prefix, suffix := pattern[:start], pattern[end+1:]
+
+	stride := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 {
+			return []string{pattern}
+		}
+		stride = n
+	}
+
+	var items []string
+	if lo, err1 := strconv.Atoi(parts[0]); err1 == nil {
+		hi, err := strconv.Atoi(parts[1])
+		if err != nil || hi < lo {
+			return []string{pattern}
+		}
+		width := len(parts[0])
+		for i := lo; i <= hi; i += stride {
+			items = append(items, fmt.Sprintf("%0*d", width, i))
+		}
+	} else if len(parts[0]) == 1 && len(parts[1]) == 1 && parts[0] <= parts[1] {
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(stride) {
+			items = append(items, string(c))
+		}
+	} else {
+		return []string{pattern}
+	}
+
+	var hosts []string
+	for _, item := range items {
+		hosts = append(hosts, expandHostPattern(prefix+item+suffix)...)
+	}
+	return hosts
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertDemoInventory checks the servers and groups every fixture equivalent
+// to hosts.yml should produce.
+func assertDemoInventory(t *testing.T, infra *model.Infrastructure) {
+	t.Helper()
+	require.ElementsMatch(t, []string{"gateway", "atlas", "nexus"}, sortedKeys(infra.Servers))
+
+	assert.Equal(t, model.ServerTypeProduction, infra.Servers["gateway"].Type)
+	assert.Equal(t, model.ServerTypeLab, infra.Servers["atlas"].Type)
+	assert.Equal(t, "203.0.113.10", infra.Servers["gateway"].PublicIP)
+	assert.Equal(t, "203.0.113.20", infra.Servers["atlas"].PublicIP)
+	assert.Equal(t, "203.0.113.30", infra.Servers["nexus"].PublicIP)
+	assert.Equal(t, []string{"lab_servers", "tailnet"}, infra.Servers["atlas"].AnsibleGroups)
+
+	require.Contains(t, infra.ServerGroups, "tailnet")
+	assert.Equal(t, []string{"atlas", "gateway", "nexus"}, infra.ServerGroups["tailnet"].Servers)
+	assert.Equal(t, []string{"atlas", "nexus"}, infra.ServerGroups["lab_servers"].Servers)
+	assert.Contains(t, infra.ServerGroups, "bootstrap")
+	assert.NotContains(t, infra.ServerGroups, "all")
+	assert.NotContains(t, infra.ServerGroups, "ungrouped")
+}
+
+func TestAnsibleInventoryFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		source string
+		files  int
+	}{
+		{"yaml", "../../testdata/ansible/hosts.yml", "../../testdata/ansible/hosts.yml [tailnet]", 1},
+		{"ini", "../../testdata/ansible/hosts.ini", "../../testdata/ansible/hosts.ini [tailnet]", 1},
+		{"directory", "../../testdata/ansible/inventory", "../../testdata/ansible/inventory/02-tailnet [tailnet]", 2},
+		{"list json", "../../testdata/ansible/list.json", "../../testdata/ansible/list.json [tailnet]", 1},
+		{"script", "../../testdata/ansible/inventory.sh", "../../testdata/ansible/inventory.sh [tailnet]", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := &AnsibleCollector{InventoryPath: tt.path}
+			infra := model.NewInfrastructure()
+			stats := &CollectStats{}
+			require.NoError(t, ac.Collect(withStats(context.Background(), stats), infra))
+
+			assertDemoInventory(t, infra)
+			assert.Equal(t, []model.Source{{Location: tt.source}}, infra.Servers["atlas"].Sources)
+			assert.Equal(t, tt.files, stats.FilesParsed)
+		})
+	}
+}
+
+func TestAnsibleInventoryCommand(t *testing.T) {
+	list, err := filepath.Abs("../../testdata/ansible/list.json")
+	require.NoError(t, err)
+
+	// A stand-in ansible-inventory printing a saved --list output.
+	bin := t.TempDir()
+	script := "#!/bin/sh\ncat " + list + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ansible-inventory"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ac := &AnsibleCollector{}
+	require.NoError(t, ac.Configure(map[string]any{
+		"inventory":             "../../testdata/ansible/hosts.yml",
+		"use_ansible_inventory": true,
+	}))
+	assert.Empty(t, ac.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, ac.Collect(withStats(context.Background(), stats), infra))
+
+	assertDemoInventory(t, infra)
+	assert.Equal(t, 1, stats.APICalls)
+}
+
+func TestParseINIInventory(t *testing.T) {
+	inv := newInventory()
+	require.NoError(t, inv.parseINI([]byte(`
+bastion.example.com:2222
+
+[web]
+web[01:03].lan http_port=8080 # frontends
+db-[a:b] ansible_ssh_common_args='-o ProxyJump=bastion' enabled=true
+
+[web:vars]
+ntp_server = time.example.com
+
+[prod:children]
+web
+`), "hosts"))
+
+	assert.Equal(t, []string{"bastion.example.com"}, inv.groups["ungrouped"].hosts)
+	assert.Equal(t, 2222, inv.hostVars["bastion.example.com"]["ansible_port"])
+	assert.Equal(t, []string{"db-a", "db-b", "web01.lan", "web02.lan", "web03.lan"}, inv.members("prod"))
+	assert.Equal(t, 8080, inv.hostVars["web02.lan"]["http_port"])
+	assert.Equal(t, "-o ProxyJump=bastion", inv.hostVars["db-b"]["ansible_ssh_common_args"])
+	assert.Equal(t, true, inv.hostVars["db-a"]["enabled"])
+	assert.Equal(t, "time.example.com", inv.groups["web"].vars["ntp_server"])
+	assert.Equal(t, []string{"prod", "web"}, inv.groupsOf("web01.lan"))
+
+	assert.Error(t, inv.parseINI([]byte("[web:hosts]\n"), "hosts"))
+}
+
+func TestExpandHostPattern(t *testing.T) {
+	assert.Equal(t, []string{"node1", "node3", "node5"}, expandHostPattern("node[1:5:2]"))
+	assert.Equal(t, []string{"r1-a", "r1-b", "r2-a", "r2-b"}, expandHostPattern("r[1:2]-[a:b]"))
+	assert.Equal(t, []string{"plain"}, expandHostPattern("plain"))
+	assert.Equal(t, []string{"bad[3:1]"}, expandHostPattern("bad[3:1]"))
+}
+
+func TestSplitHostPort(t *testing.T) {
+	host, port := splitHostPort("web[01:03].lan:2222")
+	assert.Equal(t, "web[01:03].lan", host)
+	assert.Equal(t, "2222", port)
+
+	host, port = splitHostPort("web[01:03].lan")
+	assert.Equal(t, "web[01:03].lan", host)
+	assert.Empty(t, port)
+
+	host, port = splitHostPort("2001:db8::1")
+	assert.Equal(t, "2001:db8::1", host)
+	assert.Empty(t, port)
+}
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -17,7 +18,7 @@ func TestAnsibleCollector(t *testing.T) {
 		PrimaryGroup:  "tailnet",
 	}
 
-	err := ac.Collect(infra)
+	err := ac.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Should have 3 servers
@@ -29,6 +30,7 @@ func TestAnsibleCollector(t *testing.T) {
 	assert.Equal(t, "gateway", gateway.Hostname)
 	assert.Equal(t, model.ServerTypeProduction, gateway.Type)
 	assert.Equal(t, "203.0.113.10", gateway.PublicIP)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/ansible/hosts.yml [tailnet]"}}, gateway.Sources)
 
 	// Check atlas
 	atlas, ok := infra.Servers["atlas"]
@@ -73,7 +75,7 @@ func TestAnsibleCollectorNoFile(t *testing.T) {
 		InventoryPath: "",
 	}
 
-	err := ac.Collect(infra)
+	err := ac.Collect(context.Background(), infra)
 	assert.NoError(t, err)
 	assert.Empty(t, infra.Servers)
 }
@@ -0,0 +1,318 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varLayer is the variables one group_vars or host_vars file sets.
+type varLayer struct {
+	file string
+	vars map[string]any
+}
+
+// hostVars are a host's variables after precedence is applied, with the
+// file each one came from.
+type hostVars struct {
+	values  map[string]any
+	origins map[string]string
+}
+
+func (hv *hostVars) apply(vars map[string]any, file string) {
+	for k, v := range vars {
+		hv.values[k] = v
+		hv.origins[k] = file
+	}
+}
+
+// loadVars reads the group_vars and host_vars directories. Without an
+// explicit host_vars, the host_vars directory next to group_vars is used if
+// there is one.
+func (inv *inventory) loadVars(ctx context.Context, groupVars, hostVars string) error {
+	if groupVars != "" {
+		if err := inv.loadGroupVars(ctx, groupVars); err != nil {
+			return fmt.Errorf("parsing group_vars: %w", err)
+		}
+	}
+	if dir := hostVarsDir(groupVars, hostVars); dir != "" {
+		if err := inv.loadHostVars(ctx, dir); err != nil {
+			return fmt.Errorf("parsing host_vars: %w", err)
+		}
+	}
+	return nil
+}
+
+func hostVarsDir(groupVars, hostVars string) string {
+	if hostVars != "" || groupVars == "" {
+		return hostVars
+	}
+	dir := filepath.Join(filepath.Dir(filepath.Clean(groupVars)), "host_vars")
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// loadGroupVars reads group_vars/<group>.yml and group_vars/<group>/*.yml
+// for every group in the inventory.
+func (inv *inventory) loadGroupVars(ctx context.Context, dir string) error {
+	return loadVarsDir(ctx, dir, func(name string) bool {
+		_, ok := inv.groups[name]
+		return ok || name == "all"
+	}, func(name string, layer varLayer) {
+		inv.groupFiles[name] = append(inv.groupFiles[name], layer)
+	})
+}
+
+// loadHostVars reads host_vars/<host>.yml and host_vars/<host>/*.yml for
+// every host in the inventory.
+func (inv *inventory) loadHostVars(ctx context.Context, dir string) error {
+	return loadVarsDir(ctx, dir, func(name string) bool {
+		_, ok := inv.hostVars[name]
+		return ok
+	}, func(name string, layer varLayer) {
+		inv.hostFiles[name] = append(inv.hostFiles[name], layer)
+	})
+}
+
+// loadVarsDir reads a group_vars or host_vars directory. Each entry is named
+// after a group or host, either as a file (optionally with a .yml, .yaml or
+// .json extension) or as a directory whose files are all read in name order.
+// Entries for names the inventory doesn't know are ignored, as Ansible does.
+func loadVarsDir(ctx context.Context, dir string, known func(string) bool, add func(string, varLayer)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	stats := statsFrom(ctx)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		// host_vars/db1.example.com is a file named after a host, not db1
+		// with a .com extension: only strip one when the full name isn't
+		// known.
+		if !entry.IsDir() && !known(name) {
+			ext := filepath.Ext(name)
+			if ext != ".yml" && ext != ".yaml" && ext != ".json" {
+				continue
+			}
+			name = strings.TrimSuffix(name, ext)
+		}
+		if !known(name) {
+			continue
+		}
+
+		files := []string{filepath.Join(dir, entry.Name())}
+		if entry.IsDir() {
+			files, err = varsFiles(files[0])
+			if err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			vars, err := readVarsFile(file)
+			if err != nil {
+				stats.warn("skipping %s: %v", file, err)
+				continue
+			}
+			stats.fileParsed()
+			add(name, varLayer{file: file, vars: vars})
+		}
+	}
+	return nil
+}
+
+// varsFiles lists the vars files under dir, recursively and in name order.
+func varsFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && isVarsFile(info.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func isVarsFile(name string) bool {
+	switch filepath.Ext(name) {
+	case "", ".yml", ".yaml", ".json":
+		return true
+	}
+	return false
+}
+
+// readVarsFile reads a YAML or JSON vars file. Vault-encrypted values can't
+// be read without the vault password and are left out.
+func readVarsFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(string(data), "$ANSIBLE_VAULT") {
+		return nil, fmt.Errorf("vault-encrypted file")
+	}
+	var vars map[string]any
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, err
+	}
+	for k, v := range vars {
+		if s, ok := v.(string); ok && strings.HasPrefix(s, "$ANSIBLE_VAULT") {
+			delete(vars, k)
+		}
+	}
+	return vars, nil
+}
+
+// resolve computes a host's variables with Ansible's precedence, lowest
+// first: inventory group vars, group_vars files, inventory host vars, then
+// host_vars files. Within the group layers "all" comes first, then groups
+// by depth (a child overrides its parent), ansible_group_priority and name.
+func (inv *inventory) resolve(host string) *hostVars {
+	hv := &hostVars{values: make(map[string]any), origins: make(map[string]string)}
+
+	groups := inv.precedenceOrder(host)
+	for _, name := range groups {
+		if g, ok := inv.groups[name]; ok {
+			hv.apply(g.vars, g.varsFile)
+		}
+	}
+	for _, name := range groups {
+		for _, layer := range inv.groupFiles[name] {
+			hv.apply(layer.vars, layer.file)
+		}
+	}
+	hv.apply(inv.hostVars[host], inv.hostFile[host])
+	for _, layer := range inv.hostFiles[host] {
+		hv.apply(layer.vars, layer.file)
+	}
+	return hv
+}
+
+// precedenceOrder returns "all" and the groups host belongs to, from the
+// lowest precedence to the highest.
+func (inv *inventory) precedenceOrder(host string) []string {
+	var groups []string
+	for _, name := range sortedKeys(inv.groups) {
+		if name != "all" && containsStr(inv.members(name), host) {
+			groups = append(groups, name)
+		}
+	}
+	depth := make(map[string]int, len(groups))
+	priority := make(map[string]int, len(groups))
+	for _, name := range groups {
+		depth[name] = inv.depth(name, map[string]bool{})
+		priority[name] = inv.groupPriority(name)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if depth[a] != depth[b] {
+			return depth[a] < depth[b]
+		}
+		return priority[a] < priority[b]
+	})
+	return append([]string{"all"}, groups...)
+}
+
+// depth is a group's distance from "all": 1 for top-level groups, one more
+// than its deepest parent otherwise.
+func (inv *inventory) depth(group string, visiting map[string]bool) int {
+	if group == "all" || visiting[group] {
+		return 0
+	}
+	visiting[group] = true
+	defer delete(visiting, group)
+
+	d := 1
+	for _, name := range sortedKeys(inv.groups) {
+		if name == "all" || !containsStr(inv.groups[name].children, group) {
+			continue
+		}
+		if pd := inv.depth(name, visiting) + 1; pd > d {
+			d = pd
+		}
+	}
+	return d
+}
+
+// groupPriority is the group's ansible_group_priority, 1 by default. As in
+// Ansible, only the inventory sets it: group_vars files are read once the
+// order is settled.
+func (inv *inventory) groupPriority(group string) int {
+	if v, ok := inv.groups[group].vars["ansible_group_priority"]; ok {
+		return toInt(v)
+	}
+	return 1
+}
+
+// findHost returns the inventory host a server name refers to: the host of
+// that name, or else the one whose hostname or tailscale_hostname it is.
+func (inv *inventory) findHost(server string) (string, bool) {
+	for _, name := range inv.hostNames() {
+		if strings.EqualFold(name, server) {
+			return name, true
+		}
+	}
+	for _, name := range inv.hostNames() {
+		vars := inv.resolve(name).values
+		if strings.EqualFold(toString(vars["hostname"]), server) || strings.EqualFold(toString(vars["tailscale_hostname"]), server) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// templateVars returns a host's variables along with the magic variables
+// templates commonly refer to (inventory_hostname, group_names, groups).
+func (inv *inventory) templateVars(host string) map[string]any {
+	vars := make(map[string]any)
+	for k, v := range inv.resolve(host).values {
+		vars[k] = v
+	}
+
+	short, _, _ := strings.Cut(host, ".")
+	vars["inventory_hostname"] = host
+	vars["inventory_hostname_short"] = short
+
+	var groupNames []any
+	for _, g := range inv.groupsOf(host) {
+		groupNames = append(groupNames, g)
+	}
+	vars["group_names"] = groupNames
+
+	groups := map[string]any{"all": stringsToAny(inv.hostNames())}
+	for name := range inv.groups {
+		if name != "all" {
+			groups[name] = stringsToAny(inv.members(name))
+		}
+	}
+	vars["groups"] = groups
+	return vars
+}
+
+func stringsToAny(items []string) []any {
+	out := make([]any, len(items))
+	for i, s := range items {
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnsibleVarsPrecedence(t *testing.T) {
+	ac := &AnsibleCollector{
+		InventoryPath: "../../testdata/ansible/vars/hosts.yml",
+		GroupVarsPath: "../../testdata/ansible/vars/group_vars",
+	}
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, ac.Collect(withStats(context.Background(), stats), infra))
+
+	require.ElementsMatch(t, []string{"web-one", "web2", "db1"}, sortedKeys(infra.Servers))
+
+	// group_vars/web/ beats group_vars/all.yml; host_vars/web1/ renames the host.
+	web1 := infra.Servers["web-one"]
+	assert.Equal(t, model.ServerTypeProduction, web1.Type)
+	assert.Equal(t, model.ServerTypeProduction, infra.Servers["web2"].Type)
+
+	// The deeper tailnet group beats all; files beat inline inventory vars.
+	netdata := web1.Services[0]
+	assert.Equal(t, "netdata", netdata.Name)
+	assert.Equal(t, 19998, netdata.Ports[0].HostPort)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/ansible/vars/group_vars/tailnet.yml"}}, netdata.Sources)
+	require.NotNil(t, netdata.HealthCheck)
+	assert.Equal(t, "/api/v1/info", netdata.HealthCheck.Path)
+
+	// host_vars beats group_vars; backup outranks db by the
+	// ansible_group_priority of the inventory, not the one in db.yml.
+	db1 := infra.Servers["db1"]
+	assert.Equal(t, model.ServerTypeProduction, db1.Type)
+	assert.Contains(t, db1.Aliases, "db-ts")
+	assert.Nil(t, db1.Services[0].HealthCheck, "web's checks don't apply to db1")
+	cockpit := db1.Services[1]
+	assert.Equal(t, "cockpit", cockpit.Name)
+	assert.Equal(t, 9092, cockpit.Ports[0].HostPort)
+
+	// hosts.yml, 6 group_vars files and 2 host_vars files; staging.yml names
+	// no group and the vault-encrypted file can't be read.
+	assert.Equal(t, 9, stats.FilesParsed)
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "vault-encrypted")
+}
+
+func TestAnsibleVarsPrecedenceOrder(t *testing.T) {
+	inv := newInventory()
+	require.NoError(t, inv.parseINI([]byte(`
+[web]
+web1
+
+[prod:children]
+web
+
+[zeta]
+web1
+
+[alpha]
+web1
+
+[alpha:vars]
+ansible_group_priority=5
+`), "hosts"))
+
+	// Depth first, then priority, then name; "all" is always lowest.
+	assert.Equal(t, []string{"all", "prod", "zeta", "alpha", "web"}, inv.precedenceOrder("web1"))
+}
+
+func TestAnsibleVarsFileNamedAfterFQDN(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"hosts.yml":                       "all:\n  children:\n    tailnet:\n      hosts:\n        db1.example.com:\n        web1:\n",
+		"group_vars/all.yml":              "server_type: lab\n",
+		"host_vars/db1.example.com":       "server_type: production\n",
+		"host_vars/web1.example.com.yml~": "server_type: production\n",
+		"host_vars/web1.bak":              "server_type: production\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0o600))
+	}
+
+	ac := &AnsibleCollector{
+		InventoryPath: filepath.Join(dir, "hosts.yml"),
+		GroupVarsPath: filepath.Join(dir, "group_vars"),
+	}
+	infra := model.NewInfrastructure()
+	require.NoError(t, ac.Collect(context.Background(), infra))
+
+	require.ElementsMatch(t, []string{"db1.example.com", "web1"}, sortedKeys(infra.Servers))
+	assert.Equal(t, model.ServerTypeProduction, infra.Servers["db1.example.com"].Type)
+	assert.Equal(t, model.ServerTypeLab, infra.Servers["web1"].Type)
+}
+
+func TestAnsibleHostVarsDir(t *testing.T) {
+	assert.Equal(t, "../../testdata/ansible/vars/host_vars", hostVarsDir("../../testdata/ansible/vars/group_vars/", ""))
+	assert.Empty(t, hostVarsDir("../../testdata/ansible/group_vars", ""))
+	assert.Equal(t, "elsewhere", hostVarsDir("../../testdata/ansible/vars/group_vars", "elsewhere"))
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
+)
+
+// Cache keeps the raw data each collector read on disk for cache.ttl, so
+// re-running generate to tweak rendering does not query every source again.
+// It stores the same cassettes as --record, one per collector and config.
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	refresh bool
+}
+
+// openCache returns the cache configured by cfg, or nil when caching is off.
+func openCache(cfg config.CacheConfig) (*Cache, error) {
+	if cfg.TTL <= 0 {
+		return nil, nil
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("locating cache directory: %w", err)
+		}
+		dir = filepath.Join(base, "inframap-d2")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+	return &Cache{dir: dir, ttl: cfg.TTL, refresh: cfg.Refresh}, nil
+}
+
+// cassette returns the collector's cache entry. hit reports whether fresh
+// data was found; otherwise the cassette records the live run.
+func (c *Cache) cassette(name string, section map[string]any) (cas *cassette, hit bool, err error) {
+	key, err := cacheKey(section)
+	if err != nil {
+		return nil, false, err
+	}
+	file := name + "-" + key
+
+	info, statErr := os.Stat(filepath.Join(c.dir, cassetteFile(file)))
+	hit = !c.refresh && statErr == nil && time.Since(info.ModTime()) < c.ttl
+
+	cas, err = (&Tape{dir: c.dir, replay: hit}).cassette(file)
+	if err != nil {
+		return nil, false, err
+	}
+	cas.cached = true
+	return cas, hit, nil
+}
+
+// cacheKey hashes a collector's config section, so that changing any
+// setting of a source invalidates what was cached for it.
+func cacheKey(section map[string]any) (string, error) {
+	data, err := json.Marshal(section) // map keys are sorted
+	if err != nil {
+		return "", fmt.Errorf("hashing config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cachedRun(t *testing.T, cache config.CacheConfig, section map[string]any) (string, CollectResult) {
+	t.Helper()
+	collectors := []RegisteredCollector{&commandCollector{fakeCollector{name: "clock"}}}
+	cfg := testConfig(map[string]any{"clock": section})
+	cfg.Cache = cache
+
+	infra, results, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+	return infra.Servers["atlas"].OS, results[0]
+}
+
+func TestCacheReusesFreshData(t *testing.T) {
+	cache := config.CacheConfig{TTL: time.Hour, Dir: t.TempDir()}
+
+	first, r := cachedRun(t, cache, map[string]any{})
+	assert.False(t, r.Stats.Cached)
+
+	second, r := cachedRun(t, cache, map[string]any{})
+	assert.True(t, r.Stats.Cached)
+	assert.Contains(t, r.Detail, "cached")
+	assert.Equal(t, first, second)
+}
+
+func TestCacheKeyedByConfig(t *testing.T) {
+	cache := config.CacheConfig{TTL: time.Hour, Dir: t.TempDir()}
+
+	cachedRun(t, cache, map[string]any{"server": "a"})
+	_, r := cachedRun(t, cache, map[string]any{"server": "b"})
+	assert.False(t, r.Stats.Cached)
+}
+
+func TestCacheExpires(t *testing.T) {
+	dir := t.TempDir()
+	cache := config.CacheConfig{TTL: time.Hour, Dir: dir}
+	first, _ := cachedRun(t, cache, map[string]any{})
+
+	files, err := filepath.Glob(filepath.Join(dir, "clock-*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(files[0], old, old))
+
+	second, r := cachedRun(t, cache, map[string]any{})
+	assert.False(t, r.Stats.Cached)
+	assert.NotEqual(t, first, second)
+}
+
+func TestCacheRefresh(t *testing.T) {
+	cache := config.CacheConfig{TTL: time.Hour, Dir: t.TempDir()}
+	first, _ := cachedRun(t, cache, map[string]any{})
+
+	cache.Refresh = true
+	second, r := cachedRun(t, cache, map[string]any{})
+	assert.False(t, r.Stats.Cached)
+	assert.NotEqual(t, first, second)
+
+	// The refreshed data is what the next run reuses.
+	cache.Refresh = false
+	third, _ := cachedRun(t, cache, map[string]any{})
+	assert.Equal(t, second, third)
+}
+
+func TestCacheSkipsFailedRuns(t *testing.T) {
+	dir := t.TempDir()
+	collectors := []RegisteredCollector{&failingCommandCollector{fakeCollector{name: "broken"}}}
+	cfg := testConfig(map[string]any{"broken": map[string]any{}})
+	cfg.Cache = config.CacheConfig{TTL: time.Hour, Dir: dir}
+
+	_, _, err := collectWith(context.Background(), cfg, collectors)
+	require.Error(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+type failingCommandCollector struct {
+	fakeCollector
+}
+
+func (c *failingCommandCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	_, err := runCommand(ctx, nil, "false")
+	return err
+}
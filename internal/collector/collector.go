@@ -1,6 +1,12 @@
 package collector
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/ThomasCrouzet/inframap-d2/internal/config"
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
 )
@@ -10,49 +16,227 @@ type CollectResult struct {
 	Name    string
 	Skipped bool
 	Detail  string
+	Stats   CollectStats
 	Err     error
 }
 
-// Collect runs all registered collectors and merges the results.
-func Collect(cfg *config.Config) (*model.Infrastructure, []CollectResult, error) {
-	infra := model.NewInfrastructure()
+// collectorRun tracks one enabled collector while it runs in the background.
+type collectorRun struct {
+	collector RegisteredCollector
+	name      string
+	infra     *model.Infrastructure
+	timeout   time.Duration
+	cassette  *cassette // nil unless recording, replaying or caching
+	cacheHit  bool
+	stats     CollectStats
+	err       error
+}
+
+// Collect runs all registered collectors concurrently and merges the results.
+// With collect.on_error set to "continue", failed collectors are recorded in
+// Infrastructure.FailedSources and the rest of the data is still returned.
+// Plugins configured under sources.plugins run after the built-in collectors.
+func Collect(ctx context.Context, cfg *config.Config) (*model.Infrastructure, []CollectResult, error) {
+	collectors, sources := AllWithPlugins(cfg.RawSources)
+	runCfg := *cfg
+	runCfg.RawSources = sources
+	return collectWith(ctx, &runCfg, collectors)
+}
+
+// collectWith runs the given collectors. Each one writes into its own
+// Infrastructure so they can run in parallel; the isolated results are then
+// merged in registry order so the output does not depend on timing.
+func collectWith(ctx context.Context, cfg *config.Config, collectors []RegisteredCollector) (*model.Infrastructure, []CollectResult, error) {
 	rawSources := cfg.RawSources
+	keepGoing := cfg.Collect.OnError == config.OnErrorContinue
+	prec := precedence(cfg.Merge.Precedence)
+	if err := prec.validate(collectors); err != nil {
+		return nil, nil, fmt.Errorf("merge.precedence: %w", err)
+	}
 
-	var results []CollectResult
+	tape, err := openTape(cfg.Collect)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cache *Cache
+	if tape == nil {
+		if cache, err = openCache(cfg.Cache); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	for _, c := range All() {
+	results := make([]CollectResult, len(collectors))
+	runs := make([]*collectorRun, len(collectors))
+
+	// Configure sequentially: config errors are reported before anything runs.
+	for i, c := range collectors {
 		meta := c.Metadata()
+		results[i].Name = meta.DisplayName
 
 		if !c.Enabled(rawSources) {
-			results = append(results, CollectResult{Name: meta.DisplayName, Skipped: true})
+			results[i].Skipped = true
 			continue
 		}
 
 		// Extract this collector's config section
 		section, _ := rawSources[meta.ConfigKey].(map[string]any)
-		if err := c.Configure(section); err != nil {
+
+		timeout, err := sectionTimeout(section, cfg.Collect.Timeout)
+		if err == nil {
+			err = c.Configure(section)
+		}
+		var cas *cassette
+		var hit bool
+		if err == nil && tape != nil {
+			cas, err = tape.cassette(meta.Name)
+		} else if err == nil && cache != nil {
+			cas, hit, err = cache.cassette(meta.Name, section)
+		}
+		if err != nil {
 			cerr := &CollectorError{Collector: meta.DisplayName, Err: err}
-			results = append(results, CollectResult{Name: meta.DisplayName, Err: cerr})
-			return nil, results, cerr
+			results[i].Err = cerr
+			if !keepGoing {
+				return nil, results[:i+1], cerr
+			}
+			continue
 		}
 
-		if err := c.Collect(infra); err != nil {
-			cerr := &CollectorError{Collector: meta.DisplayName, Err: err}
-			results = append(results, CollectResult{Name: meta.DisplayName, Err: cerr})
-			return nil, results, cerr
+		runs[i] = &collectorRun{
+			collector: c,
+			name:      meta.DisplayName,
+			infra:     model.NewInfrastructure(),
+			timeout:   timeout,
+			cassette:  cas,
+			cacheHit:  hit,
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		if run == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.err = run.execute(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// Failed runs are recorded too: replaying them reproduces the failure.
+	// They are not cached, so the next run tries the source again.
+	for _, run := range runs {
+		if run == nil || (run.cassette != nil && run.cassette.cached && run.err != nil) {
+			continue
+		}
+		if err := run.cassette.save(); err != nil {
+			return nil, results, fmt.Errorf("saving recording for %s: %w", run.name, err)
+		}
+	}
+
+	// Merge in registry order, whatever order the collectors finished in.
+	infra := model.NewInfrastructure()
+	var firstErr error
+	for i, run := range runs {
+		if run == nil {
+			continue
 		}
+		results[i].Stats = run.stats
+		results[i].Stats.Cached = run.cacheHit
+		if run.err != nil {
+			cerr := &CollectorError{Collector: run.name, Err: run.err}
+			results[i].Err = cerr
+			if firstErr == nil {
+				firstErr = cerr
+			}
+			continue
+		}
+		stampSources(run.infra, run.collector.Metadata().Name)
+		results[i].Stats.add(mergeInto(infra, run.infra, prec))
+		results[i].Detail = results[i].Stats.Summary()
+	}
 
-		results = append(results, CollectResult{Name: meta.DisplayName})
+	// An interrupted run is never worth rendering, even when keeping going.
+	if firstErr != nil && (!keepGoing || ctx.Err() != nil) {
+		return nil, results, firstErr
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			infra.FailedSources = append(infra.FailedSources, model.FailedSource{
+				Name:  r.Name,
+				Error: errors.Unwrap(r.Err).Error(),
+			})
+		}
 	}
 
 	// Merge and correlate
-	Merge(infra)
+	Merge(infra, cfg.Merge)
 
 	return infra, results, nil
 }
 
+// execute runs the collector under its timeout. A collector that ignores
+// cancellation is abandoned once the deadline passes; it only ever writes to
+// its own Infrastructure and stats, which are then discarded.
+func (r *collectorRun) execute(ctx context.Context) error {
+	start := time.Now()
+	defer func() { r.stats.Elapsed = time.Since(start) }()
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	tracker := &CollectStats{}
+	ctx = withStats(ctx, tracker)
+	if r.cassette != nil {
+		ctx = withCassette(ctx, r.cassette)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.collector.Collect(ctx, r.infra)
+	}()
+
+	select {
+	case err := <-done:
+		r.stats = *tracker
+		return err
+	case <-ctx.Done():
+		if r.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", r.timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// openTape returns the tape requested by --record or --replay, if any.
+func openTape(cc config.CollectConfig) (*Tape, error) {
+	switch {
+	case cc.Record != "" && cc.Replay != "":
+		return nil, fmt.Errorf("record and replay cannot be used together")
+	case cc.Record != "":
+		return NewRecorder(cc.Record)
+	case cc.Replay != "":
+		return NewReplayer(cc.Replay)
+	}
+	return nil, nil
+}
+
+// sectionTimeout returns the source's own timeout: setting, or def if unset.
+func sectionTimeout(section map[string]any, def time.Duration) (time.Duration, error) {
+	v, ok := section["timeout"]
+	if !ok {
+		return def, nil
+	}
+	return config.ParseTimeout(v)
+}
+
 // CollectLegacy runs collectors using the typed config (for backward compatibility with tests).
-func CollectLegacy(cfg *config.Config) (*model.Infrastructure, error) {
+func CollectLegacy(ctx context.Context, cfg *config.Config) (*model.Infrastructure, error) {
 	infra := model.NewInfrastructure()
 
 	// Run Ansible collector
@@ -62,7 +246,7 @@ func CollectLegacy(cfg *config.Config) (*model.Infrastructure, error) {
 			GroupVarsPath: cfg.Sources.Ansible.GroupVars,
 			PrimaryGroup:  cfg.Sources.Ansible.PrimaryGroup,
 		}
-		if err := ac.Collect(infra); err != nil {
+		if err := ac.Collect(ctx, infra); err != nil {
 			return nil, err
 		}
 	}
@@ -72,7 +256,7 @@ func CollectLegacy(cfg *config.Config) (*model.Infrastructure, error) {
 		Files:    cfg.Sources.Compose.Files,
 		ScanDirs: cfg.Sources.Compose.ScanDirs,
 	}
-	if err := cc.Collect(infra); err != nil {
+	if err := cc.Collect(ctx, infra); err != nil {
 		return nil, err
 	}
 
@@ -82,13 +266,13 @@ func CollectLegacy(cfg *config.Config) (*model.Infrastructure, error) {
 			JsonFile:       cfg.Sources.Tailscale.JsonFile,
 			IncludeOffline: cfg.Sources.Tailscale.IncludeOffline,
 		}
-		if err := tc.Collect(infra); err != nil {
+		if err := tc.Collect(ctx, infra); err != nil {
 			return nil, err
 		}
 	}
 
 	// Merge and correlate
-	Merge(infra)
+	Merge(infra, cfg.Merge)
 
 	return infra, nil
 }
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCollector is a RegisteredCollector whose behaviour is set by the test.
+type fakeCollector struct {
+	name    string
+	delay   time.Duration
+	hang    bool // ignore ctx and block forever
+	err     error
+	collect func(infra *model.Infrastructure)
+}
+
+func (f *fakeCollector) Metadata() CollectorMetadata {
+	return CollectorMetadata{Name: f.name, DisplayName: f.name, ConfigKey: f.name}
+}
+
+func (f *fakeCollector) Enabled(sources map[string]any) bool {
+	_, ok := sources[f.name]
+	return ok
+}
+
+func (f *fakeCollector) Configure(section map[string]any) error { return nil }
+
+func (f *fakeCollector) Validate() []ValidationError { return nil }
+
+func (f *fakeCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	if f.hang {
+		select {}
+	}
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if f.collect != nil {
+		f.collect(infra)
+	}
+	return f.err
+}
+
+func testConfig(sources map[string]any) *config.Config {
+	return &config.Config{RawSources: sources}
+}
+
+func TestCollectMergesInRegistryOrder(t *testing.T) {
+	// "first" finishes last but is merged first, so "second" wins the OS field.
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "first", delay: 50 * time.Millisecond, collect: func(infra *model.Infrastructure) {
+			infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeProduction, OS: "debian", Online: true}
+		}},
+		&fakeCollector{name: "second", collect: func(infra *model.Infrastructure) {
+			infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeLocal, OS: "linux", Online: true}
+		}},
+		&fakeCollector{name: "disabled"},
+	}
+	cfg := testConfig(map[string]any{"first": map[string]any{}, "second": map[string]any{}})
+
+	infra, results, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+
+	require.Len(t, results, 3)
+	assert.Equal(t, "first", results[0].Name)
+	assert.Equal(t, "second", results[1].Name)
+	assert.True(t, results[2].Skipped)
+
+	atlas := infra.Servers["atlas"]
+	require.NotNil(t, atlas)
+	assert.Equal(t, "linux", atlas.OS)
+	assert.Equal(t, model.ServerTypeProduction, atlas.Type)
+}
+
+func TestCollectRunsConcurrently(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "a", delay: 200 * time.Millisecond},
+		&fakeCollector{name: "b", delay: 200 * time.Millisecond},
+		&fakeCollector{name: "c", delay: 200 * time.Millisecond},
+	}
+	cfg := testConfig(map[string]any{"a": map[string]any{}, "b": map[string]any{}, "c": map[string]any{}})
+
+	start := time.Now()
+	_, _, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestCollectPerSourceTimeout(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "fast"},
+		&fakeCollector{name: "stuck", hang: true},
+	}
+	cfg := testConfig(map[string]any{
+		"fast":  map[string]any{},
+		"stuck": map[string]any{"timeout": "50ms"},
+	})
+	cfg.Collect.Timeout = time.Minute
+
+	_, results, err := collectWith(context.Background(), cfg, collectors)
+	require.Error(t, err)
+
+	var cerr *CollectorError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "stuck", cerr.Collector)
+	assert.Contains(t, err.Error(), "timed out after 50ms")
+	assert.NoError(t, results[0].Err)
+	assert.Error(t, results[1].Err)
+}
+
+func TestCollectGlobalTimeout(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "slow", delay: time.Minute},
+	}
+	cfg := testConfig(map[string]any{"slow": map[string]any{}})
+	cfg.Collect.Timeout = 50 * time.Millisecond
+
+	_, _, err := collectWith(context.Background(), cfg, collectors)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+}
+
+func TestCollectCancelled(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "slow", delay: time.Minute},
+	}
+	cfg := testConfig(map[string]any{"slow": map[string]any{}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := collectWith(ctx, cfg, collectors)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCollectInvalidTimeout(t *testing.T) {
+	collectors := []RegisteredCollector{&fakeCollector{name: "a"}}
+	cfg := testConfig(map[string]any{"a": map[string]any{"timeout": "soon"}})
+
+	_, _, err := collectWith(context.Background(), cfg, collectors)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid timeout")
+}
+
+func TestCollectKeepGoing(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "broken", err: errors.New("connection refused")},
+		&fakeCollector{name: "ok", collect: func(infra *model.Infrastructure) {
+			infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeLab}
+		}},
+		&fakeCollector{name: "stuck", hang: true},
+	}
+	cfg := testConfig(map[string]any{
+		"broken": map[string]any{},
+		"ok":     map[string]any{},
+		"stuck":  map[string]any{"timeout": "50ms"},
+	})
+	cfg.Collect.OnError = config.OnErrorContinue
+
+	infra, results, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+	require.NotNil(t, infra)
+
+	assert.Contains(t, infra.Servers, "atlas")
+	assert.Error(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.Error(t, results[2].Err)
+	assert.Equal(t, []model.FailedSource{
+		{Name: "broken", Error: "connection refused"},
+		{Name: "stuck", Error: "timed out after 50ms"},
+	}, infra.FailedSources)
+}
+
+func TestCollectFailByDefault(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "broken", err: errors.New("connection refused")},
+	}
+	cfg := testConfig(map[string]any{"broken": map[string]any{}})
+
+	infra, _, err := collectWith(context.Background(), cfg, collectors)
+	require.Error(t, err)
+	assert.Nil(t, infra)
+}
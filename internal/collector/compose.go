@@ -12,6 +12,7 @@ import (
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
 	"github.com/ThomasCrouzet/inframap-d2/internal/util"
 	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/loader"
 	composetypes "github.com/compose-spec/compose-go/v2/types"
 	yamlv3 "gopkg.in/yaml.v3"
 )
@@ -22,8 +23,11 @@ func init() {
 
 // ComposeCollector parses docker-compose files and templates.
 type ComposeCollector struct {
-	Files    []config.ComposeFile
-	ScanDirs []config.ScanDir
+	Files        []config.ComposeFile
+	ScanDirs     []config.ScanDir
+	TemplateVars config.TemplateVars // Ansible inventory to render templates with
+
+	vars *inventory // loaded from TemplateVars by Collect
 }
 
 func (cc *ComposeCollector) Metadata() CollectorMetadata {
@@ -81,6 +85,12 @@ func (cc *ComposeCollector) Configure(section map[string]any) error {
 			}
 		}
 	}
+	// Parse template_vars
+	if tv, ok := section["template_vars"].(map[string]any); ok {
+		cc.TemplateVars.Inventory = util.ExpandPath(toString(tv["inventory"]))
+		cc.TemplateVars.GroupVars = util.ExpandPath(toString(tv["group_vars"]))
+		cc.TemplateVars.HostVars = util.ExpandPath(toString(tv["host_vars"]))
+	}
 	// Parse scan_dirs
 	if dirsRaw, ok := section["scan_dirs"]; ok {
 		if list, ok := dirsRaw.([]any); ok {
@@ -125,14 +135,50 @@ func (cc *ComposeCollector) Validate() []ValidationError {
 			})
 		}
 	}
+	tv := cc.TemplateVars
+	if tv.Inventory == "" && (tv.GroupVars != "" || tv.HostVars != "") {
+		errs = append(errs, ValidationError{
+			Field:      "sources.compose.template_vars.inventory",
+			Message:    "template_vars needs an inventory",
+			Suggestion: "set inventory to the Ansible inventory the templates are deployed with",
+		})
+	}
+	for _, f := range []struct{ field, path string }{
+		{"inventory", tv.Inventory},
+		{"group_vars", tv.GroupVars},
+		{"host_vars", tv.HostVars},
+	} {
+		field, path := f.field, f.path
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources.compose.template_vars." + field,
+				Message:    fmt.Sprintf("path not found: %s", path),
+				Suggestion: "check the path or remove template_vars to strip template expressions instead",
+			})
+		}
+	}
 	return errs
 }
 
-func (cc *ComposeCollector) Collect(infra *model.Infrastructure) error {
+func (cc *ComposeCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	if tv := cc.TemplateVars; tv.Inventory != "" {
+		inv, err := loadInventory(ctx, tv.Inventory, false)
+		if err != nil {
+			return fmt.Errorf("loading template_vars inventory: %w", err)
+		}
+		if err := inv.loadVars(ctx, tv.GroupVars, tv.HostVars); err != nil {
+			return fmt.Errorf("loading template_vars: %w", err)
+		}
+		cc.vars = inv
+	}
+
 	// Process explicit files
 	for _, f := range cc.Files {
 		path := util.ExpandPath(f.Path)
-		if err := cc.parseComposeFile(infra, path, f.Server, f.Template); err != nil {
+		if err := cc.parseComposeFile(ctx, infra, path, f.Server, f.Template); err != nil {
 			return fmt.Errorf("parsing compose file %s: %w", f.Path, err)
 		}
 	}
@@ -140,7 +186,7 @@ func (cc *ComposeCollector) Collect(infra *model.Infrastructure) error {
 	// Scan directories
 	for _, dir := range cc.ScanDirs {
 		path := util.ExpandPath(dir.Path)
-		if err := cc.scanDirectory(infra, path, dir.Server); err != nil {
+		if err := cc.scanDirectory(ctx, infra, path, dir.Server); err != nil {
 			return fmt.Errorf("scanning directory %s: %w", dir.Path, err)
 		}
 	}
@@ -148,7 +194,7 @@ func (cc *ComposeCollector) Collect(infra *model.Infrastructure) error {
 	return nil
 }
 
-func (cc *ComposeCollector) scanDirectory(infra *model.Infrastructure, dir, server string) error {
+func (cc *ComposeCollector) scanDirectory(ctx context.Context, infra *model.Infrastructure, dir, server string) error {
 	patterns := []string{
 		"docker-compose.yml",
 		"docker-compose.yaml",
@@ -160,6 +206,9 @@ func (cc *ComposeCollector) scanDirectory(infra *model.Infrastructure, dir, serv
 		if err != nil {
 			return nil // skip inaccessible paths
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if info.IsDir() {
 			// Skip hidden directories and common non-relevant dirs
 			name := info.Name()
@@ -170,9 +219,9 @@ func (cc *ComposeCollector) scanDirectory(infra *model.Infrastructure, dir, serv
 		}
 		for _, pattern := range patterns {
 			if info.Name() == pattern {
-				if err := cc.parseComposeFile(infra, path, server, false); err != nil {
-					// Log but don't fail on individual parse errors
-					fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+				if err := cc.parseComposeFile(ctx, infra, path, server, false); err != nil {
+					// Report but don't fail on individual parse errors
+					statsFrom(ctx).warn("skipping %s: %v", path, err)
 				}
 				return nil
 			}
@@ -181,16 +230,20 @@ func (cc *ComposeCollector) scanDirectory(infra *model.Infrastructure, dir, serv
 	})
 }
 
-func (cc *ComposeCollector) parseComposeFile(infra *model.Infrastructure, path, server string, isTemplate bool) error {
+func (cc *ComposeCollector) parseComposeFile(ctx context.Context, infra *model.Infrastructure, path, server string, isTemplate bool) error {
+	var err error
 	if isTemplate {
-		return cc.parseTemplate(infra, path, server)
+		err = cc.parseTemplate(ctx, infra, path, server)
+	} else {
+		err = cc.parseStandard(ctx, infra, path, server)
 	}
-	return cc.parseStandard(infra, path, server)
+	if err == nil {
+		statsFrom(ctx).fileParsed()
+	}
+	return err
 }
 
-func (cc *ComposeCollector) parseStandard(infra *model.Infrastructure, path, server string) error {
-	ctx := context.Background()
-
+func (cc *ComposeCollector) parseStandard(ctx context.Context, infra *model.Infrastructure, path, server string) error {
 	opts, err := cli.NewProjectOptions(
 		[]string{path},
 		cli.WithDotEnv,
@@ -209,29 +262,40 @@ func (cc *ComposeCollector) parseStandard(infra *model.Infrastructure, path, ser
 	return cc.projectToServices(infra, project, path, server)
 }
 
-func (cc *ComposeCollector) parseTemplate(infra *model.Infrastructure, path, server string) error {
+func (cc *ComposeCollector) parseTemplate(ctx context.Context, infra *model.Infrastructure, path, server string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	// Strip Jinja2 expressions
-	cleaned := util.StripJinja2(string(data))
+	// Parse under the template's own path
+	return cc.parseRaw(infra, cc.renderTemplate(ctx, string(data), path, server), path, server)
+}
 
-	// Write to temp file and parse
-	tmpFile, err := os.CreateTemp("", "compose-*.yml")
-	if err != nil {
-		return err
+// renderTemplate evaluates a Jinja2 compose template with the Ansible
+// variables of the server it's deployed to. Expressions it can't resolve are
+// replaced with placeholders; without template_vars, or when the template is
+// malformed, all of them are.
+func (cc *ComposeCollector) renderTemplate(ctx context.Context, content, path, server string) string {
+	if cc.vars == nil {
+		return util.StripJinja2(content)
 	}
-	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	stats := statsFrom(ctx)
 
-	if _, err := tmpFile.WriteString(cleaned); err != nil {
-		_ = tmpFile.Close()
-		return err
+	host, ok := cc.vars.findHost(server)
+	if !ok {
+		stats.warn("%s: no inventory host for server %s, template expressions left as placeholders", path, server)
+		return util.StripJinja2(content)
 	}
-	_ = tmpFile.Close()
-
-	return cc.parseFallback(infra, tmpFile.Name(), server)
+	rendered, unresolved, err := util.RenderJinja2(content, cc.vars.templateVars(host))
+	if err != nil {
+		stats.warn("%s: %v, template expressions left as placeholders", path, err)
+		return util.StripJinja2(content)
+	}
+	for _, expr := range unresolved {
+		stats.warn("%s: cannot resolve %s for %s", path, expr, host)
+	}
+	return rendered
 }
 
 // parseFallback uses raw YAML parsing when compose-go fails.
@@ -247,6 +311,11 @@ func (cc *ComposeCollector) parseFallback(infra *model.Infrastructure, path, ser
 		content = util.StripJinja2(content)
 	}
 
+	return cc.parseRaw(infra, content, path, server)
+}
+
+// parseRaw reads services from compose YAML content that came from path.
+func (cc *ComposeCollector) parseRaw(infra *model.Infrastructure, content, path, server string) error {
 	var raw map[string]interface{}
 	if err := yamlv3.Unmarshal([]byte(content), &raw); err != nil {
 		return fmt.Errorf("yaml parse: %w", err)
@@ -261,7 +330,13 @@ func (cc *ComposeCollector) parseFallback(infra *model.Infrastructure, path, ser
 		return nil
 	}
 
-	ensureServer(infra, server)
+	ensureServer(infra, server, path)
+
+	// Compose names the project after the file's directory unless told otherwise
+	project := loader.NormalizeProjectName(toString(raw["name"]))
+	if project == "" {
+		project = loader.NormalizeProjectName(filepath.Base(filepath.Dir(path)))
+	}
 
 	for name, svcData := range servicesMap {
 		svcMap, ok := svcData.(map[string]interface{})
@@ -270,10 +345,13 @@ func (cc *ComposeCollector) parseFallback(infra *model.Infrastructure, path, ser
 		}
 
 		svc := &model.Service{
-			Name:        name,
-			Image:       toString(svcMap["image"]),
-			Type:        detectServiceType(toString(svcMap["image"]), name),
-			ComposeFile: path,
+			Name:           name,
+			Image:          toString(svcMap["image"]),
+			Type:           detectServiceType(toString(svcMap["image"]), name),
+			ComposeFile:    path,
+			ContainerName:  toString(svcMap["container_name"]),
+			Project:        project,
+			ComposeService: name,
 		}
 
 		// Parse ports
@@ -296,6 +374,7 @@ func (cc *ComposeCollector) parseFallback(infra *model.Infrastructure, path, ser
 			svc.Volumes = parseVolumes(volsRaw)
 		}
 
+		svc.AddSource(path)
 		infra.Servers[server].AddService(svc)
 	}
 
@@ -303,14 +382,17 @@ func (cc *ComposeCollector) parseFallback(infra *model.Infrastructure, path, ser
 }
 
 func (cc *ComposeCollector) projectToServices(infra *model.Infrastructure, project *composetypes.Project, path, server string) error {
-	ensureServer(infra, server)
+	ensureServer(infra, server, path)
 
 	for _, svc := range project.Services {
 		service := &model.Service{
-			Name:        svc.Name,
-			Image:       svc.Image,
-			Type:        detectServiceType(svc.Image, svc.Name),
-			ComposeFile: path,
+			Name:           svc.Name,
+			Image:          svc.Image,
+			Type:           detectServiceType(svc.Image, svc.Name),
+			ComposeFile:    path,
+			ContainerName:  svc.ContainerName,
+			Project:        project.Name,
+			ComposeService: svc.Name,
 		}
 
 		// Ports
@@ -342,13 +424,14 @@ func (cc *ComposeCollector) projectToServices(infra *model.Infrastructure, proje
 			})
 		}
 
+		service.AddSource(path)
 		infra.Servers[server].AddService(service)
 	}
 
 	return nil
 }
 
-func ensureServer(infra *model.Infrastructure, hostname string) {
+func ensureServer(infra *model.Infrastructure, hostname, location string) {
 	if hostname == "" {
 		return
 	}
@@ -360,6 +443,7 @@ func ensureServer(infra *model.Infrastructure, hostname string) {
 			Online:   true,
 		}
 	}
+	infra.Servers[hostname].AddSource(location)
 }
 
 func detectServiceType(image, name string) model.ServiceType {
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/config"
@@ -21,7 +22,7 @@ func TestComposeCollectorStandard(t *testing.T) {
 		},
 	}
 
-	err := cc.Collect(infra)
+	err := cc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	server, ok := infra.Servers["testserver"]
@@ -39,6 +40,9 @@ func TestComposeCollectorStandard(t *testing.T) {
 	require.NotNil(t, uptimeKuma)
 	assert.Equal(t, "louislam/uptime-kuma:1", uptimeKuma.Image)
 	assert.Equal(t, model.ServiceTypeContainer, uptimeKuma.Type)
+	// The project is named after the directory, as docker compose does.
+	assert.Equal(t, "compose", uptimeKuma.Project)
+	assert.Equal(t, "uptime-kuma", uptimeKuma.ComposeService)
 }
 
 func TestComposeCollectorTemplate(t *testing.T) {
@@ -54,7 +58,7 @@ func TestComposeCollectorTemplate(t *testing.T) {
 		},
 	}
 
-	err := cc.Collect(infra)
+	err := cc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	server, ok := infra.Servers["atlas"]
@@ -69,6 +73,11 @@ func TestComposeCollectorTemplate(t *testing.T) {
 	}
 	require.NotNil(t, stirling, "stirling-pdf service should be parsed from template")
 	assert.Equal(t, "stirlingtools/stirling-pdf:latest", stirling.Image)
+
+	// Provenance points at the template, not at an intermediate file.
+	assert.Equal(t, "../../testdata/compose/template.yml.j2", stirling.ComposeFile)
+	assert.Equal(t, "compose", stirling.Project)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/compose/template.yml.j2"}}, stirling.Sources)
 }
 
 func TestComposeCollectorScanDir(t *testing.T) {
@@ -83,7 +92,7 @@ func TestComposeCollectorScanDir(t *testing.T) {
 		},
 	}
 
-	err := cc.Collect(infra)
+	err := cc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	server, ok := infra.Servers["scanserver"]
@@ -111,3 +120,90 @@ func TestDetectServiceType(t *testing.T) {
 		})
 	}
 }
+
+func TestComposeCollectorTemplateVars(t *testing.T) {
+	cc := &ComposeCollector{}
+	require.NoError(t, cc.Configure(map[string]any{
+		"files": []any{
+			map[string]any{"path": "../../testdata/compose/web.yml.j2", "server": "web-one", "template": true},
+		},
+		"template_vars": map[string]any{
+			"inventory":  "../../testdata/ansible/vars/hosts.yml",
+			"group_vars": "../../testdata/ansible/vars/group_vars",
+		},
+	}))
+	assert.Empty(t, cc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, cc.Collect(withStats(context.Background(), stats), infra))
+
+	server := infra.Servers["web-one"]
+	require.NotNil(t, server)
+	require.Len(t, server.Services, 2)
+	byName := make(map[string]*model.Service)
+	for _, svc := range server.Services {
+		byName[svc.Name] = svc
+	}
+
+	frontend := byName["frontend"]
+	require.NotNil(t, frontend, "service name comes from app_name")
+	assert.Equal(t, "nginx:stable", frontend.Image)
+	require.Len(t, frontend.Ports, 2)
+	assert.Equal(t, 8080, frontend.Ports[0].HostPort)
+	assert.Equal(t, 443, frontend.Ports[1].HostPort)
+	assert.ElementsMatch(t, []string{"proxy", "internal"}, frontend.Networks)
+
+	// exporter_tag is defined nowhere: only that expression is stripped.
+	exporter := byName["exporter"]
+	require.NotNil(t, exporter)
+	assert.Equal(t, "prom/node-exporter:PLACEHOLDER", exporter.Image)
+	assert.Equal(t, 19998, exporter.Ports[0].HostPort)
+	require.Len(t, stats.Warnings, 2, "the vault-encrypted vars file and exporter_tag")
+	assert.Contains(t, stats.Warnings[1], "cannot resolve {{ exporter_tag }} for web1")
+}
+
+func TestComposeCollectorTemplateVarsUnsupportedTag(t *testing.T) {
+	cc := &ComposeCollector{}
+	require.NoError(t, cc.Configure(map[string]any{
+		"files": []any{
+			map[string]any{"path": "../../testdata/compose/partial.yml.j2", "server": "web-one", "template": true},
+		},
+		"template_vars": map[string]any{
+			"inventory":  "../../testdata/ansible/vars/hosts.yml",
+			"group_vars": "../../testdata/ansible/vars/group_vars",
+		},
+	}))
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, cc.Collect(withStats(context.Background(), stats), infra))
+
+	// The {% set %} is dropped; the expressions around it still render.
+	server := infra.Servers["web-one"]
+	require.NotNil(t, server)
+	require.Len(t, server.Services, 1)
+	assert.Equal(t, "frontend", server.Services[0].Name)
+	assert.Equal(t, 8080, server.Services[0].Ports[0].HostPort)
+	assert.Contains(t, stats.Warnings[len(stats.Warnings)-1], "cannot resolve {% set suffix = '-' ~ env %} for web1")
+}
+
+func TestComposeCollectorTemplateVarsUnknownServer(t *testing.T) {
+	cc := &ComposeCollector{
+		Files:        []config.ComposeFile{{Path: "../../testdata/compose/web.yml.j2", Server: "elsewhere", Template: true}},
+		TemplateVars: config.TemplateVars{Inventory: "../../testdata/ansible/vars/hosts.yml"},
+	}
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, cc.Collect(withStats(context.Background(), stats), infra))
+
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "no inventory host for server elsewhere")
+
+	// Stripped: expressions become placeholders, block tags are dropped.
+	var names []string
+	for _, svc := range infra.Servers["elsewhere"].Services {
+		names = append(names, svc.Name)
+	}
+	assert.ElementsMatch(t, []string{"PLACEHOLDER", "exporter"}, names)
+}
@@ -0,0 +1,415 @@
+package collector
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
+
+// identity is everything a server or device can be recognised by.
+type identity struct {
+	names []string // normalized hostnames, FQDNs and aliases
+	addrs []string // IP addresses
+}
+
+// correlate folds together servers that collectors reported under different
+// names — a MagicDNS name, an Ansible inventory alias, an FQDN next to the
+// short name, a shared address, or an entry of the user's aliases map — and
+// folds in devices that turn out to be one of those servers.
+func correlate(infra *model.Infrastructure, aliases map[string][]string, prec precedence) {
+	hostnames := sortedKeys(infra.Servers)
+
+	// User-declared aliases become part of the servers' identities. Any
+	// address the user lists counts, private ones included.
+	canonical := make(map[string]string) // hostname → declared name
+	for _, name := range sortedKeys(aliases) {
+		declared := identityOf(append([]string{name}, aliases[name]...))
+		for _, h := range hostnames {
+			id := serverIdentity(infra.Servers[h])
+			if !declared.matches(id, nil) && !declared.sharesAddress(id) {
+				continue
+			}
+			canonical[h] = strings.ToLower(name)
+			for _, a := range aliases[name] {
+				if net.ParseIP(a) != nil {
+					infra.Servers[h].AddAddress(a)
+				} else {
+					infra.Servers[h].AddAlias(strings.ToLower(a))
+				}
+			}
+		}
+	}
+
+	ids := make([]identity, len(hostnames))
+	for i, h := range hostnames {
+		ids[i] = serverIdentity(infra.Servers[h])
+	}
+	distinct := distinctAddresses(infra, false)
+
+	parent := make([]int, len(hostnames))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hostnames {
+		for j := i + 1; j < len(hostnames); j++ {
+			sameDeclared := canonical[hostnames[i]] != "" && canonical[hostnames[i]] == canonical[hostnames[j]]
+			if sameDeclared || ids[i].matches(ids[j], distinct) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]string)
+	var roots []int
+	for i, h := range hostnames {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], h)
+	}
+
+	renamed := make(map[string]string)
+	for _, root := range roots {
+		members := groups[root]
+		var target string
+		for _, h := range members {
+			if canonical[h] != "" {
+				target = canonical[h]
+				break
+			}
+		}
+		if len(members) == 1 && (target == "" || target == members[0]) {
+			continue
+		}
+		if target == "" {
+			target = pickCanonical(infra, members)
+		}
+		mergeGroup(infra, members, target, renamed, prec)
+	}
+	renameReferences(infra, renamed)
+
+	// Devices that are really one of the servers only add their details.
+	distinct = distinctAddresses(infra, false)
+	for _, devName := range sortedKeys(infra.Devices) {
+		dev := infra.Devices[devName]
+		devID := identityOf(append([]string{dev.Hostname}, dev.Aliases...), dev.TailscaleIP)
+		for _, h := range sortedKeys(infra.Servers) {
+			server := infra.Servers[h]
+			if devID.matches(serverIdentity(server), distinct) {
+				infra.Conflicts = append(infra.Conflicts, enrichFromDevice(server, dev, prec)...)
+				delete(infra.Devices, devName)
+				break
+			}
+		}
+	}
+}
+
+// linkGuests connects the VMs and containers of a hypervisor to the servers
+// they turn out to be, by name or by an address the guest reports, such as a
+// Kubernetes node or an Ansible host running as a Proxmox guest. The guest
+// stays on its hypervisor; the link shows it is also that server.
+func linkGuests(infra *model.Infrastructure) {
+	hostnames := sortedKeys(infra.Servers)
+	distinct := distinctAddresses(infra, true)
+	for _, h := range hostnames {
+		for _, svc := range infra.Servers[h].Services {
+			if svc.Type != model.ServiceTypeVM && svc.Type != model.ServiceTypeLXC {
+				continue
+			}
+			guest := identityOf([]string{svc.Name}, svc.Addresses...)
+			for _, other := range hostnames {
+				if other != h && guest.matches(serverIdentity(infra.Servers[other]), distinct) {
+					infra.Connections = append(infra.Connections, model.Connection{
+						From:  h + "/" + svc.Name,
+						To:    other,
+						Label: "guest",
+						Style: "dashed",
+					})
+					break
+				}
+			}
+		}
+	}
+}
+
+// mergeGroup merges the servers in members into one server named target.
+func mergeGroup(infra *model.Infrastructure, members []string, target string, renamed map[string]string, prec precedence) {
+	base := infra.Servers[target]
+	if base == nil {
+		base = infra.Servers[pickCanonical(infra, members)]
+	}
+
+	var conflicts []model.Conflict
+	for _, h := range members {
+		server := infra.Servers[h]
+		if server == base {
+			continue
+		}
+		conflicts = append(conflicts, mergeServer(base, server, prec)...)
+		base.AddAlias(h)
+		delete(infra.Servers, h)
+		renamed[h] = target
+	}
+
+	if base.Hostname != target {
+		old := base.Hostname
+		delete(infra.Servers, old)
+		if base.Label == old || base.Label == "" {
+			base.Label = target
+		}
+		base.Hostname = target
+		base.AddAlias(old)
+		renamed[old] = target
+	}
+	infra.Servers[target] = base
+	for _, c := range conflicts {
+		c.Server = target
+		infra.Conflicts = append(infra.Conflicts, c)
+	}
+
+	aliases := base.Aliases[:0]
+	for _, a := range base.Aliases {
+		if a != target {
+			aliases = append(aliases, a)
+		}
+	}
+	base.Aliases = aliases
+}
+
+// pickCanonical chooses the name a merged server keeps: a plain short
+// hostname over an FQDN or an IP, then the name most collectors agree on.
+func pickCanonical(infra *model.Infrastructure, members []string) string {
+	sorted := append([]string{}, members...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if plainA, plainB := isPlainName(a), isPlainName(b); plainA != plainB {
+			return plainA
+		}
+		if na, nb := len(infra.Servers[a].Sources), len(infra.Servers[b].Sources); na != nb {
+			return na > nb
+		}
+		return a < b
+	})
+	return sorted[0]
+}
+
+// renameReferences points group memberships and connections at the merged
+// servers' new names.
+func renameReferences(infra *model.Infrastructure, renamed map[string]string) {
+	if len(renamed) == 0 {
+		return
+	}
+	for _, group := range infra.ServerGroups {
+		var servers []string
+		for _, s := range group.Servers {
+			if n, ok := renamed[s]; ok {
+				s = n
+			}
+			if !containsStr(servers, s) {
+				servers = append(servers, s)
+			}
+		}
+		group.Servers = servers
+	}
+	for i, conn := range infra.Connections {
+		infra.Connections[i].From = renameRef(conn.From, renamed)
+		infra.Connections[i].To = renameRef(conn.To, renamed)
+	}
+}
+
+// renameRef rewrites the host part of a "hostname" or "hostname/service" reference.
+func renameRef(ref string, renamed map[string]string) string {
+	host, svc, hasSvc := strings.Cut(ref, "/")
+	if n, ok := renamed[host]; ok {
+		host = n
+	}
+	if hasSvc {
+		return host + "/" + svc
+	}
+	return host
+}
+
+// enrichFromDevice copies a device's Tailscale details onto the server it
+// turned out to be and returns the field conflicts this settled.
+func enrichFromDevice(server *model.Server, dev *model.Device, prec precedence) []model.Conflict {
+	details := &model.Server{
+		Hostname:    dev.Hostname,
+		TailscaleIP: dev.TailscaleIP,
+		OS:          dev.OS,
+		Online:      dev.Online,
+		Sources:     dev.Sources,
+		Aliases:     dev.Aliases,
+	}
+	if len(dev.Sources) > 0 {
+		stampFieldSources(details, dev.Sources[0].Collector)
+	}
+	conflicts := mergeServer(server, details, prec)
+	server.AddAlias(dev.Hostname)
+	return conflicts
+}
+
+func serverIdentity(s *model.Server) identity {
+	addrs := append([]string{s.PublicIP, s.TailscaleIP}, s.Addresses...)
+	return identityOf(append([]string{s.Hostname}, s.Aliases...), addrs...)
+}
+
+// identityOf normalizes names and sorts out IP addresses given as names.
+func identityOf(names []string, addrs ...string) identity {
+	var id identity
+	for _, n := range names {
+		if net.ParseIP(n) != nil {
+			addrs = append(addrs, n)
+			continue
+		}
+		if n = normalizeHostname(n); n != "" && !containsStr(id.names, n) {
+			id.names = append(id.names, n)
+		}
+	}
+	for _, a := range addrs {
+		if a != "" && !containsStr(id.addrs, a) {
+			id.addrs = append(id.addrs, a)
+		}
+	}
+	return id
+}
+
+// matches reports whether two identities describe the same machine: a
+// shared name or address, or an FQDN whose first label is the other's short
+// hostname. Private addresses are reused from one network to the next, so
+// one only counts if it is in distinct.
+func (id identity) matches(other identity, distinct map[string]bool) bool {
+	for _, a := range id.addrs {
+		if (isUniqueAddress(a) || distinct[a]) && containsStr(other.addrs, a) {
+			return true
+		}
+	}
+	for _, n := range id.names {
+		if containsStr(other.names, n) {
+			return true
+		}
+	}
+	return id.shortMatches(other) || other.shortMatches(id)
+}
+
+// sharesAddress reports whether two identities have any address in common.
+func (id identity) sharesAddress(other identity) bool {
+	for _, a := range id.addrs {
+		if containsStr(other.addrs, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (id identity) shortMatches(other identity) bool {
+	for _, n := range id.names {
+		short, _, isFQDN := strings.Cut(n, ".")
+		if isFQDN && containsStr(other.names, short) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHostname lowercases a name, drops the trailing dot of a DNS name
+// and reduces a Tailscale MagicDNS name to the bare hostname.
+func normalizeHostname(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if strings.HasSuffix(name, ".ts.net") || strings.HasSuffix(name, ".tailscale.net") {
+		name, _, _ = strings.Cut(name, ".")
+	}
+	return name
+}
+
+// isUniqueAddress reports whether addr names one machine wherever it is seen:
+// a public or Tailscale (100.64.0.0/10) address, not a private, loopback or
+// link-local one.
+func isUniqueAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+}
+
+// distinctAddresses lists the private addresses that still tell machines
+// apart in this run: no collector reports one for more than one server (nor,
+// with guests, for more than one hypervisor guest), so two sites' own
+// 192.168.1.10 stay apart while an ansible_host matches the node InternalIP
+// Kubernetes reports. Docker bridge addresses are on every Docker host and
+// never count.
+func distinctAddresses(infra *model.Infrastructure, guests bool) map[string]bool {
+	holders := make(map[string]map[string]int) // address → collector → holders
+	hold := func(addrs []string, sources []model.Source, kind string) {
+		collectors := []string{kind}
+		if len(sources) > 0 {
+			collectors = nil
+			for _, src := range sources {
+				if !containsStr(collectors, kind+src.Collector) {
+					collectors = append(collectors, kind+src.Collector)
+				}
+			}
+		}
+		var seen []string
+		for _, a := range addrs {
+			if a == "" || containsStr(seen, a) || isUniqueAddress(a) || !isPrivateAddress(a) {
+				continue
+			}
+			seen = append(seen, a)
+			if holders[a] == nil {
+				holders[a] = make(map[string]int)
+			}
+			for _, c := range collectors {
+				holders[a][c]++
+			}
+		}
+	}
+	for _, h := range sortedKeys(infra.Servers) {
+		server := infra.Servers[h]
+		hold(append([]string{server.PublicIP, server.TailscaleIP}, server.Addresses...), server.Sources, "")
+		if !guests {
+			continue
+		}
+		// Guests are counted apart from servers: a guest and a server
+		// sharing an address are what linkGuests looks for.
+		for _, svc := range server.Services {
+			if svc.Type == model.ServiceTypeVM || svc.Type == model.ServiceTypeLXC {
+				hold(svc.Addresses, svc.Sources, "guest:")
+			}
+		}
+	}
+
+	distinct := make(map[string]bool)
+	for a, byCollector := range holders {
+		single := true
+		for _, n := range byCollector {
+			single = single && n == 1
+		}
+		distinct[a] = single
+	}
+	return distinct
+}
+
+// isPrivateAddress reports whether addr is a private (RFC 1918 or ULA)
+// address outside the ranges Docker gives its bridge networks,
+// 172.17.0.0/16 to 172.31.0.0/16.
+func isPrivateAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil || !ip.IsPrivate() {
+		return false
+	}
+	ip4 := ip.To4()
+	return ip4 == nil || ip4[0] != 172 || ip4[1] < 17
+}
+
+// isPlainName reports whether name is a short hostname rather than an FQDN or IP.
+func isPlainName(name string) bool {
+	return !strings.Contains(name, ".") && net.ParseIP(name) == nil
+}
@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelateMagicDNSName(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["gateway"] = &model.Server{Hostname: "gateway", Label: "gateway", Type: model.ServerTypeProduction}
+	infra.Servers["gateway.tail1234.ts.net"] = &model.Server{
+		Hostname: "gateway.tail1234.ts.net",
+		Label:    "gateway.tail1234.ts.net",
+		Services: []*model.Service{{Name: "caddy"}},
+	}
+
+	correlate(infra, nil, nil)
+
+	require.Len(t, infra.Servers, 1)
+	gateway := infra.Servers["gateway"]
+	require.NotNil(t, gateway)
+	assert.Equal(t, "gateway", gateway.Label)
+	assert.Equal(t, []string{"gateway.tail1234.ts.net"}, gateway.Aliases)
+	require.Len(t, gateway.Services, 1)
+}
+
+func TestCorrelateFQDNAndAddress(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["nas"] = &model.Server{Hostname: "nas"}
+	infra.Servers["nas.home.lan"] = &model.Server{Hostname: "nas.home.lan"}
+	infra.Servers["pve1"] = &model.Server{Hostname: "pve1", Addresses: []string{"203.0.113.10"}}
+	infra.Servers["hypervisor"] = &model.Server{Hostname: "hypervisor", PublicIP: "203.0.113.10"}
+	infra.Servers["web"] = &model.Server{Hostname: "web"}
+
+	correlate(infra, nil, nil)
+
+	assert.ElementsMatch(t, []string{"nas", "hypervisor", "web"}, sortedKeys(infra.Servers))
+	assert.Equal(t, []string{"pve1"}, infra.Servers["hypervisor"].Aliases)
+}
+
+func TestCorrelatePrivateAddress(t *testing.T) {
+	// Two sites of one inventory, each with its own 192.168.1.10, a host
+	// sharing a Docker bridge address with one of them, and a node that only
+	// Kubernetes reports at 192.168.1.20.
+	inventory := []model.Source{{Collector: "ansible"}}
+	infra := model.NewInfrastructure()
+	infra.Servers["paris-nas"] = &model.Server{Hostname: "paris-nas", Addresses: []string{"192.168.1.10", "172.17.0.1"}, Sources: inventory}
+	infra.Servers["lyon-nas"] = &model.Server{Hostname: "lyon-nas", Addresses: []string{"192.168.1.10"}, Sources: inventory}
+	infra.Servers["builder"] = &model.Server{Hostname: "builder", Addresses: []string{"172.17.0.1"}}
+	infra.Servers["worker"] = &model.Server{Hostname: "worker", Addresses: []string{"192.168.1.20"}, Sources: inventory}
+	infra.Servers["k3s-worker-1"] = &model.Server{Hostname: "k3s-worker-1", Addresses: []string{"192.168.1.20"}, Sources: []model.Source{{Collector: "kubernetes"}}}
+
+	correlate(infra, nil, nil)
+	assert.ElementsMatch(t, []string{"paris-nas", "lyon-nas", "builder", "k3s-worker-1"}, sortedKeys(infra.Servers))
+	assert.Contains(t, infra.Servers["k3s-worker-1"].Aliases, "worker")
+
+	// Declared by the user, a private address does identify a machine.
+	correlate(infra, map[string][]string{"nas": {"192.168.1.10"}}, nil)
+	assert.ElementsMatch(t, []string{"nas", "builder", "k3s-worker-1"}, sortedKeys(infra.Servers))
+}
+
+func TestCorrelateUserAliases(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["srv-01"] = &model.Server{Hostname: "srv-01", Label: "srv-01"}
+	infra.Servers["k8s-default"] = &model.Server{Hostname: "k8s-default", Label: "Kubernetes"}
+	infra.ServerGroups["web"] = &model.ServerGroup{Name: "web", Servers: []string{"srv-01", "k8s-default"}}
+	infra.Connections = []model.Connection{{From: "srv-01/nginx", To: "k8s-default"}}
+
+	correlate(infra, map[string][]string{
+		"atlas": {"srv-01", "k8s-default"},
+	}, nil)
+
+	require.Len(t, infra.Servers, 1)
+	atlas := infra.Servers["atlas"]
+	require.NotNil(t, atlas)
+	assert.Equal(t, "atlas", atlas.Hostname)
+	assert.ElementsMatch(t, []string{"srv-01", "k8s-default"}, atlas.Aliases)
+	assert.Equal(t, []string{"atlas"}, infra.ServerGroups["web"].Servers)
+	assert.Equal(t, "atlas/nginx", infra.Connections[0].From)
+	assert.Equal(t, "atlas", infra.Connections[0].To)
+}
+
+func TestCorrelateFoldsDevices(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["gateway"] = &model.Server{Hostname: "gateway", Addresses: []string{"203.0.113.10"}}
+	infra.Devices["gw"] = &model.Device{
+		Hostname:    "gw",
+		Aliases:     []string{"gateway.tail1234.ts.net"},
+		TailscaleIP: "100.64.0.2",
+		OS:          "linux",
+	}
+	infra.Devices["phone"] = &model.Device{Hostname: "phone"}
+
+	correlate(infra, nil, nil)
+
+	assert.NotContains(t, infra.Devices, "gw")
+	assert.Contains(t, infra.Devices, "phone")
+	assert.Equal(t, "100.64.0.2", infra.Servers["gateway"].TailscaleIP)
+	assert.Contains(t, infra.Servers["gateway"].Aliases, "gw")
+}
+
+func TestAnsibleSharedHostIsNotAnAddress(t *testing.T) {
+	ac := &AnsibleCollector{InventoryPath: "../../testdata/ansible/jump.yml"}
+	infra := model.NewInfrastructure()
+	require.NoError(t, ac.Collect(context.Background(), infra))
+
+	assert.Empty(t, infra.Servers["app1"].Addresses)
+	assert.Empty(t, infra.Servers["app2"].Addresses)
+	assert.Equal(t, []string{"10.0.0.5"}, infra.Servers["db"].Addresses)
+	assert.Contains(t, infra.Servers["db"].Aliases, "db.internal")
+
+	correlate(infra, nil, nil)
+	assert.Len(t, infra.Servers, 3)
+}
+
+func TestLinkGuests(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["pve1"] = &model.Server{
+		Hostname: "pve1",
+		Type:     model.ServerTypeHypervisor,
+		Services: []*model.Service{
+			{Name: "k3s-server", Type: model.ServiceTypeVM},
+			{Name: "media", Type: model.ServiceTypeLXC},
+			{Name: "vm-104", Type: model.ServiceTypeVM, Addresses: []string{"192.168.1.60", "100.64.0.60"}},
+			{Name: "vm-105", Type: model.ServiceTypeVM, Addresses: []string{"192.168.1.61"}},
+			{Name: "vm-106", Type: model.ServiceTypeVM, Addresses: []string{"192.168.1.62", "172.18.0.1"}},
+			{Name: "pveproxy", Type: model.ServiceTypeSystem},
+		},
+	}
+	infra.Servers["k3s-server"] = &model.Server{Hostname: "k3s-server", Type: model.ServerTypeCluster}
+	infra.Servers["media"] = &model.Server{Hostname: "media", Aliases: []string{"media.home.lan"}}
+	infra.Servers["nas"] = &model.Server{Hostname: "nas", TailscaleIP: "100.64.0.60"}
+	infra.Servers["backup"] = &model.Server{Hostname: "backup", Addresses: []string{"192.168.1.61"}}
+	// 192.168.1.62 is on two inventory hosts, and Docker bridges are everywhere.
+	inventory := []model.Source{{Collector: "ansible"}}
+	infra.Servers["paris-ci"] = &model.Server{Hostname: "paris-ci", Addresses: []string{"192.168.1.62"}, Sources: inventory}
+	infra.Servers["lyon-ci"] = &model.Server{Hostname: "lyon-ci", Addresses: []string{"192.168.1.62"}, Sources: inventory}
+	infra.Servers["builder"] = &model.Server{Hostname: "builder", Addresses: []string{"172.18.0.1"}}
+
+	linkGuests(infra)
+
+	assert.Equal(t, []model.Connection{
+		{From: "pve1/k3s-server", To: "k3s-server", Label: "guest", Style: "dashed"},
+		{From: "pve1/media", To: "media", Label: "guest", Style: "dashed"},
+		{From: "pve1/vm-104", To: "nas", Label: "guest", Style: "dashed"},
+		{From: "pve1/vm-105", To: "backup", Label: "guest", Style: "dashed"},
+	}, infra.Connections)
+}
+
+func TestMergeKubernetesNodes(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["pve1"] = &model.Server{
+		Hostname: "pve1",
+		Type:     model.ServerTypeHypervisor,
+		Services: []*model.Service{{Name: "k3s-agent-1", Type: model.ServiceTypeVM}},
+	}
+	infra.Servers["agent1"] = &model.Server{
+		Hostname:  "agent1",
+		Type:      model.ServerTypeLab,
+		Addresses: []string{"192.168.1.32"},
+		Sources:   []model.Source{{Collector: "ansible"}},
+	}
+
+	fakeKubectl(t, "pods", "svc", "ingress", "nodes")
+	kc := &KubernetesCollector{}
+	require.NoError(t, kc.Collect(context.Background(), infra))
+	Merge(infra, config.MergeConfig{})
+
+	// The inventory host shares the node's InternalIP: one machine, in the
+	// cluster, still known by the node name.
+	assert.NotContains(t, infra.Servers, "k3s-agent-1")
+	agent := infra.Servers["agent1"]
+	require.NotNil(t, agent)
+	assert.Equal(t, model.ServerTypeCluster, agent.Type)
+	assert.Contains(t, agent.Aliases, "k3s-agent-1")
+
+	// The Proxmox guest of the same name links to it, and so do the
+	// workloads scheduled on the node.
+	assert.Contains(t, infra.Connections, model.Connection{From: "pve1/k3s-agent-1", To: "agent1", Label: "guest", Style: "dashed"})
+	assert.Contains(t, infra.Connections, model.Connection{From: "k8s-default/postgres", To: "agent1", Label: "1 pod", Style: "dashed"})
+}
@@ -0,0 +1,235 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
+
+// dedupeServices folds together services that several collectors (or one
+// collector reading overlapping files) reported on the same server: Compose
+// and Portainer describing the same container, or systemd re-reporting a
+// database a compose file declares. The first report keeps its name; later
+// ones only add what it was missing.
+func dedupeServices(infra *model.Infrastructure) {
+	for _, hostname := range sortedKeys(infra.Servers) {
+		server := infra.Servers[hostname]
+		if len(server.Services) < 2 {
+			continue
+		}
+
+		var kept []*model.Service
+		renamed := make(map[string]string)
+		for _, svc := range server.Services {
+			var match *model.Service
+			for _, k := range kept {
+				if sameService(k, svc) {
+					match = k
+					break
+				}
+			}
+			if match == nil {
+				kept = append(kept, svc)
+				continue
+			}
+			mergeService(match, svc)
+			if svc.Name != match.Name {
+				renamed[svc.Name] = match.Name
+			}
+		}
+		server.Services = kept
+
+		if len(renamed) > 0 {
+			renameServiceRefs(infra, server, renamed)
+		}
+	}
+}
+
+// sameService reports whether two services on one server are the same
+// container or process.
+func sameService(a, b *model.Service) bool {
+	// Workloads (Kubernetes objects, Swarm services) are named uniquely by
+	// their source, and often share an image: only the same kind and name
+	// is the same workload.
+	if a.Kind != "" || b.Kind != "" {
+		return a.Kind == b.Kind && strings.EqualFold(a.Name, b.Name)
+	}
+	// Compose services are named by their project: "db" in app1 and "db"
+	// in app2 are two databases, whatever they run.
+	if a.Project != "" && a.ComposeService != "" && b.Project != "" && b.ComposeService != "" {
+		return a.Project == b.Project && a.ComposeService == b.ComposeService
+	}
+	if strings.EqualFold(a.Name, b.Name) {
+		return true
+	}
+	if matchesContainer(a, b) || matchesContainer(b, a) {
+		return true
+	}
+	return sameDatabase(a, b) || sameDatabase(b, a)
+}
+
+// matchesContainer reports whether a's container is the one b describes.
+func matchesContainer(a, b *model.Service) bool {
+	if a.ContainerName == "" {
+		return false
+	}
+	return a.ContainerName == b.ContainerName || a.ContainerName == b.Name
+}
+
+// sameDatabase reports whether unit is a systemd database service for the
+// database image that svc runs ("postgresql" for postgres:16).
+func sameDatabase(unit, svc *model.Service) bool {
+	if unit.Image != "" || svc.Image == "" {
+		return false
+	}
+	if unit.Type != model.ServiceTypeDatabase || svc.Type != model.ServiceTypeDatabase {
+		return false
+	}
+	repo := imageRepo(svc.Image)
+	base := repo[strings.LastIndex(repo, "/")+1:]
+	name := strings.ToLower(unit.Name)
+	return strings.HasPrefix(name, base) || strings.HasPrefix(base, name)
+}
+
+// imageRepo reduces an image reference to its repository, without registry
+// defaults, tag or digest: "docker.io/library/postgres:16" → "postgres".
+func imageRepo(image string) string {
+	image = strings.ToLower(image)
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "library/"} {
+		image = strings.TrimPrefix(image, prefix)
+	}
+	return image
+}
+
+// mergeService adds what src knows about a service to dst.
+func mergeService(dst, src *model.Service) {
+	if dst.Image == "" {
+		dst.Image = src.Image
+	}
+	if dst.Type == "" || dst.Type == model.ServiceTypeSystem {
+		dst.Type = src.Type
+	}
+	if dst.HealthCheck == nil {
+		dst.HealthCheck = src.HealthCheck
+	}
+	if dst.ComposeFile == "" {
+		dst.ComposeFile = src.ComposeFile
+	}
+	if dst.Category == "" {
+		dst.Category = src.Category
+	}
+	if dst.Status == "" {
+		dst.Status = src.Status
+	}
+	if dst.ContainerName == "" {
+		dst.ContainerName = src.ContainerName
+	}
+	if dst.Project == "" {
+		dst.Project = src.Project
+	}
+	if dst.ComposeService == "" {
+		dst.ComposeService = src.ComposeService
+	}
+	if dst.Kind == "" {
+		dst.Kind = src.Kind
+	}
+	if dst.Replicas == 0 {
+		dst.Replicas = src.Replicas
+	}
+	if dst.Desired == 0 {
+		dst.Desired = src.Desired
+	}
+
+	for _, p := range src.Ports {
+		if !hasPort(dst.Ports, p) {
+			dst.Ports = append(dst.Ports, p)
+		}
+	}
+	for _, v := range src.Volumes {
+		if !hasVolume(dst.Volumes, v) {
+			dst.Volumes = append(dst.Volumes, v)
+		}
+	}
+	for _, n := range src.Networks {
+		if !containsStr(dst.Networks, n) {
+			dst.Networks = append(dst.Networks, n)
+		}
+	}
+	for _, d := range src.DependsOn {
+		if !containsStr(dst.DependsOn, d) {
+			dst.DependsOn = append(dst.DependsOn, d)
+		}
+	}
+	dst.Sources = model.AddSources(dst.Sources, src.Sources...)
+}
+
+// hasPort reports whether ports already publishes p. A mapping without a
+// host IP or protocol (as the Docker API reports it) matches one that has them.
+func hasPort(ports []model.PortMapping, p model.PortMapping) bool {
+	for _, q := range ports {
+		if q.HostPort != p.HostPort || q.ContainerPort != p.ContainerPort {
+			continue
+		}
+		if q.HostIP != "" && p.HostIP != "" && q.HostIP != p.HostIP {
+			continue
+		}
+		if protocolOf(q) == protocolOf(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func protocolOf(p model.PortMapping) string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return strings.ToLower(p.Protocol)
+}
+
+func hasVolume(volumes []model.VolumeMount, v model.VolumeMount) bool {
+	for _, w := range volumes {
+		if w.Target == v.Target && (w.Source == v.Source || w.Source == "" || v.Source == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// renameServiceRefs points dependencies and connections at the names the
+// deduplicated services kept.
+func renameServiceRefs(infra *model.Infrastructure, server *model.Server, renamed map[string]string) {
+	for _, svc := range server.Services {
+		var deps []string
+		for _, d := range svc.DependsOn {
+			if n, ok := renamed[d]; ok {
+				d = n
+			}
+			if d != svc.Name && !containsStr(deps, d) {
+				deps = append(deps, d)
+			}
+		}
+		svc.DependsOn = deps
+	}
+	for i, conn := range infra.Connections {
+		infra.Connections[i].From = renameServiceRef(conn.From, server.Hostname, renamed)
+		infra.Connections[i].To = renameServiceRef(conn.To, server.Hostname, renamed)
+	}
+}
+
+func renameServiceRef(ref, hostname string, renamed map[string]string) string {
+	host, svc, ok := strings.Cut(ref, "/")
+	if !ok || host != hostname {
+		return ref
+	}
+	if n, ok := renamed[svc]; ok {
+		return host + "/" + n
+	}
+	return ref
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupeComposeAndPortainer(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Services: []*model.Service{
+		{
+			Name: "web", Image: "nginx:1.27", Project: "myapp", ComposeService: "web",
+			Ports:     []model.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+			DependsOn: []string{"db"},
+			Sources:   []model.Source{{Collector: "compose"}},
+		},
+		{
+			Name: "db", Image: "postgres:16", Project: "myapp", ComposeService: "db",
+			Volumes: []model.VolumeMount{{Source: "pgdata", Target: "/var/lib/postgresql/data"}},
+		},
+		{
+			Name: "myapp-web-1", Image: "nginx:1.27", ContainerName: "myapp-web-1",
+			Project: "myapp", ComposeService: "web",
+			Ports: []model.PortMapping{
+				{HostPort: 8080, ContainerPort: 80},
+				{HostPort: 8443, ContainerPort: 443, Protocol: "tcp"},
+			},
+			Sources: []model.Source{{Collector: "portainer"}},
+		},
+		{
+			Name: "myapp-db-1", Image: "postgres:16", ContainerName: "myapp-db-1",
+			Project: "myapp", ComposeService: "db",
+			Volumes: []model.VolumeMount{{Source: "/var/lib/docker/volumes/myapp_pgdata/_data", Target: "/var/lib/postgresql/data"}},
+		},
+	}}
+	infra.Connections = []model.Connection{{From: "atlas/myapp-web-1", To: "atlas/myapp-db-1"}}
+
+	dedupeServices(infra)
+
+	services := infra.Servers["atlas"].Services
+	require.Len(t, services, 2)
+	web := services[0]
+	assert.Equal(t, "web", web.Name)
+	assert.Equal(t, "myapp-web-1", web.ContainerName)
+	assert.Equal(t, []model.PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostPort: 8443, ContainerPort: 443, Protocol: "tcp"},
+	}, web.Ports)
+	assert.Equal(t, []string{"db"}, web.DependsOn)
+	assert.Equal(t, []model.Source{{Collector: "compose"}, {Collector: "portainer"}}, web.Sources)
+	assert.Len(t, services[1].Volumes, 2)
+
+	assert.Equal(t, model.Connection{From: "atlas/web", To: "atlas/db"}, infra.Connections[0])
+}
+
+func TestDedupeSystemdDatabase(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["nexus"] = &model.Server{Hostname: "nexus", Services: []*model.Service{
+		{Name: "db", Image: "docker.io/library/postgres:16", Type: model.ServiceTypeDatabase},
+		{Name: "redis", Image: "redis:7", Type: model.ServiceTypeDatabase},
+		{Name: "postgresql", Type: model.ServiceTypeDatabase},
+		{Name: "redis-server", Type: model.ServiceTypeDatabase},
+		{Name: "sshd", Type: model.ServiceTypeSystem},
+	}}
+
+	dedupeServices(infra)
+
+	var names []string
+	for _, svc := range infra.Servers["nexus"].Services {
+		names = append(names, svc.Name)
+	}
+	assert.Equal(t, []string{"db", "redis", "sshd"}, names)
+}
+
+func TestDedupeKeepsDistinctServices(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Services: []*model.Service{
+		// The db service of two compose projects
+		{Name: "db", Image: "postgres:16", Project: "app1", ComposeService: "db"},
+		{Name: "db", Image: "mariadb:11", Project: "app2", ComposeService: "db"},
+		{Name: "cache", Image: "redis:7", Project: "app1", ComposeService: "cache"},
+		{Name: "cache", Image: "redis:7", Project: "app2", ComposeService: "cache"},
+		// Two containers of one project with the same image
+		{Name: "worker-a", Image: "myapp:latest", ContainerName: "worker-a"},
+		{Name: "worker-b", Image: "myapp:latest", ContainerName: "worker-b"},
+		// Services running the same image under related names
+		{Name: "web", Image: "nginx:1.27"},
+		{Name: "web-admin", Image: "docker.io/library/nginx:1.27"},
+	}}
+
+	dedupeServices(infra)
+
+	var names []string
+	for _, svc := range infra.Servers["atlas"].Services {
+		names = append(names, svc.Name+"="+svc.Image)
+	}
+	assert.Equal(t, []string{
+		"db=postgres:16", "db=mariadb:11", "cache=redis:7", "cache=redis:7",
+		"worker-a=myapp:latest", "worker-b=myapp:latest",
+		"web=nginx:1.27", "web-admin=docker.io/library/nginx:1.27",
+	}, names)
+}
+
+func TestDedupeKeepsWorkloadsSharingAnImage(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["k8s-default"] = &model.Server{Hostname: "k8s-default", Services: []*model.Service{
+		{Name: "web", Image: "ghcr.io/acme/app:1.2", Kind: "Deployment", Replicas: 3},
+		{Name: "web-worker", Image: "ghcr.io/acme/app:1.2", Kind: "Deployment", Replicas: 2},
+		{Name: "web", Image: "ghcr.io/acme/app:1.2", Kind: "StatefulSet", Replicas: 1},
+		{Name: "svc/web", Kind: "Service"},
+		{Name: "web-worker", Image: "ghcr.io/acme/app:1.2", Kind: "Deployment"},
+	}}
+	infra.Servers["swarm"] = &model.Server{Hostname: "swarm", Services: []*model.Service{
+		{Name: "app_web", Image: "ghcr.io/acme/app:1.2", Kind: "Replicated service"},
+		{Name: "app_web-cron", Image: "ghcr.io/acme/app:1.2", Kind: "Global service"},
+		{Name: "app", Image: "ghcr.io/acme/app:1.2", ContainerName: "app"},
+	}}
+
+	dedupeServices(infra)
+
+	var names []string
+	for _, svc := range infra.Servers["k8s-default"].Services {
+		names = append(names, svc.Kind+"/"+svc.Name)
+	}
+	assert.Equal(t, []string{"Deployment/web", "Deployment/web-worker", "StatefulSet/web", "Service/svc/web"}, names,
+		"only the same kind and name is folded")
+	assert.Len(t, infra.Servers["swarm"].Services, 3)
+}
+
+func TestImageRepo(t *testing.T) {
+	assert.Equal(t, "postgres", imageRepo("docker.io/library/postgres:16"))
+	assert.Equal(t, "grafana/grafana", imageRepo("grafana/grafana@sha256:abc"))
+	assert.Equal(t, "localhost:5000/app", imageRepo("localhost:5000/app:1.2"))
+}
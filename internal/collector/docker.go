@@ -0,0 +1,366 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
+)
+
+func init() {
+	Register(func() RegisteredCollector { return &DockerCollector{} })
+}
+
+// defaultDockerHost is where the Docker Engine listens when DOCKER_HOST is unset.
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerCollector collects running containers from a Docker Engine API,
+// over its unix socket or a tcp:// DOCKER_HOST.
+type DockerCollector struct {
+	Host     string // unix:///path/to/docker.sock or tcp://host:port
+	CertPath string // directory with ca.pem, cert.pem and key.pem for TLS
+	Server   string // hostname to assign containers to
+}
+
+func (dc *DockerCollector) Metadata() CollectorMetadata {
+	return CollectorMetadata{
+		Name:        "docker",
+		DisplayName: "Docker Engine",
+		Description: "Collects running containers from the Docker Engine API",
+		ConfigKey:   "docker",
+		DetectHint:  "/var/run/docker.sock",
+	}
+}
+
+func (dc *DockerCollector) Enabled(sources map[string]any) bool {
+	section, ok := sources["docker"].(map[string]any)
+	if !ok {
+		return false
+	}
+	if enabled, ok := section["enabled"].(bool); ok {
+		return enabled
+	}
+	host, _ := section["host"].(string)
+	return host != ""
+}
+
+func (dc *DockerCollector) Configure(section map[string]any) error {
+	if section != nil {
+		if v, ok := section["host"].(string); ok {
+			dc.Host = v
+		}
+		if v, ok := section["cert_path"].(string); ok {
+			dc.CertPath = util.ExpandPath(v)
+		}
+		if v, ok := section["server"].(string); ok {
+			dc.Server = strings.ToLower(v)
+		}
+	}
+	dc.Host, dc.CertPath = dockerEnvDefaults(dc.Host, dc.CertPath)
+	if dc.Server == "" {
+		dc.Server = dc.defaultServer()
+	}
+	return nil
+}
+
+// dockerEnvDefaults fills in the host and certificate directory the docker CLI
+// would use when they aren't configured.
+func dockerEnvDefaults(host, certPath string) (string, string) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+	if certPath == "" && os.Getenv("DOCKER_TLS_VERIFY") != "" {
+		certPath = os.Getenv("DOCKER_CERT_PATH")
+		if certPath == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				certPath = filepath.Join(home, ".docker")
+			}
+		}
+	}
+	return host, certPath
+}
+
+// defaultServer names the host the containers run on: this machine for the
+// local socket, the daemon's host for tcp://.
+func (dc *DockerCollector) defaultServer() string {
+	if u, err := url.Parse(dc.Host); err == nil && u.Scheme == "tcp" && u.Hostname() != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		name, _, _ = strings.Cut(name, ".")
+		return strings.ToLower(name)
+	}
+	return "docker"
+}
+
+func (dc *DockerCollector) Validate() []ValidationError {
+	return validateDockerHost("docker", dc.Host, dc.CertPath)
+}
+
+// validateDockerHost checks the host and cert_path of the config section key.
+func validateDockerHost(key, host, certPath string) []ValidationError {
+	u, err := url.Parse(host)
+	if err != nil || (u.Scheme != "unix" && u.Scheme != "tcp") {
+		return []ValidationError{{
+			Field:      "sources." + key + ".host",
+			Message:    fmt.Sprintf("unsupported Docker host %q", host),
+			Suggestion: "use unix:///var/run/docker.sock or tcp://host:2376",
+		}}
+	}
+
+	var errs []ValidationError
+	if u.Scheme == "unix" {
+		if _, err := os.Stat(u.Path); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources." + key + ".host",
+				Message:    fmt.Sprintf("Docker socket not found: %s", u.Path),
+				Suggestion: "start Docker, or point host at the daemon's socket or tcp:// address",
+			})
+		}
+	}
+	if certPath != "" {
+		for _, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+			if _, err := os.Stat(filepath.Join(certPath, name)); err != nil {
+				errs = append(errs, ValidationError{
+					Field:      "sources." + key + ".cert_path",
+					Message:    fmt.Sprintf("%s not found in %s", name, certPath),
+					Suggestion: "point cert_path at the directory holding the client certificates (DOCKER_CERT_PATH)",
+				})
+			}
+		}
+	}
+	return errs
+}
+
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Ports  []dockerPort      `json:"Ports"`
+	Labels map[string]string `json:"Labels"`
+	Mounts []dockerMount     `json:"Mounts"`
+
+	NetworkSettings struct {
+		Networks map[string]json.RawMessage `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type dockerPort struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+type dockerMount struct {
+	Type        string `json:"Type"` // bind, volume, tmpfs...
+	Name        string `json:"Name"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+}
+
+func (dc *DockerCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	containers, err := dc.getContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("getting containers: %w", err)
+	}
+
+	server, exists := infra.Servers[dc.Server]
+	if !exists {
+		server = &model.Server{
+			Hostname: dc.Server,
+			Label:    dc.Server,
+			Type:     model.ServerTypeLab,
+			Online:   true,
+		}
+		infra.Servers[dc.Server] = server
+	}
+	server.AddSource(dc.Host)
+
+	// Compose dependencies name services; the diagram shows containers.
+	byComposeService := make(map[string]string)
+	for _, c := range containers {
+		if project, svc := c.Labels["com.docker.compose.project"], c.Labels["com.docker.compose.service"]; svc != "" {
+			byComposeService[project+"/"+svc] = containerName(c.Names)
+		}
+	}
+
+	for _, c := range containers {
+		if c.State != "" && c.State != "running" {
+			continue
+		}
+
+		name := containerName(c.Names)
+		project := c.Labels["com.docker.compose.project"]
+
+		svc := &model.Service{
+			Name:           name,
+			Image:          c.Image,
+			Type:           detectServiceType(c.Image, name),
+			Category:       project,
+			ContainerName:  name,
+			Project:        project,
+			ComposeService: c.Labels["com.docker.compose.service"],
+		}
+
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			pm := model.PortMapping{
+				HostPort:      p.PublicPort,
+				ContainerPort: p.PrivatePort,
+				Protocol:      p.Type,
+			}
+			// Docker lists a port once per address family; a wildcard
+			// address is the same as none.
+			if p.IP != "0.0.0.0" && p.IP != "::" {
+				pm.HostIP = p.IP
+			}
+			if !hasPort(svc.Ports, pm) {
+				svc.Ports = append(svc.Ports, pm)
+			}
+		}
+
+		for netName := range c.NetworkSettings.Networks {
+			svc.Networks = append(svc.Networks, netName)
+		}
+		sort.Strings(svc.Networks)
+
+		for _, m := range c.Mounts {
+			source := m.Source
+			if m.Type == "volume" && m.Name != "" {
+				source = m.Name
+			}
+			svc.Volumes = append(svc.Volumes, model.VolumeMount{Source: source, Target: m.Destination})
+		}
+
+		for _, dep := range composeDependsOn(c.Labels["com.docker.compose.depends_on"]) {
+			if target, ok := byComposeService[project+"/"+dep]; ok {
+				dep = target
+			}
+			svc.DependsOn = append(svc.DependsOn, dep)
+		}
+
+		svc.AddSource(dc.Host)
+		server.AddService(svc)
+	}
+
+	return nil
+}
+
+// composeDependsOn parses the com.docker.compose.depends_on label
+// ("db:service_started:false,redis:service_healthy:true") into service names.
+func composeDependsOn(label string) []string {
+	var deps []string
+	for _, entry := range strings.Split(label, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if name != "" {
+			deps = append(deps, name)
+		}
+	}
+	return deps
+}
+
+func (dc *DockerCollector) getContainers(ctx context.Context) ([]dockerContainer, error) {
+	client, base, err := dc.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status, body, err := doHTTP(ctx, client, req)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("docker API returned %d: %s", status, strings.TrimSpace(string(body)))
+	}
+
+	var containers []dockerContainer
+	if err := json.Unmarshal(body, &containers); err != nil {
+		return nil, fmt.Errorf("parsing containers: %w", err)
+	}
+	return containers, nil
+}
+
+// client returns an HTTP client that reaches the daemon and the base URL to
+// send requests to.
+func (dc *DockerCollector) client() (*http.Client, string, error) {
+	return dockerClient(dc.Host, dc.CertPath)
+}
+
+// dockerClient returns an HTTP client that reaches the daemon at host and
+// the base URL to send requests to.
+func dockerClient(host, certPath string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	transport := &http.Transport{}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		// The host part is ignored when dialing the socket.
+		return &http.Client{Transport: transport, Timeout: 30 * time.Second}, "http://docker", nil
+	case "tcp":
+		scheme := "http"
+		if certPath != "" {
+			tlsConfig, err := dockerTLSConfig(certPath)
+			if err != nil {
+				return nil, "", err
+			}
+			transport.TLSClientConfig = tlsConfig
+			scheme = "https"
+		}
+		return &http.Client{Transport: transport, Timeout: 30 * time.Second}, scheme + "://" + u.Host, nil
+	}
+	return nil, "", fmt.Errorf("unsupported docker host %q (use unix:// or tcp://)", host)
+}
+
+// dockerTLSConfig loads the client certificates the docker CLI uses for a
+// TLS-protected daemon.
+func dockerTLSConfig(certPath string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("loading docker client certificate: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("reading docker CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", filepath.Join(certPath, "ca.pem"))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDockerSocket serves the fixture as the Docker Engine API on a unix
+// socket and returns its path.
+func fakeDockerSocket(t *testing.T, fixture string) string {
+	t.Helper()
+	data, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.Error(w, `{"message":"page not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return socket
+}
+
+func TestDockerCollector(t *testing.T) {
+	socket := fakeDockerSocket(t, "../../testdata/docker/containers.json")
+
+	dc := &DockerCollector{}
+	require.NoError(t, dc.Configure(map[string]any{
+		"host":   "unix://" + socket,
+		"server": "Atlas",
+	}))
+	assert.Empty(t, dc.Validate())
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, dc.Collect(context.Background(), infra))
+
+	server := infra.Servers["atlas"]
+	require.NotNil(t, server)
+	require.Len(t, server.Services, 3, "stopped containers are skipped")
+
+	app := server.Services[0]
+	assert.Equal(t, "nextcloud-app-1", app.Name)
+	assert.Equal(t, "nextcloud", app.Project)
+	assert.Equal(t, "app", app.ComposeService)
+	assert.Equal(t, "nextcloud", app.Category)
+	assert.Equal(t, []model.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}, app.Ports)
+	assert.Equal(t, []string{"nextcloud_default", "proxy"}, app.Networks)
+	assert.Equal(t, []model.VolumeMount{
+		{Source: "nextcloud_html", Target: "/var/www/html"},
+		{Source: "/srv/nextcloud/config", Target: "/var/www/html/config"},
+	}, app.Volumes)
+	assert.Equal(t, []string{"nextcloud-db-1"}, app.DependsOn)
+	assert.Equal(t, []model.Source{{Location: "unix://" + socket}}, app.Sources)
+
+	db := server.Services[1]
+	assert.Equal(t, model.ServiceTypeDatabase, db.Type)
+	assert.Empty(t, db.Ports, "unpublished ports are not shown")
+}
+
+func TestDockerCollectorValidate(t *testing.T) {
+	dc := &DockerCollector{}
+	require.NoError(t, dc.Configure(map[string]any{"host": "unix:///nonexistent/docker.sock"}))
+	errs := dc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.docker.host", errs[0].Field)
+
+	dc = &DockerCollector{}
+	require.NoError(t, dc.Configure(map[string]any{"host": "ssh://user@host"}))
+	assert.Len(t, dc.Validate(), 1)
+}
+
+func TestDockerCollectorDefaults(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://Docker01.lan:2375")
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+
+	dc := &DockerCollector{}
+	require.NoError(t, dc.Configure(map[string]any{"enabled": true}))
+	assert.Equal(t, "tcp://Docker01.lan:2375", dc.Host)
+	assert.Equal(t, "docker01.lan", dc.Server)
+
+	_, base, err := dc.client()
+	require.NoError(t, err)
+	assert.Equal(t, "http://Docker01.lan:2375", base)
+}
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -20,17 +21,23 @@ type KubernetesCollector struct {
 	Kubeconfig string
 	Context    string
 	Namespaces []string
-	// TestData paths for testing (bypasses kubectl)
-	TestPods      string
-	TestServices  string
-	TestIngresses string
+	Clusters   []KubernetesCluster // several clusters instead of the above
+	cluster    string              // name of the cluster being collected
+}
+
+// KubernetesCluster is one entry of sources.kubernetes.clusters.
+type KubernetesCluster struct {
+	Name       string // group name in the diagram (default: the context)
+	Kubeconfig string
+	Context    string
+	Namespaces []string
 }
 
 func (kc *KubernetesCollector) Metadata() CollectorMetadata {
 	return CollectorMetadata{
 		Name:        "kubernetes",
 		DisplayName: "Kubernetes",
-		Description: "Collects pods, services, and ingresses from Kubernetes clusters",
+		Description: "Collects nodes, workloads, services, and ingresses from Kubernetes clusters",
 		ConfigKey:   "kubernetes",
 		DetectHint:  "kubectl",
 	}
@@ -51,16 +58,54 @@ func (kc *KubernetesCollector) Configure(section map[string]any) error {
 	if v, ok := section["context"].(string); ok {
 		kc.Context = v
 	}
-	if v, ok := section["namespaces"].([]any); ok {
-		for _, ns := range v {
-			if s, ok := ns.(string); ok {
-				kc.Namespaces = append(kc.Namespaces, s)
+	kc.Namespaces = append(kc.Namespaces, stringList(section["namespaces"])...)
+	if v, ok := section["clusters"].([]any); ok {
+		// Namespaces are named after their cluster, sanitized: two clusters
+		// named alike ("prod.eu" and "prod-eu") would be drawn as one.
+		ids := make(map[string]string)
+		for i, item := range v {
+			entry, ok := item.(map[string]any)
+			if !ok {
+				return fmt.Errorf("clusters[%d]: expected a mapping", i)
 			}
+			cluster := KubernetesCluster{
+				Name:       toString(entry["name"]),
+				Kubeconfig: util.ExpandPath(toString(entry["kubeconfig"])),
+				Context:    toString(entry["context"]),
+				Namespaces: stringList(entry["namespaces"]),
+			}
+			if cluster.Name == "" {
+				cluster.Name = cluster.Context
+			}
+			if cluster.Name == "" {
+				cluster.Name = fmt.Sprintf("cluster-%d", i+1)
+			}
+			id := util.SanitizeID(cluster.Name)
+			if other, ok := ids[id]; ok {
+				if other == cluster.Name {
+					return fmt.Errorf("clusters[%d]: duplicate cluster name %q", i, cluster.Name)
+				}
+				return fmt.Errorf("clusters[%d]: cluster names %q and %q both draw as %q; give each cluster a distinct name", i, other, cluster.Name, id)
+			}
+			ids[id] = cluster.Name
+			kc.Clusters = append(kc.Clusters, cluster)
 		}
 	}
 	return nil
 }
 
+// stringList reads a YAML list of strings, ignoring anything else.
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
 func (kc *KubernetesCollector) Validate() []ValidationError {
 	var errs []ValidationError
 	if kc.Kubeconfig != "" {
@@ -72,6 +117,24 @@ func (kc *KubernetesCollector) Validate() []ValidationError {
 			})
 		}
 	}
+	for i, cluster := range kc.Clusters {
+		if cluster.Kubeconfig != "" {
+			if _, err := os.Stat(cluster.Kubeconfig); err != nil {
+				errs = append(errs, ValidationError{
+					Field:      fmt.Sprintf("sources.kubernetes.clusters[%d].kubeconfig", i),
+					Message:    fmt.Sprintf("file not found: %s", cluster.Kubeconfig),
+					Suggestion: "check the path to your kubeconfig file",
+				})
+			}
+		}
+	}
+	if len(kc.Clusters) > 0 && (kc.Kubeconfig != "" || kc.Context != "") {
+		errs = append(errs, ValidationError{
+			Field:      "sources.kubernetes",
+			Message:    "kubeconfig and context are ignored when clusters is set",
+			Suggestion: "move them into a clusters entry",
+		})
+	}
 	if _, err := exec.LookPath("kubectl"); err != nil {
 		errs = append(errs, ValidationError{
 			Field:      "sources.kubernetes",
@@ -96,12 +159,34 @@ type k8sPod struct {
 }
 
 type k8sMeta struct {
-	Name      string            `json:"name"`
-	Namespace string            `json:"namespace"`
-	Labels    map[string]string `json:"labels"`
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	Labels          map[string]string `json:"labels"`
+	OwnerReferences []k8sOwnerRef     `json:"ownerReferences"`
+}
+
+type k8sOwnerRef struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Controller bool   `json:"controller"`
+}
+
+// controller returns the object's managing owner: the reference marked as
+// controller, or else the first one.
+func (m k8sMeta) controller() (k8sOwnerRef, bool) {
+	for _, ref := range m.OwnerReferences {
+		if ref.Controller {
+			return ref, true
+		}
+	}
+	if len(m.OwnerReferences) > 0 {
+		return m.OwnerReferences[0], true
+	}
+	return k8sOwnerRef{}, false
 }
 
 type k8sPodSpec struct {
+	NodeName   string         `json:"nodeName"`
 	Containers []k8sContainer `json:"containers"`
 }
 
@@ -112,6 +197,7 @@ type k8sContainer struct {
 }
 
 type k8sPort struct {
+	Name          string `json:"name"`
 	ContainerPort int    `json:"containerPort"`
 	Protocol      string `json:"protocol"`
 }
@@ -133,10 +219,24 @@ type k8sServiceSpec struct {
 }
 
 type k8sServicePort struct {
-	Port       int    `json:"port"`
-	TargetPort int    `json:"targetPort"`
-	NodePort   int    `json:"nodePort"`
-	Protocol   string `json:"protocol"`
+	Name       string         `json:"name"`
+	Port       int            `json:"port"`
+	TargetPort k8sIntOrString `json:"targetPort"`
+	NodePort   int            `json:"nodePort"`
+	Protocol   string         `json:"protocol"`
+}
+
+// k8sIntOrString is a port given either as a number or by name.
+type k8sIntOrString struct {
+	IntVal int
+	StrVal string
+}
+
+func (p *k8sIntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &p.StrVal)
+	}
+	return json.Unmarshal(data, &p.IntVal)
 }
 
 // k8sIngressList is the JSON structure returned by kubectl get ingress.
@@ -150,7 +250,8 @@ type k8sIngress struct {
 }
 
 type k8sIngressSpec struct {
-	Rules []k8sIngressRule `json:"rules"`
+	DefaultBackend *k8sIngressBackend `json:"defaultBackend"`
+	Rules          []k8sIngressRule   `json:"rules"`
 }
 
 type k8sIngressRule struct {
@@ -174,118 +275,176 @@ type k8sIngressBackend struct {
 type k8sBackendService struct {
 	Name string `json:"name"`
 	Port struct {
-		Number int `json:"number"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
 	} `json:"port"`
 }
 
-func (kc *KubernetesCollector) Collect(infra *model.Infrastructure) error {
-	pods, err := kc.getPods()
+// k8sNodeList is the JSON structure returned by kubectl get nodes.
+type k8sNodeList struct {
+	Items []k8sNode `json:"items"`
+}
+
+type k8sNode struct {
+	Metadata k8sMeta `json:"metadata"`
+	Status   struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		NodeInfo struct {
+			OSImage        string `json:"osImage"`
+			KubeletVersion string `json:"kubeletVersion"`
+		} `json:"nodeInfo"`
+	} `json:"status"`
+}
+
+// roles lists the node's roles from its node-role.kubernetes.io/<role>
+// labels, e.g. "control-plane".
+func (n k8sNode) roles() []string {
+	var roles []string
+	for _, label := range sortedKeys(n.Metadata.Labels) {
+		if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// ready reports whether the node's Ready condition is true.
+func (n k8sNode) ready() bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+func (kc *KubernetesCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	if len(kc.Clusters) == 0 {
+		return kc.collectCluster(ctx, infra)
+	}
+	for _, cluster := range kc.Clusters {
+		cc := &KubernetesCollector{
+			Kubeconfig: cluster.Kubeconfig,
+			Context:    cluster.Context,
+			Namespaces: cluster.Namespaces,
+			cluster:    cluster.Name,
+		}
+		if err := cc.collectCluster(ctx, infra); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+// collectCluster collects one cluster. Its namespaces become servers of
+// their own, grouped under the cluster's name when there are several.
+func (kc *KubernetesCollector) collectCluster(ctx context.Context, infra *model.Infrastructure) error {
+	pods, err := kc.getPods(ctx)
 	if err != nil {
 		return fmt.Errorf("getting pods: %w", err)
 	}
 
-	services, err := kc.getServices()
+	services, err := kc.getServices(ctx)
 	if err != nil {
 		return fmt.Errorf("getting services: %w", err)
 	}
 
-	ingresses, err := kc.getIngresses()
+	ingresses, err := kc.getIngresses(ctx)
 	if err != nil {
 		return fmt.Errorf("getting ingresses: %w", err)
 	}
 
-	// Build a port map from services: svcName@ns → port
-	svcPorts := make(map[string]int)
-	for _, svc := range services.Items {
-		if len(svc.Spec.Ports) > 0 {
-			key := svc.Metadata.Name + "@" + svc.Metadata.Namespace
-			port := svc.Spec.Ports[0].Port
-			if svc.Spec.Ports[0].NodePort > 0 {
-				port = svc.Spec.Ports[0].NodePort
-			}
-			svcPorts[key] = port
-		}
-	}
+	owners := kc.getOwners(ctx)
 
-	// Build ingress map: svcName@ns → host
-	ingressHosts := make(map[string]string)
-	for _, ing := range ingresses.Items {
-		for _, rule := range ing.Spec.Rules {
-			if rule.HTTP == nil {
-				continue
-			}
-			for _, path := range rule.HTTP.Paths {
-				key := path.Backend.Service.Name + "@" + ing.Metadata.Namespace
-				ingressHosts[key] = rule.Host
-			}
+	// Nodes are cluster-scoped: a user confined to namespaces may not list
+	// them, and the workloads are drawn without their placement.
+	nodes, err := kc.getNodes(ctx)
+	if err != nil {
+		if kc.cluster != "" {
+			err = fmt.Errorf("cluster %s: %w", kc.cluster, err)
 		}
+		statsFrom(ctx).warn("skipping nodes: %v", err)
+		nodes = &k8sNodeList{}
 	}
 
-	// Group pods by namespace → create a server per namespace
-	nsPods := make(map[string][]k8sPod)
+	// Group pods by namespace, then by the workload that manages them
+	nsWorkloads := make(map[string][]*k8sPodGroup)
+	groups := make(map[string]*k8sPodGroup)
 	for _, pod := range pods.Items {
-		if pod.Status.Phase != "Running" {
-			continue
-		}
 		ns := pod.Metadata.Namespace
 		if len(kc.Namespaces) > 0 && !containsStr(kc.Namespaces, ns) {
 			continue
 		}
-		nsPods[ns] = append(nsPods[ns], pod)
-	}
+		kind, name := owners.workloadOf(pod)
+		// Finished pods are all a Job or CronJob usually has to show.
+		batch := kind == "Job" || kind == "CronJob"
+		if pod.Status.Phase != "Running" && !(batch && pod.Status.Phase == "Succeeded") {
+			continue
+		}
 
-	// Deduplicate pods by app label (deployment pods share the same label)
-	for ns, podList := range nsPods {
-		serverName := fmt.Sprintf("k8s-%s", ns)
+		key := ns + "/" + kind + "/" + name
+		group, ok := groups[key]
+		if !ok {
+			group = &k8sPodGroup{kind: kind, name: name, pod: pod, nodes: make(map[string]int)}
+			groups[key] = group
+			nsWorkloads[ns] = append(nsWorkloads[ns], group)
+		}
+		if pod.Status.Phase == "Running" {
+			group.running++
+		}
+		if pod.Spec.NodeName != "" {
+			group.nodes[pod.Spec.NodeName]++
+		}
+	}
 
-		server, exists := infra.Servers[serverName]
-		if !exists {
-			server = &model.Server{
-				Hostname: serverName,
-				Label:    fmt.Sprintf("k8s/%s", ns),
-				Type:     model.ServerTypeCluster,
-				Online:   true,
-			}
-			infra.Servers[serverName] = server
+	objs := &k8sObjects{cluster: kc.cluster}
+	for _, ns := range sortedKeys(nsWorkloads) {
+		for _, group := range nsWorkloads[ns] {
+			objs.workloads = append(objs.workloads, group.workload())
 		}
+	}
+	for _, svc := range services.Items {
+		if len(kc.Namespaces) == 0 || containsStr(kc.Namespaces, svc.Metadata.Namespace) {
+			objs.services = append(objs.services, svc)
+		}
+	}
+	for _, ing := range ingresses.Items {
+		if len(kc.Namespaces) == 0 || containsStr(kc.Namespaces, ing.Metadata.Namespace) {
+			objs.ingresses = append(objs.ingresses, ing)
+		}
+	}
 
-		seen := make(map[string]bool)
-		for _, pod := range podList {
-			// Use app label as service name for dedup, fall back to container name
-			for _, container := range pod.Spec.Containers {
-				svcName := container.Name
-				if appLabel, ok := pod.Metadata.Labels["app"]; ok {
-					svcName = appLabel
-				}
+	for _, ns := range objs.build(infra) {
+		namespaceServer(infra, kc.cluster, ns).AddSource(kc.location("namespace " + ns))
+	}
 
-				if seen[svcName] {
+	// Nodes are servers of their own, named after the machine so that they
+	// correlate with what other collectors know of it. Each workload is
+	// linked to the nodes its pods were scheduled on.
+	listed := make(map[string]string) // node name → server
+	for _, node := range nodes.Items {
+		listed[node.Metadata.Name] = kc.nodeServer(infra, node).Hostname
+	}
+	for _, ns := range sortedKeys(nsWorkloads) {
+		for _, group := range nsWorkloads[ns] {
+			for _, node := range sortedKeys(group.nodes) {
+				server, ok := listed[node]
+				if !ok {
 					continue
 				}
-				seen[svcName] = true
-
-				svc := &model.Service{
-					Name:  svcName,
-					Image: container.Image,
-					Type:  detectServiceType(container.Image, svcName),
-				}
-
-				// Add port from k8s service if available
-				key := svcName + "@" + ns
-				if port, ok := svcPorts[key]; ok {
-					svc.Ports = append(svc.Ports, model.PortMapping{
-						HostPort:      port,
-						ContainerPort: port,
-						Protocol:      "tcp",
-					})
-				} else if len(container.Ports) > 0 {
-					svc.Ports = append(svc.Ports, model.PortMapping{
-						ContainerPort: container.Ports[0].ContainerPort,
-						Protocol:      strings.ToLower(container.Ports[0].Protocol),
-					})
-				}
-
-				svc.Category = "kubernetes"
-				server.AddService(svc)
+				infra.Connections = append(infra.Connections, model.Connection{
+					From:  namespaceServerName(kc.cluster, ns) + "/" + group.name,
+					To:    server,
+					Label: podsLabel(group.nodes[node]),
+					Style: "dashed",
+				})
 			}
 		}
 	}
@@ -293,49 +452,108 @@ func (kc *KubernetesCollector) Collect(infra *model.Infrastructure) error {
 	return nil
 }
 
-func (kc *KubernetesCollector) getPods() (*k8sPodList, error) {
-	var result k8sPodList
-	if kc.TestPods != "" {
-		if err := loadJSONFile(kc.TestPods, &result); err != nil {
-			return nil, err
+// nodeServer adds a node as a server of type cluster: online when Ready,
+// reachable at its addresses, tagged with its roles.
+func (kc *KubernetesCollector) nodeServer(infra *model.Infrastructure, node k8sNode) *model.Server {
+	name := strings.ToLower(node.Metadata.Name)
+	server, exists := infra.Servers[name]
+	if !exists {
+		server = &model.Server{
+			Hostname: name,
+			Label:    name,
+			Type:     model.ServerTypeCluster,
+			Cluster:  kc.cluster,
 		}
-		return &result, nil
+		infra.Servers[name] = server
 	}
-	if err := kc.kubectlGet(&result, "get", "pods", "-A", "-o", "json"); err != nil {
+	server.Online = node.ready()
+	server.OS = node.Status.NodeInfo.OSImage
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case "InternalIP":
+			server.AddAddress(addr.Address)
+		case "ExternalIP":
+			server.PublicIP = addr.Address
+		case "Hostname", "InternalDNS", "ExternalDNS":
+			server.AddAlias(strings.ToLower(addr.Address))
+		}
+	}
+	for _, role := range node.roles() {
+		server.Tags = append(server.Tags, "role="+role)
+	}
+	if !server.Online {
+		server.Tags = append(server.Tags, "status=NotReady")
+	}
+	server.AddSource(kc.location("node " + node.Metadata.Name))
+	return server
+}
+
+func podsLabel(n int) string {
+	if n == 1 {
+		return "1 pod"
+	}
+	return fmt.Sprintf("%d pods", n)
+}
+
+func (kc *KubernetesCollector) getPods(ctx context.Context) (*k8sPodList, error) {
+	var result k8sPodList
+	if err := kc.kubectlGet(ctx, &result, "get", "pods", "-A", "-o", "json"); err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (kc *KubernetesCollector) getServices() (*k8sServiceList, error) {
+func (kc *KubernetesCollector) getServices(ctx context.Context) (*k8sServiceList, error) {
 	var result k8sServiceList
-	if kc.TestServices != "" {
-		if err := loadJSONFile(kc.TestServices, &result); err != nil {
-			return nil, err
-		}
-		return &result, nil
-	}
-	if err := kc.kubectlGet(&result, "get", "svc", "-A", "-o", "json"); err != nil {
+	if err := kc.kubectlGet(ctx, &result, "get", "svc", "-A", "-o", "json"); err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (kc *KubernetesCollector) getIngresses() (*k8sIngressList, error) {
+func (kc *KubernetesCollector) getIngresses(ctx context.Context) (*k8sIngressList, error) {
 	var result k8sIngressList
-	if kc.TestIngresses != "" {
-		if err := loadJSONFile(kc.TestIngresses, &result); err != nil {
-			return nil, err
+	if err := kc.kubectlGet(ctx, &result, "get", "ingress", "-A", "-o", "json"); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// getOwners lists the ReplicaSets and Jobs that sit between pods and the
+// Deployments and CronJobs managing them. Listing them across namespaces
+// takes more rights than listing pods: without them, a warning is shown and
+// workloadOf names Deployments after their pods' ReplicaSets, and Jobs after
+// themselves.
+func (kc *KubernetesCollector) getOwners(ctx context.Context) k8sOwners {
+	owners := make(k8sOwners)
+	for _, res := range []struct{ kind, resource string }{
+		{"ReplicaSet", "replicasets"},
+		{"Job", "jobs"},
+	} {
+		var result k8sObjectList
+		if err := kc.kubectlGet(ctx, &result, "get", res.resource, "-A", "-o", "json"); err != nil {
+			if kc.cluster != "" {
+				err = fmt.Errorf("cluster %s: %w", kc.cluster, err)
+			}
+			statsFrom(ctx).warn("skipping pod owners: %v", err)
+			continue
+		}
+		for _, item := range result.Items {
+			owners[item.Metadata.Namespace+"/"+res.kind+"/"+item.Metadata.Name] = item.Metadata
 		}
-		return &result, nil
 	}
-	if err := kc.kubectlGet(&result, "get", "ingress", "-A", "-o", "json"); err != nil {
+	return owners
+}
+
+func (kc *KubernetesCollector) getNodes(ctx context.Context) (*k8sNodeList, error) {
+	var result k8sNodeList
+	if err := kc.kubectlGet(ctx, &result, "get", "nodes", "-o", "json"); err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (kc *KubernetesCollector) kubectlGet(result any, args ...string) error {
+func (kc *KubernetesCollector) kubectlGet(ctx context.Context, result any, args ...string) error {
 	cmdArgs := args
 	if kc.Kubeconfig != "" {
 		cmdArgs = append([]string{"--kubeconfig", kc.Kubeconfig}, cmdArgs...)
@@ -344,8 +562,7 @@ func (kc *KubernetesCollector) kubectlGet(result any, args ...string) error {
 		cmdArgs = append([]string{"--context", kc.Context}, cmdArgs...)
 	}
 
-	cmd := exec.Command("kubectl", cmdArgs...)
-	out, err := cmd.Output()
+	out, err := runCommand(ctx, nil, "kubectl", cmdArgs...)
 	if err != nil {
 		return fmt.Errorf("kubectl %s: %w", strings.Join(args, " "), err)
 	}
@@ -356,6 +573,116 @@ func (kc *KubernetesCollector) kubectlGet(result any, args ...string) error {
 	return nil
 }
 
+// location describes where a namespace or node ("namespace default", "node
+// k3s-server") was read from, for provenance.
+func (kc *KubernetesCollector) location(object string) string {
+	switch {
+	case kc.Context != "":
+		return fmt.Sprintf("context %s, %s", kc.Context, object)
+	case kc.cluster != "":
+		return fmt.Sprintf("cluster %s, %s", kc.cluster, object)
+	}
+	return object
+}
+
+// k8sObjectList is any kubectl list of which only the metadata is needed.
+type k8sObjectList struct {
+	Items []struct {
+		Metadata k8sMeta `json:"metadata"`
+	} `json:"items"`
+}
+
+// k8sOwners indexes ReplicaSets and Jobs by "namespace/Kind/name".
+type k8sOwners map[string]k8sMeta
+
+// k8sPodGroup is the pods of one workload: the first one seen stands for
+// them all.
+type k8sPodGroup struct {
+	kind    string
+	name    string
+	pod     k8sPod
+	running int
+	nodes   map[string]int // pods per node
+}
+
+// workload describes the pods as the workload they belong to, with the
+// running ones as its replicas.
+func (g *k8sPodGroup) workload() k8sWorkload {
+	wl := k8sWorkload{
+		Kind:     g.kind,
+		Metadata: k8sMeta{Name: g.name, Namespace: g.pod.Metadata.Namespace},
+	}
+	running := g.running
+	wl.Spec.Replicas = &running
+	template := k8sPodTemplate{Metadata: g.pod.Metadata, Spec: g.pod.Spec}
+	if g.kind == "CronJob" {
+		wl.Spec.JobTemplate.Spec.Template = template
+	} else {
+		wl.Spec.Template = template
+	}
+	return wl
+}
+
+// workloadOf follows a pod's ownerReferences to the workload managing it:
+// through its ReplicaSet to a Deployment, through its Job to a CronJob, or
+// directly to a StatefulSet, DaemonSet or Job. A pod nobody owns is its own
+// workload. When the ReplicaSet isn't listed, its Deployment is the
+// ReplicaSet name without the pod-template-hash suffix.
+func (o k8sOwners) workloadOf(pod k8sPod) (kind, name string) {
+	ns := pod.Metadata.Namespace
+	kind, name = "Pod", pod.Metadata.Name
+	meta := pod.Metadata
+	for depth := 0; depth < 4; depth++ {
+		ref, ok := meta.controller()
+		if !ok {
+			return kind, name
+		}
+		kind, name = ref.Kind, ref.Name
+		owner, ok := o[ns+"/"+kind+"/"+name]
+		if !ok {
+			hash := pod.Metadata.Labels["pod-template-hash"]
+			if kind == "ReplicaSet" && hash != "" && strings.HasSuffix(name, "-"+hash) {
+				return "Deployment", strings.TrimSuffix(name, "-"+hash)
+			}
+			return kind, name
+		}
+		meta = owner
+	}
+	return kind, name
+}
+
+// namespaceServer returns the server standing for a namespace, creating it
+// if needed. Namespaces of a named cluster are prefixed with its name, so
+// that two clusters' "default" namespaces stay apart.
+func namespaceServer(infra *model.Infrastructure, cluster, ns string) *model.Server {
+	serverName := namespaceServerName(cluster, ns)
+	server, exists := infra.Servers[serverName]
+	if !exists {
+		server = &model.Server{
+			Hostname: serverName,
+			Label:    fmt.Sprintf("k8s/%s", ns),
+			Type:     model.ServerTypeCluster,
+			Cluster:  cluster,
+			Online:   true,
+		}
+		if cluster != "" {
+			server.Label = fmt.Sprintf("k8s/%s/%s", cluster, ns)
+		}
+		infra.Servers[serverName] = server
+	}
+	return server
+}
+
+// namespaceServerName names the server of a namespace. Namespace names
+// can't hold an underscore, so the one after the cluster's name keeps
+// cluster "a-b" with namespace "c" apart from cluster "a" with "b-c".
+func namespaceServerName(cluster, ns string) string {
+	if cluster == "" {
+		return fmt.Sprintf("k8s-%s", ns)
+	}
+	return fmt.Sprintf("k8s-%s_%s", util.SanitizeID(cluster), ns)
+}
+
 func loadJSONFile(path string, result any) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
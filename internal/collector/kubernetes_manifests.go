@@ -0,0 +1,261 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	Register(func() RegisteredCollector { return &KubernetesManifestsCollector{} })
+}
+
+// KubernetesManifestsCollector reads workloads from Kubernetes manifests on
+// disk, such as a GitOps repository or `kustomize build`/`helm template`
+// output, without access to a cluster.
+type KubernetesManifestsCollector struct {
+	Paths      []string // manifest files or directories of manifests
+	Namespace  string   // namespace of objects that don't set one (default: "default")
+	Namespaces []string // only keep these namespaces (empty = all)
+}
+
+func (mc *KubernetesManifestsCollector) Metadata() CollectorMetadata {
+	return CollectorMetadata{
+		Name:        "kubernetes_manifests",
+		DisplayName: "Kubernetes Manifests",
+		Description: "Reads workloads, services and ingresses from Kubernetes YAML manifests",
+		ConfigKey:   "kubernetes_manifests",
+		DetectHint:  "kustomization.yaml",
+	}
+}
+
+func (mc *KubernetesManifestsCollector) Enabled(sources map[string]any) bool {
+	section, ok := sources["kubernetes_manifests"].(map[string]any)
+	if !ok {
+		return false
+	}
+	paths, ok := section["paths"].([]any)
+	return ok && len(paths) > 0
+}
+
+func (mc *KubernetesManifestsCollector) Configure(section map[string]any) error {
+	if section == nil {
+		return nil
+	}
+	if v, ok := section["paths"].([]any); ok {
+		for _, p := range v {
+			mc.Paths = append(mc.Paths, util.ExpandPath(toString(p)))
+		}
+	}
+	if v, ok := section["namespace"].(string); ok {
+		mc.Namespace = v
+	}
+	mc.Namespaces = append(mc.Namespaces, stringList(section["namespaces"])...)
+	return nil
+}
+
+func (mc *KubernetesManifestsCollector) Validate() []ValidationError {
+	var errs []ValidationError
+	for i, p := range mc.Paths {
+		if _, err := os.Stat(p); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      fmt.Sprintf("sources.kubernetes_manifests.paths[%d]", i),
+				Message:    fmt.Sprintf("manifest path not found: %s", p),
+				Suggestion: "point to a YAML manifest, a directory of manifests, or saved `kustomize build`/`helm template` output",
+			})
+		}
+	}
+	return errs
+}
+
+// k8sWorkloadKinds are the kinds modeled as services.
+var k8sWorkloadKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"Job":         true,
+	"CronJob":     true,
+}
+
+func (mc *KubernetesManifestsCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	files, err := mc.manifestFiles()
+	if err != nil {
+		return err
+	}
+
+	stats := statsFrom(ctx)
+	objs := &k8sObjects{files: make(map[string]string)}
+	for _, path := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		docs, err := readManifests(path)
+		if err != nil {
+			stats.warn("skipping %s: %v", path, err)
+			continue
+		}
+		stats.fileParsed()
+		for _, doc := range docs {
+			if err := mc.addObject(objs, doc, path); err != nil {
+				stats.warn("skipping object in %s: %v", path, err)
+			}
+		}
+	}
+
+	objs.build(infra)
+	return nil
+}
+
+// manifestFiles expands the configured paths into files. Directories are
+// searched recursively for .yaml, .yml and .json files, skipping hidden ones.
+func (mc *KubernetesManifestsCollector) manifestFiles() ([]string, error) {
+	var files []string
+	for _, p := range mc.Paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // skip inaccessible paths
+			}
+			if path != p && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch filepath.Ext(info.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !info.IsDir() {
+					found = append(found, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// readManifests decodes every document of a YAML (or JSON) stream. The items
+// of a List are returned as documents of their own.
+func readManifests(path string) ([]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []map[string]any
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc map[string]any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue // empty document, e.g. a helm template rendering nothing
+		}
+		if toString(doc["kind"]) == "List" {
+			items, _ := doc["items"].([]any)
+			for _, item := range items {
+				if m, ok := item.(map[string]any); ok {
+					docs = append(docs, m)
+				}
+			}
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// addObject records a manifest document if it is a kind we model. Objects
+// without a namespace get the configured default, as `kubectl apply` would.
+// An object already read from another file is refused.
+func (mc *KubernetesManifestsCollector) addObject(objs *k8sObjects, doc map[string]any, path string) error {
+	kind := toString(doc["kind"])
+	if !k8sWorkloadKinds[kind] && kind != "Service" && kind != "Ingress" {
+		return nil
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	var meta struct {
+		Metadata k8sMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	if meta.Metadata.Name == "" {
+		return fmt.Errorf("%s without a name", kind)
+	}
+	ns := meta.Metadata.Namespace
+	if ns == "" {
+		ns = mc.Namespace
+	}
+	if ns == "" {
+		ns = "default"
+	}
+	if len(mc.Namespaces) > 0 && !containsStr(mc.Namespaces, ns) {
+		return nil
+	}
+	// Kustomize bases and the overlays patching them hold the same object;
+	// only `kustomize build` can merge them, so the first one read stands.
+	key := kind + "/" + ns + "/" + meta.Metadata.Name
+	if first, ok := objs.files[key]; ok {
+		return fmt.Errorf("%s/%s in namespace %s is already defined in %s (render kustomize overlays with `kustomize build` first)", kind, meta.Metadata.Name, ns, first)
+	}
+
+	switch kind {
+	case "Service":
+		var svc k8sService
+		if err := json.Unmarshal(raw, &svc); err != nil {
+			return fmt.Errorf("Service/%s: %w", meta.Metadata.Name, err)
+		}
+		svc.Metadata.Namespace = ns
+		objs.services = append(objs.services, svc)
+	case "Ingress":
+		var ing k8sIngress
+		if err := json.Unmarshal(raw, &ing); err != nil {
+			return fmt.Errorf("Ingress/%s: %w", meta.Metadata.Name, err)
+		}
+		ing.Metadata.Namespace = ns
+		objs.ingresses = append(objs.ingresses, ing)
+	default:
+		var wl k8sWorkload
+		if err := json.Unmarshal(raw, &wl); err != nil {
+			return fmt.Errorf("%s/%s: %w", kind, meta.Metadata.Name, err)
+		}
+		wl.Metadata.Namespace = ns
+		objs.workloads = append(objs.workloads, wl)
+	}
+	objs.files[key] = path
+	return nil
+}
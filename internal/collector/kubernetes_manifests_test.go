@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubernetesManifestsHelmTemplate(t *testing.T) {
+	mc := &KubernetesManifestsCollector{}
+	require.NoError(t, mc.Configure(map[string]any{
+		"paths":     []any{"../../testdata/kubernetes/manifests/helm-template.yaml"},
+		"namespace": "shop",
+	}))
+	assert.Empty(t, mc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, mc.Collect(withStats(context.Background(), stats), infra))
+	assert.Equal(t, 1, stats.FilesParsed)
+	assert.Empty(t, stats.Warnings)
+
+	// Objects without a namespace land in the configured one.
+	require.Equal(t, []string{"k8s-shop"}, sortedKeys(infra.Servers))
+	server := infra.Servers["k8s-shop"]
+	assert.Equal(t, model.ServerTypeCluster, server.Type)
+	require.Len(t, server.Services, 5)
+
+	web := server.Services[0]
+	assert.Equal(t, "shop-web", web.Name)
+	assert.Equal(t, "ghcr.io/example/shop:1.4.2", web.Image)
+	assert.Equal(t, "Deployment", web.Kind)
+	assert.Equal(t, 3, web.Replicas)
+	assert.Equal(t, []model.PortMapping{{ContainerPort: 8080, Protocol: "tcp"}}, web.Ports)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/kubernetes/manifests/helm-template.yaml Deployment/shop-web"}}, web.Sources)
+
+	db := server.Services[1]
+	assert.Equal(t, "shop-db", db.Name)
+	assert.Equal(t, model.ServiceTypeDatabase, db.Type)
+
+	// Every port of the Service, named target ports resolved.
+	webSvc := server.Services[2]
+	assert.Equal(t, "svc/shop-web", webSvc.Name)
+	assert.Equal(t, "Service", webSvc.Kind)
+	assert.Equal(t, []model.PortMapping{
+		{HostPort: 80, ContainerPort: 8080, Protocol: "tcp"},
+		{HostPort: 9090, ContainerPort: 9090, Protocol: "tcp"},
+	}, webSvc.Ports)
+	assert.Equal(t, "svc/shop-db", server.Services[3].Name)
+	assert.Equal(t, "ingress/shop", server.Services[4].Name)
+
+	assert.Equal(t, []model.Connection{
+		{From: "k8s-shop/svc/shop-web", To: "k8s-shop/shop-web", Label: "80→8080, 9090"},
+		{From: "k8s-shop/svc/shop-db", To: "k8s-shop/shop-db", Label: "5432"},
+		{From: "k8s-shop/ingress/shop", To: "k8s-shop/svc/shop-web", Label: "shop.example.com"},
+	}, infra.Connections)
+}
+
+func TestKubernetesManifestsDirectory(t *testing.T) {
+	mc := &KubernetesManifestsCollector{Paths: []string{"../../testdata/kubernetes/manifests/gitops"}}
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, mc.Collect(withStats(context.Background(), stats), infra))
+
+	// kustomization.yaml, grafana.yaml, node-exporter.json and values.yaml;
+	// hidden directories are skipped and the unrendered template can't be read.
+	assert.Equal(t, 4, stats.FilesParsed)
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "broken.yaml")
+
+	require.Equal(t, []string{"k8s-monitoring"}, sortedKeys(infra.Servers))
+	server := infra.Servers["k8s-monitoring"]
+	require.Len(t, server.Services, 5)
+
+	assert.Equal(t, "grafana", server.Services[0].Name)
+
+	// A CronJob's pods are described by its job template.
+	backup := server.Services[1]
+	assert.Equal(t, "CronJob", backup.Kind)
+	assert.Equal(t, "restic/restic:0.16", backup.Image)
+
+	exporter := server.Services[2]
+	assert.Equal(t, "node-exporter", exporter.Name)
+	assert.Equal(t, "DaemonSet", exporter.Kind)
+	assert.Zero(t, exporter.Replicas)
+	assert.Equal(t, []model.PortMapping{{ContainerPort: 9100, Protocol: "tcp"}}, exporter.Ports)
+
+	// A NodePort Service publishes its node port.
+	grafanaSvc := server.Services[3]
+	assert.Equal(t, "svc/grafana", grafanaSvc.Name)
+	assert.Equal(t, []model.PortMapping{{HostPort: 30300, ContainerPort: 3000, Protocol: "tcp"}}, grafanaSvc.Ports)
+
+	// The default backend and the rule share one connection.
+	assert.Equal(t, []model.Connection{
+		{From: "k8s-monitoring/svc/grafana", To: "k8s-monitoring/grafana", Label: "3000"},
+		{From: "k8s-monitoring/ingress/grafana", To: "k8s-monitoring/svc/grafana", Label: "*, grafana.example.com"},
+	}, infra.Connections)
+}
+
+func TestKubernetesManifestsDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"base/web.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.27
+`,
+		"overlays/prod/patch.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 5
+`,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0o600))
+	}
+
+	mc := &KubernetesManifestsCollector{Paths: []string{dir}}
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, mc.Collect(withStats(context.Background(), stats), infra))
+
+	// The patch doesn't become a second web Deployment without an image.
+	services := infra.Servers["k8s-default"].Services
+	require.Len(t, services, 1)
+	assert.Equal(t, "nginx:1.27", services[0].Image)
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "patch.yaml: Deployment/web in namespace default is already defined in "+filepath.Join(dir, "base/web.yaml"))
+}
+
+func TestKubernetesManifestsNamespaceFilter(t *testing.T) {
+	mc := &KubernetesManifestsCollector{
+		Paths:      []string{"../../testdata/kubernetes/manifests"},
+		Namespaces: []string{"monitoring"},
+	}
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, mc.Collect(context.Background(), infra))
+	assert.Equal(t, []string{"k8s-monitoring"}, sortedKeys(infra.Servers))
+}
+
+func TestKubernetesPortsLabel(t *testing.T) {
+	ks := k8sService{Spec: k8sServiceSpec{Ports: []k8sServicePort{
+		{Port: 80, TargetPort: k8sIntOrString{StrVal: "http"}},
+		{Port: 443, TargetPort: k8sIntOrString{IntVal: 8443}},
+		{Port: 53, Protocol: "UDP"},
+	}}}
+
+	ports := servicePorts(ks, []k8sContainer{{Ports: []k8sPort{{Name: "http", ContainerPort: 8080}}}})
+	assert.Equal(t, "80→8080, 443→8443, 53", portsLabel(ks, ports))
+	assert.Equal(t, "udp", ports[2].Protocol)
+
+	// A named port no container declares stays named.
+	assert.Equal(t, "80→http, 443→8443, 53", portsLabel(ks, servicePorts(ks, nil)))
+}
@@ -0,0 +1,305 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
+
+// k8sWorkload is a Deployment, StatefulSet, DaemonSet, Job or CronJob: the
+// pods it runs are described by its pod template.
+type k8sWorkload struct {
+	Kind     string  `json:"kind"`
+	Metadata k8sMeta `json:"metadata"`
+	Spec     struct {
+		Replicas    *int           `json:"replicas"`
+		Template    k8sPodTemplate `json:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template k8sPodTemplate `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMeta    `json:"metadata"`
+	Spec     k8sPodSpec `json:"spec"`
+}
+
+// podTemplate returns the template of the pods the workload runs; a
+// CronJob's is inside its job template.
+func (wl k8sWorkload) podTemplate() k8sPodTemplate {
+	if wl.Kind == "CronJob" {
+		return wl.Spec.JobTemplate.Spec.Template
+	}
+	return wl.Spec.Template
+}
+
+// replicas is the number of pods the workload asks for, 0 when it doesn't
+// say (DaemonSets, Jobs).
+func (wl k8sWorkload) replicas() int {
+	switch {
+	case wl.Spec.Replicas != nil:
+		return *wl.Spec.Replicas
+	case wl.Kind == "Deployment" || wl.Kind == "StatefulSet":
+		return 1
+	}
+	return 0
+}
+
+// k8sObjects are the workloads, Services and Ingresses of a cluster or of a
+// set of manifests.
+type k8sObjects struct {
+	workloads []k8sWorkload
+	services  []k8sService
+	ingresses []k8sIngress
+	files     map[string]string // "Kind/namespace/name" → manifest file
+	cluster   string            // cluster name, when there are several
+}
+
+// source describes where an object was read from: its manifest file, or
+// the namespace it was listed in.
+func (objs *k8sObjects) source(kind, ns, name string) string {
+	if file, ok := objs.files[kind+"/"+ns+"/"+name]; ok {
+		return fmt.Sprintf("%s %s/%s", file, kind, name)
+	}
+	return fmt.Sprintf("%s %s/%s", kind, ns, name)
+}
+
+// build adds the objects to infra, one server per namespace, and returns the
+// namespaces it created servers for. Each workload is a service listening on
+// its container ports. Services and Ingresses are nodes of their own, named
+// "svc/<name>" and "ingress/<name>", and the way traffic enters the cluster
+// is drawn as connections: Ingress → Service labeled with the hosts and
+// paths, Service → workload labeled with the ports.
+func (objs *k8sObjects) build(infra *model.Infrastructure) []string {
+	namespaces := make(map[string]bool)
+	serverFor := func(kind, ns, name string) *model.Server {
+		server := namespaceServer(infra, objs.cluster, ns)
+		if file, ok := objs.files[kind+"/"+ns+"/"+name]; ok {
+			server.AddSource(file)
+		}
+		namespaces[ns] = true
+		return server
+	}
+	connect := func(ns, from, to, label string) {
+		server := namespaceServerName(objs.cluster, ns)
+		infra.Connections = append(infra.Connections, model.Connection{
+			From:  server + "/" + from,
+			To:    server + "/" + to,
+			Label: label,
+		})
+	}
+
+	for _, wl := range objs.workloads {
+		ns, pod := wl.Metadata.Namespace, wl.podTemplate()
+		svc := &model.Service{
+			Name:     wl.Metadata.Name,
+			Kind:     wl.Kind,
+			Replicas: wl.replicas(),
+			Category: "kubernetes",
+		}
+		if len(pod.Spec.Containers) > 0 {
+			svc.Image = pod.Spec.Containers[0].Image
+		}
+		svc.Type = detectServiceType(svc.Image, svc.Name)
+		for _, c := range pod.Spec.Containers {
+			for _, p := range c.Ports {
+				svc.Ports = append(svc.Ports, model.PortMapping{
+					ContainerPort: p.ContainerPort,
+					Protocol:      portProtocol(p.Protocol),
+				})
+			}
+		}
+		svc.AddSource(objs.source(wl.Kind, ns, wl.Metadata.Name))
+		serverFor(wl.Kind, ns, wl.Metadata.Name).AddService(svc)
+	}
+
+	// Services are drawn when they select a workload or an Ingress routes to
+	// them, which leaves out the API server's and other bookkeeping ones.
+	routed := make(map[string]bool)
+	for _, ing := range objs.ingresses {
+		for _, route := range ing.routes() {
+			routed[ing.Metadata.Namespace+"/"+route.backend] = true
+		}
+	}
+	for _, ks := range objs.services {
+		ns, name := ks.Metadata.Namespace, ks.Metadata.Name
+		targets := objs.selectedWorkloads(ks)
+		if len(targets) == 0 && !routed[ns+"/"+name] {
+			continue
+		}
+
+		node := &model.Service{
+			Name:     "svc/" + name,
+			Type:     model.ServiceTypeApp,
+			Kind:     "Service",
+			Category: "kubernetes",
+		}
+		for _, wl := range targets {
+			ports := servicePorts(ks, wl.podTemplate().Spec.Containers)
+			if node.Ports == nil {
+				node.Ports = ports
+			}
+			connect(ns, node.Name, wl.Metadata.Name, portsLabel(ks, ports))
+		}
+		if node.Ports == nil {
+			node.Ports = servicePorts(ks, nil)
+		}
+		node.AddSource(objs.source("Service", ns, name))
+		serverFor("Service", ns, name).AddService(node)
+	}
+
+	for _, ing := range objs.ingresses {
+		ns, name := ing.Metadata.Namespace, ing.Metadata.Name
+		node := &model.Service{
+			Name:     "ingress/" + name,
+			Type:     model.ServiceTypeApp,
+			Kind:     "Ingress",
+			Category: "kubernetes",
+		}
+		node.AddSource(objs.source("Ingress", ns, name))
+		serverFor("Ingress", ns, name).AddService(node)
+
+		// One connection per backend Service, listing every host and path
+		// that leads to it.
+		var backends []string
+		labels := make(map[string][]string)
+		for _, route := range ing.routes() {
+			if !containsStr(backends, route.backend) {
+				backends = append(backends, route.backend)
+			}
+			if !containsStr(labels[route.backend], route.label) {
+				labels[route.backend] = append(labels[route.backend], route.label)
+			}
+		}
+		for _, backend := range backends {
+			connect(ns, node.Name, "svc/"+backend, strings.Join(labels[backend], ", "))
+		}
+	}
+
+	return sortedKeys(namespaces)
+}
+
+// selects reports whether a Service selector matches a pod's labels. A
+// Service without a selector selects no pods.
+func selects(selector, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// selectedWorkloads returns the workloads a Service selects, in name order.
+func (objs *k8sObjects) selectedWorkloads(ks k8sService) []k8sWorkload {
+	var targets []k8sWorkload
+	for _, wl := range objs.workloads {
+		if wl.Metadata.Namespace == ks.Metadata.Namespace && selects(ks.Spec.Selector, wl.podTemplate().Metadata.Labels) {
+			targets = append(targets, wl)
+		}
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].Metadata.Name < targets[j].Metadata.Name
+	})
+	return targets
+}
+
+// servicePorts maps a Service's ports onto the pods it selects, one mapping
+// per port. The port the Service exposes (its node port, for NodePort and
+// LoadBalancer Services) is the host side; the target port, resolved by name
+// against the containers' ports if needed, is the container side.
+func servicePorts(ks k8sService, containers []k8sContainer) []model.PortMapping {
+	var ports []model.PortMapping
+	for _, p := range ks.Spec.Ports {
+		pm := model.PortMapping{
+			HostPort:      p.Port,
+			ContainerPort: p.TargetPort.IntVal,
+			Protocol:      portProtocol(p.Protocol),
+		}
+		if p.NodePort > 0 {
+			pm.HostPort = p.NodePort
+		}
+		if name := p.TargetPort.StrVal; name != "" {
+			for _, c := range containers {
+				for _, cp := range c.Ports {
+					if cp.Name == name {
+						pm.ContainerPort = cp.ContainerPort
+					}
+				}
+			}
+		}
+		if pm.ContainerPort == 0 && p.TargetPort.StrVal == "" {
+			pm.ContainerPort = p.Port
+		}
+		ports = append(ports, pm)
+	}
+	return ports
+}
+
+// portsLabel lists how a Service's ports reach its pods, e.g. "80→8080, 9090".
+// ports are the Service's ports as servicePorts resolved them.
+func portsLabel(ks k8sService, ports []model.PortMapping) string {
+	var parts []string
+	for i, p := range ks.Spec.Ports {
+		switch target := ports[i].ContainerPort; {
+		case target == p.Port:
+			parts = append(parts, strconv.Itoa(p.Port))
+		case target == 0:
+			parts = append(parts, fmt.Sprintf("%d→%s", p.Port, p.TargetPort.StrVal))
+		default:
+			parts = append(parts, fmt.Sprintf("%d→%d", p.Port, target))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func portProtocol(protocol string) string {
+	if protocol == "" {
+		return "tcp"
+	}
+	return strings.ToLower(protocol)
+}
+
+// k8sRoute is one Ingress path: the Service it sends traffic to and a label
+// such as "app.example.com/api".
+type k8sRoute struct {
+	backend string
+	label   string
+}
+
+// routes lists the Ingress's paths, the default backend first.
+func (ing k8sIngress) routes() []k8sRoute {
+	var routes []k8sRoute
+	if b := ing.Spec.DefaultBackend; b != nil && b.Service.Name != "" {
+		routes = append(routes, k8sRoute{backend: b.Service.Name, label: "*"})
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := rule.Host
+		if host == "" {
+			host = "*"
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service.Name == "" {
+				continue // a resource backend, not a Service
+			}
+			label := host
+			if path.Path != "" && path.Path != "/" {
+				label += path.Path
+			}
+			routes = append(routes, k8sRoute{backend: path.Backend.Service.Name, label: label})
+		}
+	}
+	return routes
+}
@@ -1,6 +1,10 @@
 package collector
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -8,15 +12,48 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestKubernetesCollector(t *testing.T) {
-	kc := &KubernetesCollector{
-		TestPods:      "../../testdata/kubernetes/pods.json",
-		TestServices:  "../../testdata/kubernetes/services.json",
-		TestIngresses: "../../testdata/kubernetes/ingresses.json",
+// fakeKubectl puts a stand-in kubectl first in PATH. It answers `get` with
+// the fixtures in testdata/kubernetes for the listed resources, an empty list
+// for the others, and the error an RBAC denial gives for those marked
+// "forbidden" ("nodes=forbidden").
+func fakeKubectl(t *testing.T, resources ...string) {
+	t.Helper()
+	fixtures, err := filepath.Abs("../../testdata/kubernetes")
+	require.NoError(t, err)
+	files := map[string]string{"svc": "services", "ingress": "ingresses"}
+
+	var cases strings.Builder
+	for _, res := range resources {
+		if name, ok := strings.CutSuffix(res, "=forbidden"); ok {
+			cases.WriteString(`  ` + name + `) echo 'Error from server (Forbidden): ` + name + ` is forbidden: User "dev" cannot list resource "` + name + `" in API group "" at the cluster scope' >&2; exit 1 ;;` + "\n")
+			continue
+		}
+		file := res
+		if f, ok := files[res]; ok {
+			file = f
+		}
+		cases.WriteString("  " + res + ") cat " + fixtures + "/" + file + ".json ;;\n")
 	}
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  [ "$1" = get ] && res=$2
+  shift
+done
+case "$res" in
+` + cases.String() + `  *) echo '{"items": []}' ;;
+esac
+`
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "kubectl"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestKubernetesCollector(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress")
+	kc := &KubernetesCollector{}
 
 	infra := model.NewInfrastructure()
-	err := kc.Collect(infra)
+	err := kc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Should have 2 namespace-servers: k8s-default and k8s-monitoring
@@ -26,7 +63,7 @@ func TestKubernetesCollector(t *testing.T) {
 	// k8s-default should have nginx and postgres
 	defaultServer := infra.Servers["k8s-default"]
 	assert.Equal(t, model.ServerTypeCluster, defaultServer.Type)
-	assert.Len(t, defaultServer.Services, 2)
+	assert.Len(t, k8sWorkloadServices(defaultServer), 2)
 
 	svcNames := make(map[string]bool)
 	for _, svc := range defaultServer.Services {
@@ -44,20 +81,219 @@ func TestKubernetesCollector(t *testing.T) {
 
 	// k8s-monitoring should have grafana
 	monServer := infra.Servers["k8s-monitoring"]
-	assert.Len(t, monServer.Services, 1)
+	assert.Len(t, k8sWorkloadServices(monServer), 1)
 	assert.Equal(t, "grafana", monServer.Services[0].Name)
 }
 
-func TestKubernetesCollectorNamespaceFilter(t *testing.T) {
-	kc := &KubernetesCollector{
-		Namespaces:    []string{"monitoring"},
-		TestPods:      "../../testdata/kubernetes/pods.json",
-		TestServices:  "../../testdata/kubernetes/services.json",
-		TestIngresses: "../../testdata/kubernetes/ingresses.json",
+func TestKubernetesCollectorRouting(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress")
+	kc := &KubernetesCollector{}
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, kc.Collect(context.Background(), infra))
+
+	services := make(map[string]*model.Service)
+	for _, svc := range infra.Servers["k8s-default"].Services {
+		services[svc.Name] = svc
+	}
+	require.Contains(t, services, "svc/nginx")
+	require.Contains(t, services, "ingress/nginx-ingress")
+	assert.Equal(t, "Service", services["svc/nginx"].Kind)
+	assert.Equal(t, []model.PortMapping{{HostPort: 80, ContainerPort: 80, Protocol: "tcp"}}, services["svc/nginx"].Ports)
+	// The workload listens on its container ports; the Service publishes them.
+	assert.Equal(t, []model.PortMapping{{ContainerPort: 80, Protocol: "tcp"}}, services["nginx"].Ports)
+
+	// Every port of the grafana NodePort Service.
+	grafana := infra.Servers["k8s-monitoring"].Services[1]
+	assert.Equal(t, "svc/grafana", grafana.Name)
+	assert.Equal(t, []model.PortMapping{
+		{HostPort: 30300, ContainerPort: 3000, Protocol: "tcp"},
+		{HostPort: 9094, ContainerPort: 9094, Protocol: "udp"},
+	}, grafana.Ports)
+
+	assert.Equal(t, []model.Connection{
+		{From: "k8s-default/svc/nginx", To: "k8s-default/nginx", Label: "80"},
+		{From: "k8s-default/svc/postgres", To: "k8s-default/postgres", Label: "5432"},
+		{From: "k8s-monitoring/svc/grafana", To: "k8s-monitoring/grafana", Label: "3000, 9094"},
+		{From: "k8s-default/ingress/nginx-ingress", To: "k8s-default/svc/nginx", Label: "app.example.com, app.example.com/api"},
+	}, infra.Connections)
+}
+
+// k8sWorkloadServices leaves out the nodes standing for Services and Ingresses.
+func k8sWorkloadServices(server *model.Server) []*model.Service {
+	var out []*model.Service
+	for _, svc := range server.Services {
+		if svc.Kind != "Service" && svc.Kind != "Ingress" {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
+
+func TestKubernetesCollectorWorkloads(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress", "replicasets", "jobs")
+	kc := &KubernetesCollector{}
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, kc.Collect(withStats(context.Background(), stats), infra))
+	assert.Equal(t, 6, stats.APICalls, "five lists and the nodes")
+
+	workloads := func(ns string) map[string]*model.Service {
+		out := make(map[string]*model.Service)
+		for _, svc := range infra.Servers["k8s-"+ns].Services {
+			out[svc.Name] = svc
+		}
+		return out
+	}
+
+	// Two running replicas through ReplicaSet nginx-7c5ddbdf54; the pending
+	// one isn't counted.
+	nginx := workloads("default")["nginx"]
+	require.NotNil(t, nginx)
+	assert.Equal(t, "Deployment", nginx.Kind)
+	assert.Equal(t, 2, nginx.Replicas)
+	assert.Equal(t, []model.Source{{Location: "Deployment default/nginx"}}, nginx.Sources)
+	assert.Equal(t, "StatefulSet", workloads("default")["postgres"].Kind)
+
+	// ReplicaSet not listed: the Deployment is named from pod-template-hash.
+	assert.Equal(t, "Deployment", workloads("monitoring")["grafana"].Kind)
+
+	// Pods without an app label are still one workload.
+	logging := workloads("logging")
+	require.Len(t, logging, 1)
+	assert.Equal(t, "DaemonSet", logging["fluent-bit"].Kind)
+	assert.Equal(t, 2, logging["fluent-bit"].Replicas)
+
+	// Workloads sharing app=tools stay apart; a CronJob is shown from its
+	// finished pods and the failed Job is dropped.
+	batch := workloads("batch")
+	require.Len(t, batch, 3)
+	assert.Equal(t, "CronJob", batch["backup"].Kind)
+	assert.Equal(t, 0, batch["backup"].Replicas)
+	assert.Equal(t, "Job", batch["migrate"].Kind)
+	assert.Equal(t, "Pod", batch["debug"].Kind)
+}
+
+func TestKubernetesCollectorNodes(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress", "replicasets", "jobs", "nodes")
+	kc := &KubernetesCollector{}
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, kc.Collect(context.Background(), infra))
+
+	server := infra.Servers["k3s-server"]
+	require.NotNil(t, server)
+	assert.Equal(t, model.ServerTypeCluster, server.Type)
+	assert.True(t, server.Online)
+	assert.Equal(t, "Ubuntu 24.04.1 LTS", server.OS)
+	assert.Equal(t, []string{"192.168.1.31"}, server.Addresses)
+	assert.Equal(t, []string{"role=control-plane", "role=master"}, server.Tags)
+	assert.Equal(t, []model.Source{{Location: "node k3s-server"}}, server.Sources)
+
+	agent1 := infra.Servers["k3s-agent-1"]
+	assert.Equal(t, "203.0.113.32", agent1.PublicIP)
+	assert.Equal(t, []string{"k3s-agent-1.lan"}, agent1.Aliases)
+	assert.Empty(t, agent1.Tags)
+
+	agent2 := infra.Servers["k3s-agent-2"]
+	assert.False(t, agent2.Online)
+	assert.Equal(t, []string{"role=worker", "status=NotReady"}, agent2.Tags)
+
+	// Workloads point at the nodes their pods run on; the pending pod has no
+	// node yet and the failed one isn't drawn.
+	var placement []model.Connection
+	for _, conn := range infra.Connections {
+		if conn.Style == "dashed" {
+			placement = append(placement, conn)
+		}
 	}
+	assert.Equal(t, []model.Connection{
+		{From: "k8s-batch/backup", To: "k3s-agent-1", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-batch/migrate", To: "k3s-agent-2", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-batch/debug", To: "k3s-server", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-default/nginx", To: "k3s-agent-1", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-default/nginx", To: "k3s-agent-2", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-default/postgres", To: "k3s-agent-1", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-logging/fluent-bit", To: "k3s-agent-1", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-logging/fluent-bit", To: "k3s-agent-2", Label: "1 pod", Style: "dashed"},
+		{From: "k8s-monitoring/grafana", To: "k3s-agent-2", Label: "1 pod", Style: "dashed"},
+	}, placement)
+}
+
+func TestKubernetesCollectorOwnersForbidden(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress", "replicasets=forbidden", "jobs=forbidden")
+	kc := &KubernetesCollector{}
 
 	infra := model.NewInfrastructure()
-	err := kc.Collect(infra)
+	stats := &CollectStats{}
+	require.NoError(t, kc.Collect(withStats(context.Background(), stats), infra))
+
+	// Deployments are still named from pod-template-hash.
+	var nginx *model.Service
+	for _, svc := range infra.Servers["k8s-default"].Services {
+		if svc.Name == "nginx" {
+			nginx = svc
+		}
+	}
+	require.NotNil(t, nginx)
+	assert.Equal(t, "Deployment", nginx.Kind)
+	assert.Equal(t, 2, nginx.Replicas)
+
+	require.Len(t, stats.Warnings, 2)
+	assert.Contains(t, stats.Warnings[0], "skipping pod owners: kubectl get replicasets -A -o json")
+	assert.Contains(t, stats.Warnings[1], "skipping pod owners: kubectl get jobs -A -o json")
+}
+
+func TestKubernetesCollectorNodesForbidden(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress", "replicasets", "jobs", "nodes=forbidden")
+	kc := &KubernetesCollector{}
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, kc.Collect(withStats(context.Background(), stats), infra))
+
+	// Workloads are still collected, without placement.
+	assert.Len(t, k8sWorkloadServices(infra.Servers["k8s-default"]), 2)
+	assert.NotContains(t, infra.Servers, "k3s-server")
+	for _, conn := range infra.Connections {
+		assert.NotEqual(t, "dashed", conn.Style)
+	}
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "skipping nodes: kubectl get nodes -o json")
+	assert.Contains(t, stats.Warnings[0], "Forbidden")
+}
+
+func TestKubernetesWorkloadOf(t *testing.T) {
+	owners := k8sOwners{
+		"ns/ReplicaSet/web-5d8f": {Name: "web-5d8f", OwnerReferences: []k8sOwnerRef{{Kind: "Deployment", Name: "web", Controller: true}}},
+		"ns/ReplicaSet/loop":     {Name: "loop", OwnerReferences: []k8sOwnerRef{{Kind: "ReplicaSet", Name: "loop"}}},
+	}
+	pod := func(refs ...k8sOwnerRef) k8sPod {
+		return k8sPod{Metadata: k8sMeta{Name: "p", Namespace: "ns", OwnerReferences: refs}}
+	}
+
+	kind, name := owners.workloadOf(pod(k8sOwnerRef{Kind: "ReplicaSet", Name: "web-5d8f"}))
+	assert.Equal(t, []string{"Deployment", "web"}, []string{kind, name})
+
+	// The controller reference wins over other owners.
+	kind, name = owners.workloadOf(pod(k8sOwnerRef{Kind: "Node", Name: "n1"}, k8sOwnerRef{Kind: "StatefulSet", Name: "db", Controller: true}))
+	assert.Equal(t, []string{"StatefulSet", "db"}, []string{kind, name})
+
+	// An owner cycle ends instead of looping forever.
+	kind, name = owners.workloadOf(pod(k8sOwnerRef{Kind: "ReplicaSet", Name: "loop"}))
+	assert.Equal(t, []string{"ReplicaSet", "loop"}, []string{kind, name})
+
+	kind, name = owners.workloadOf(pod())
+	assert.Equal(t, []string{"Pod", "p"}, []string{kind, name})
+}
+
+func TestKubernetesCollectorNamespaceFilter(t *testing.T) {
+	fakeKubectl(t, "pods", "svc", "ingress")
+	kc := &KubernetesCollector{Namespaces: []string{"monitoring"}}
+
+	infra := model.NewInfrastructure()
+	err := kc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Only monitoring namespace should be present
@@ -71,3 +307,121 @@ func TestKubernetesMetadata(t *testing.T) {
 	assert.Equal(t, "kubernetes", meta.Name)
 	assert.Equal(t, "kubernetes", meta.ConfigKey)
 }
+
+func TestKubernetesCollectorClusters(t *testing.T) {
+	fixtures, err := filepath.Abs("../../testdata/kubernetes")
+	require.NoError(t, err)
+
+	// A stand-in kubectl serving the same workloads for every context, the
+	// nodes for one of them, and logging which context each call was for.
+	bin := t.TempDir()
+	log := filepath.Join(bin, "calls.log")
+	script := `#!/bin/sh
+ctx=""; res=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --context) ctx=$2; shift ;;
+    get) res=$2; shift ;;
+  esac
+  shift
+done
+echo "$ctx $res" >> ` + log + `
+case "$ctx $res" in
+  "work nodes") echo '{"items": []}' ;;
+  *" svc") cat ` + fixtures + `/services.json ;;
+  *" ingress") cat ` + fixtures + `/ingresses.json ;;
+  *) cat ` + fixtures + `/$res.json ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "kubectl"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	kc := &KubernetesCollector{}
+	require.NoError(t, kc.Configure(map[string]any{
+		"clusters": []any{
+			map[string]any{"name": "home", "context": "k3s-home"},
+			map[string]any{"context": "work", "namespaces": []any{"default"}},
+		},
+	}))
+	assert.Empty(t, kc.Validate())
+	assert.Equal(t, "work", kc.Clusters[1].Name)
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, kc.Collect(withStats(context.Background(), stats), infra))
+	assert.Equal(t, 12, stats.APICalls)
+
+	calls, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "k3s-home pods\n")
+	assert.Contains(t, string(calls), "work pods\n")
+
+	// Both clusters have a default namespace; neither overwrites the other.
+	assert.ElementsMatch(t, []string{
+		"k8s-home_batch", "k8s-home_default", "k8s-home_logging", "k8s-home_monitoring",
+		"k8s-work_default", "k3s-agent-1", "k3s-agent-2", "k3s-server",
+	}, sortedKeys(infra.Servers))
+	assert.Equal(t, "home", infra.Servers["k3s-server"].Cluster)
+	home, work := infra.Servers["k8s-home_default"], infra.Servers["k8s-work_default"]
+	assert.Equal(t, "home", home.Cluster)
+	assert.Equal(t, "work", work.Cluster)
+	assert.Equal(t, []model.Source{{Location: "context work, namespace default"}}, work.Sources)
+	assert.Len(t, work.Services, len(home.Services))
+
+	assert.Contains(t, infra.Connections, model.Connection{
+		From: "k8s-work_default/svc/nginx", To: "k8s-work_default/nginx", Label: "80",
+	})
+}
+
+func TestNamespaceServerName(t *testing.T) {
+	assert.Equal(t, "k8s-default", namespaceServerName("", "default"))
+	assert.Equal(t, "k8s-home-lab_default", namespaceServerName("Home Lab", "default"))
+
+	// Cluster "a-b" with namespace "c" is not cluster "a" with "b-c", nor
+	// namespace "a-b" of the unnamed cluster.
+	names := []string{
+		namespaceServerName("a-b", "c"),
+		namespaceServerName("a", "b-c"),
+		namespaceServerName("", "a-b-c"),
+		namespaceServerName("a", "b"),
+		namespaceServerName("", "a-b"),
+	}
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			assert.NotEqual(t, names[i], names[j])
+		}
+	}
+}
+
+func TestKubernetesClustersValidate(t *testing.T) {
+	kc := &KubernetesCollector{}
+	require.NoError(t, kc.Configure(map[string]any{
+		"context": "legacy",
+		"clusters": []any{
+			map[string]any{"kubeconfig": "/nonexistent/config"},
+			map[string]any{"name": "cluster-2"},
+		},
+	}))
+	assert.Equal(t, "cluster-1", kc.Clusters[0].Name)
+
+	var fields []string
+	for _, e := range kc.Validate() {
+		fields = append(fields, e.Field)
+	}
+	assert.Contains(t, fields, "sources.kubernetes.clusters[0].kubeconfig")
+	assert.Contains(t, fields, "sources.kubernetes")
+
+	assert.Error(t, (&KubernetesCollector{}).Configure(map[string]any{"clusters": []any{"prod"}}))
+
+	// Clusters that would be drawn as one are refused, for generate too.
+	err := (&KubernetesCollector{}).Configure(map[string]any{"clusters": []any{
+		map[string]any{"kubeconfig": "/nonexistent/config"},
+		map[string]any{"name": "cluster-1"},
+	}})
+	assert.ErrorContains(t, err, `clusters[1]: duplicate cluster name "cluster-1"`)
+	err = (&KubernetesCollector{}).Configure(map[string]any{"clusters": []any{
+		map[string]any{"name": "prod.eu"},
+		map[string]any{"name": "prod-eu"},
+	}})
+	assert.ErrorContains(t, err, `clusters[1]: cluster names "prod.eu" and "prod-eu" both draw as "prod-eu"`)
+}
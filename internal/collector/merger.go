@@ -1,11 +1,23 @@
 package collector
 
 import (
+	"sort"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
 )
 
 // Merge correlates data across collectors.
-func Merge(infra *model.Infrastructure) {
+func Merge(infra *model.Infrastructure, cfg config.MergeConfig) {
+	// Fold servers reported under different names into one
+	correlate(infra, cfg.Aliases, precedence(cfg.Precedence))
+
+	// Link hypervisor guests to the servers they are
+	linkGuests(infra)
+
+	// Fold the same container reported by several collectors into one service
+	dedupeServices(infra)
+
 	// Auto-categorize services
 	categorizeServices(infra)
 
@@ -28,7 +40,7 @@ func buildTypeGroups(infra *model.Infrastructure) {
 		model.ServerTypeProduction: {Name: "production", Label: "Production"},
 		model.ServerTypeLab:        {Name: "lab", Label: "Lab Servers"},
 		model.ServerTypeLocal:      {Name: "local", Label: "Local"},
-		model.ServerTypeCluster:    {Name: "cluster", Label: "Kubernetes"},
+		model.ServerTypeCluster:    {Name: "cluster", Label: "Clusters"},
 		model.ServerTypeHypervisor: {Name: "hypervisor", Label: "Hypervisors"},
 	}
 
@@ -44,3 +56,169 @@ func buildTypeGroups(infra *model.Infrastructure) {
 		}
 	}
 }
+
+// serverTypeRank orders server types by how specific a claim they are. When
+// two collectors report the same host, the more specific type wins: a Proxmox
+// node is a hypervisor even if Ansible lists it as a lab machine, but a
+// compose file's "local" default never downgrades a production server.
+var serverTypeRank = map[model.ServerType]int{
+	model.ServerTypeLocal:      1,
+	model.ServerTypeLab:        2,
+	model.ServerTypeProduction: 3,
+	model.ServerTypeCluster:    4,
+	model.ServerTypeHypervisor: 5,
+}
+
+// mergeInto folds one collector's isolated result into the shared
+// infrastructure and reports what it added or updated. Results are merged in
+// registry order, so unless the precedence policy says otherwise, for fields
+// both sides set the later collector wins (e.g. Tailscale's IP and online
+// status).
+func mergeInto(dst, src *model.Infrastructure, prec precedence) CollectStats {
+	var stats CollectStats
+
+	if src.TailnetName != "" {
+		dst.TailnetName = src.TailnetName
+	}
+
+	for _, hostname := range sortedKeys(src.Servers) {
+		server := src.Servers[hostname]
+		stats.ServicesAdded += len(server.Services)
+		existing, ok := dst.Servers[hostname]
+		if !ok {
+			// A device reported earlier turns out to be a server.
+			if dev, ok := dst.Devices[hostname]; ok {
+				server.Sources = model.AddSources(dev.Sources, server.Sources...)
+			}
+			delete(dst.Devices, hostname)
+			dst.Servers[hostname] = server
+			stats.ServersAdded++
+			continue
+		}
+		dst.Conflicts = append(dst.Conflicts, mergeServer(existing, server, prec)...)
+		stats.ServersUpdated++
+	}
+
+	for _, hostname := range sortedKeys(src.Devices) {
+		dev := src.Devices[hostname]
+		// A known server reported as a plain device only contributes its
+		// Tailscale details, as when Tailscale enriches an Ansible host.
+		if server, ok := dst.Servers[hostname]; ok {
+			dst.Conflicts = append(dst.Conflicts, enrichFromDevice(server, dev, prec)...)
+			stats.ServersUpdated++
+			continue
+		}
+		if existing, ok := dst.Devices[hostname]; ok {
+			dev.Sources = model.AddSources(existing.Sources, dev.Sources...)
+			stats.DevicesUpdated++
+		} else {
+			stats.DevicesAdded++
+		}
+		dst.Devices[hostname] = dev
+	}
+
+	for _, name := range sortedKeys(src.ServerGroups) {
+		group := src.ServerGroups[name]
+		existing, ok := dst.ServerGroups[name]
+		if !ok {
+			dst.ServerGroups[name] = group
+			continue
+		}
+		for _, s := range group.Servers {
+			if !containsStr(existing.Servers, s) {
+				existing.Servers = append(existing.Servers, s)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(src.Networks) {
+		network := src.Networks[name]
+		existing, ok := dst.Networks[name]
+		if !ok {
+			dst.Networks[name] = network
+			continue
+		}
+		if existing.Driver == "" {
+			existing.Driver = network.Driver
+		}
+		for _, s := range network.Services {
+			if !containsStr(existing.Services, s) {
+				existing.Services = append(existing.Services, s)
+			}
+		}
+	}
+
+	dst.Connections = append(dst.Connections, src.Connections...)
+
+	return stats
+}
+
+// mergeServer copies what src knows about a host onto dst and returns the
+// field conflicts the precedence policy settled.
+func mergeServer(dst, src *model.Server, prec precedence) []model.Conflict {
+	conflicts := prec.mergeFields(dst, src)
+	if dst.Cluster == "" {
+		dst.Cluster = src.Cluster
+	}
+	if len(dst.Storage) == 0 {
+		dst.Storage = src.Storage
+	}
+	for _, a := range src.Aliases {
+		dst.AddAlias(a)
+	}
+	for _, a := range src.Addresses {
+		dst.AddAddress(a)
+	}
+	for _, t := range src.Tags {
+		if !containsStr(dst.Tags, t) {
+			dst.Tags = append(dst.Tags, t)
+		}
+	}
+	for _, g := range src.AnsibleGroups {
+		if !containsStr(dst.AnsibleGroups, g) {
+			dst.AnsibleGroups = append(dst.AnsibleGroups, g)
+		}
+	}
+	for _, svc := range src.Services {
+		dst.AddService(svc)
+	}
+	dst.Sources = model.AddSources(dst.Sources, src.Sources...)
+	return conflicts
+}
+
+// stampSources attributes a collector's isolated result to it: sources
+// recorded without a collector name get this one, and entities the collector
+// did not annotate get a bare entry.
+func stampSources(infra *model.Infrastructure, collector string) {
+	stamp := func(sources []model.Source) []model.Source {
+		if len(sources) == 0 {
+			return []model.Source{{Collector: collector}}
+		}
+		for i := range sources {
+			if sources[i].Collector == "" {
+				sources[i].Collector = collector
+			}
+		}
+		return sources
+	}
+
+	for _, server := range infra.Servers {
+		server.Sources = stamp(server.Sources)
+		stampFieldSources(server, collector)
+		for _, svc := range server.Services {
+			svc.Sources = stamp(svc.Sources)
+		}
+	}
+	for _, dev := range infra.Devices {
+		dev.Sources = stamp(dev.Sources)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
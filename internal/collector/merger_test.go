@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeIntoEnrichesServerFromDevice(t *testing.T) {
+	dst := model.NewInfrastructure()
+	dst.Servers["gateway"] = &model.Server{
+		Hostname: "gateway",
+		PublicIP: "203.0.113.10",
+		Type:     model.ServerTypeProduction,
+		Online:   true,
+	}
+
+	// Tailscale on its own sees an untagged peer as a device.
+	src := model.NewInfrastructure()
+	src.Devices["gateway"] = &model.Device{Hostname: "gateway", OS: "linux", TailscaleIP: "100.64.0.2", Online: true}
+	src.Devices["phone"] = &model.Device{Hostname: "phone", OS: "iOS"}
+	src.TailnetName = "user@example"
+
+	mergeInto(dst, src, nil)
+
+	gateway := dst.Servers["gateway"]
+	assert.Equal(t, "100.64.0.2", gateway.TailscaleIP)
+	assert.Equal(t, "linux", gateway.OS)
+	assert.Equal(t, "203.0.113.10", gateway.PublicIP)
+	assert.NotContains(t, dst.Devices, "gateway")
+	assert.Contains(t, dst.Devices, "phone")
+	assert.Equal(t, "user@example", dst.TailnetName)
+}
+
+func TestMergeIntoServerType(t *testing.T) {
+	dst := model.NewInfrastructure()
+	dst.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeProduction}
+	dst.Servers["pve1"] = &model.Server{Hostname: "pve1", Type: model.ServerTypeLab}
+
+	src := model.NewInfrastructure()
+	src.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeLocal,
+		Services: []*model.Service{{Name: "uptime-kuma"}}}
+	src.Servers["pve1"] = &model.Server{Hostname: "pve1", Type: model.ServerTypeHypervisor}
+
+	mergeInto(dst, src, nil)
+
+	// A compose placeholder does not downgrade a production host...
+	assert.Equal(t, model.ServerTypeProduction, dst.Servers["atlas"].Type)
+	require.Len(t, dst.Servers["atlas"].Services, 1)
+	// ...but a Proxmox node is a hypervisor whatever Ansible says.
+	assert.Equal(t, model.ServerTypeHypervisor, dst.Servers["pve1"].Type)
+}
+
+func TestMergeIntoServerGroups(t *testing.T) {
+	dst := model.NewInfrastructure()
+	dst.ServerGroups["web"] = &model.ServerGroup{Name: "web", Servers: []string{"a"}}
+
+	src := model.NewInfrastructure()
+	src.ServerGroups["web"] = &model.ServerGroup{Name: "web", Servers: []string{"a", "b"}}
+
+	mergeInto(dst, src, nil)
+	assert.Equal(t, []string{"a", "b"}, dst.ServerGroups["web"].Servers)
+}
+
+func TestMergeIntoSources(t *testing.T) {
+	ansible := model.NewInfrastructure()
+	ansible.Servers["gateway"] = &model.Server{Hostname: "gateway", Type: model.ServerTypeProduction}
+	ansible.Servers["gateway"].AddSource("hosts.yml [tailnet]")
+	stampSources(ansible, "ansible")
+
+	tailscale := model.NewInfrastructure()
+	tailscale.Devices["gateway"] = &model.Device{Hostname: "gateway"}
+	tailscale.Devices["phone"] = &model.Device{Hostname: "phone"}
+	stampSources(tailscale, "tailscale")
+
+	dst := model.NewInfrastructure()
+	mergeInto(dst, ansible, nil)
+	mergeInto(dst, tailscale, nil)
+
+	assert.Equal(t, []model.Source{
+		{Collector: "ansible", Location: "hosts.yml [tailnet]"},
+		{Collector: "tailscale"},
+	}, dst.Servers["gateway"].Sources)
+	assert.Equal(t, []model.Source{{Collector: "tailscale"}}, dst.Devices["phone"].Sources)
+}
@@ -0,0 +1,447 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
+)
+
+// pluginProtocol is the version of the exec protocol spoken to plugins.
+const pluginProtocol = 1
+
+// pluginValidateTimeout bounds the metadata and validate subcommands of a
+// plugin without its own timeout: setting, so a hung plugin can't hang
+// `inframap-d2 validate`.
+const pluginValidateTimeout = 30 * time.Second
+
+// PluginCollector runs an external executable that speaks the plugin
+// protocol: `<command> metadata`, `<command> validate` and `<command> collect`.
+// The plugin's config section is written to stdin as JSON and the answer is
+// read from stdout as JSON.
+type PluginCollector struct {
+	Name    string
+	Command string
+	Args    []string
+	Config  map[string]any
+	Timeout time.Duration // limit for metadata and validate, from sources.plugins[].timeout
+
+	index     int             // position under sources.plugins, for error fields
+	meta      *pluginMetadata // filled in by Validate, or by Collect
+	duplicate int             // 1 + index of an earlier plugin of the same name
+}
+
+// pluginMetadata is the answer to the metadata subcommand.
+type pluginMetadata struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Description string `json:"description"`
+	Protocol    int    `json:"protocol"`
+}
+
+// pluginValidationError is one entry of the answer to the validate subcommand.
+type pluginValidationError struct {
+	Field      string `json:"field"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// pluginResult is the answer to the collect subcommand.
+type pluginResult struct {
+	Servers     []pluginServer     `json:"servers"`
+	Services    []pluginService    `json:"services"`
+	Devices     []pluginDevice     `json:"devices"`
+	Connections []pluginConnection `json:"connections"`
+	Warnings    []string           `json:"warnings"`
+}
+
+type pluginServer struct {
+	Hostname    string          `json:"hostname"`
+	Label       string          `json:"label"`
+	PublicIP    string          `json:"public_ip"`
+	TailscaleIP string          `json:"tailscale_ip"`
+	Type        string          `json:"type"`
+	OS          string          `json:"os"`
+	Online      *bool           `json:"online"`
+	Groups      []string        `json:"groups"`
+	Services    []pluginService `json:"services"`
+}
+
+type pluginService struct {
+	Server    string         `json:"server"` // only for top-level services
+	Name      string         `json:"name"`
+	Image     string         `json:"image"`
+	Type      string         `json:"type"`
+	Category  string         `json:"category"`
+	Ports     []pluginPort   `json:"ports"`
+	Networks  []string       `json:"networks"`
+	DependsOn []string       `json:"depends_on"`
+	Volumes   []pluginVolume `json:"volumes"`
+}
+
+type pluginPort struct {
+	HostIP        string `json:"host_ip"`
+	HostPort      int    `json:"host_port"`
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"`
+}
+
+type pluginVolume struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type pluginDevice struct {
+	Hostname    string   `json:"hostname"`
+	OS          string   `json:"os"`
+	TailscaleIP string   `json:"tailscale_ip"`
+	Online      bool     `json:"online"`
+	Tags        []string `json:"tags"`
+}
+
+type pluginConnection struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+	Style string `json:"style"`
+}
+
+// AllWithPlugins returns the registered collectors followed by one
+// PluginCollector per entry under sources.plugins. Each plugin's entry is
+// exposed in the returned source map under its own "plugin:<name>" key, so
+// plugins are enabled and configured exactly like built-in collectors. A
+// plugin named like an earlier one would share its key: it fails to
+// configure.
+func AllWithPlugins(sources map[string]any) ([]RegisteredCollector, map[string]any) {
+	collectors := All()
+
+	entries, _ := sources["plugins"].([]any)
+	if len(entries) == 0 {
+		return collectors, sources
+	}
+
+	merged := make(map[string]any, len(sources)+len(entries))
+	for k, v := range sources {
+		merged[k] = v
+	}
+	seen := make(map[string]int)
+	for i, item := range entries {
+		entry, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := entry["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		pc := &PluginCollector{Name: name, index: i}
+		if first, ok := seen[name]; ok {
+			pc.duplicate = first + 1
+		} else {
+			seen[name] = i
+			merged[pc.configKey()] = entry
+		}
+		collectors = append(collectors, pc)
+	}
+	return collectors, merged
+}
+
+func (pc *PluginCollector) configKey() string {
+	return "plugin:" + pc.Name
+}
+
+func (pc *PluginCollector) field(name string) string {
+	if name == "" {
+		return fmt.Sprintf("sources.plugins[%d]", pc.index)
+	}
+	return fmt.Sprintf("sources.plugins[%d].%s", pc.index, name)
+}
+
+func (pc *PluginCollector) Metadata() CollectorMetadata {
+	meta := CollectorMetadata{
+		Name:        pc.configKey(),
+		DisplayName: "Plugin: " + pc.Name,
+		Description: "External collector plugin",
+		ConfigKey:   pc.configKey(),
+	}
+	if pc.meta != nil {
+		if pc.meta.DisplayName != "" {
+			meta.DisplayName = pc.meta.DisplayName
+		}
+		if pc.meta.Description != "" {
+			meta.Description = pc.meta.Description
+		}
+	}
+	return meta
+}
+
+func (pc *PluginCollector) Enabled(sources map[string]any) bool {
+	section, ok := sources[pc.configKey()].(map[string]any)
+	if !ok {
+		return false
+	}
+	if enabled, ok := section["enabled"].(bool); ok {
+		return enabled
+	}
+	return true
+}
+
+func (pc *PluginCollector) Configure(section map[string]any) error {
+	if pc.duplicate > 0 {
+		return fmt.Errorf("%s: plugin name %q is already used by sources.plugins[%d]", pc.field("name"), pc.Name, pc.duplicate-1)
+	}
+	if section == nil {
+		return nil
+	}
+	if v, ok := section["command"].(string); ok {
+		pc.Command = util.ExpandPath(v)
+	}
+	if v, ok := section["args"].([]any); ok {
+		for _, a := range v {
+			pc.Args = append(pc.Args, toString(a))
+		}
+	}
+	if v, ok := section["config"].(map[string]any); ok {
+		pc.Config = v
+	}
+	if pc.Command == "" {
+		pc.Command = "inframap-collector-" + pc.Name
+	}
+	timeout, err := sectionTimeout(section, pluginValidateTimeout)
+	if err != nil {
+		return err
+	}
+	pc.Timeout = timeout
+	return nil
+}
+
+func (pc *PluginCollector) Validate() []ValidationError {
+	if _, err := exec.LookPath(pc.Command); err != nil {
+		return []ValidationError{{
+			Field:      pc.field("command"),
+			Message:    fmt.Sprintf("plugin executable not found: %s", pc.Command),
+			Suggestion: "install the plugin in PATH or set command to its full path",
+		}}
+	}
+
+	timeout := pc.Timeout
+	if timeout <= 0 {
+		timeout = pluginValidateTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var meta pluginMetadata
+	if err := pc.run(ctx, "metadata", &meta); err != nil {
+		return []ValidationError{{
+			Field:      pc.field("command"),
+			Message:    fmt.Sprintf("metadata: %v", err),
+			Suggestion: "check that the executable implements the inframap plugin protocol",
+		}}
+	}
+	if suggestion, err := meta.checkProtocol(); err != nil {
+		return []ValidationError{{
+			Field:      pc.field("command"),
+			Message:    err.Error(),
+			Suggestion: suggestion,
+		}}
+	}
+	pc.meta = &meta
+
+	var perrs []pluginValidationError
+	if err := pc.run(ctx, "validate", &perrs); err != nil {
+		return []ValidationError{{
+			Field:   pc.field(""),
+			Message: fmt.Sprintf("validate: %v", err),
+		}}
+	}
+
+	var errs []ValidationError
+	for _, pe := range perrs {
+		field := pc.field("config")
+		if pe.Field != "" {
+			field += "." + pe.Field
+		}
+		errs = append(errs, ValidationError{
+			Field:      field,
+			Message:    pe.Message,
+			Suggestion: pe.Suggestion,
+		})
+	}
+	return errs
+}
+
+// checkProtocol tells why a plugin's protocol version can't be spoken, and
+// what would fix it.
+func (m pluginMetadata) checkProtocol() (string, error) {
+	if m.Protocol < 1 {
+		return fmt.Sprintf("the metadata answer must include \"protocol\": %d", pluginProtocol),
+			fmt.Errorf("plugin metadata has no protocol version (got %d)", m.Protocol)
+	}
+	if m.Protocol > pluginProtocol {
+		return "upgrade inframap-d2 or use an older plugin release",
+			fmt.Errorf("plugin speaks protocol %d, this inframap-d2 supports %d", m.Protocol, pluginProtocol)
+	}
+	return "", nil
+}
+
+// Collect runs the plugin's collect subcommand, once its metadata shows it
+// speaks our protocol; generate doesn't run Validate, so Collect asks for
+// the metadata itself if needed.
+func (pc *PluginCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	if pc.meta == nil {
+		var meta pluginMetadata
+		if err := pc.run(ctx, "metadata", &meta); err != nil {
+			return fmt.Errorf("metadata: %w", err)
+		}
+		if _, err := meta.checkProtocol(); err != nil {
+			return err
+		}
+		pc.meta = &meta
+	}
+
+	var result pluginResult
+	if err := pc.run(ctx, "collect", &result); err != nil {
+		return err
+	}
+
+	stats := statsFrom(ctx)
+	for _, w := range result.Warnings {
+		stats.warn("%s", w)
+	}
+
+	for _, ps := range result.Servers {
+		hostname := strings.ToLower(ps.Hostname)
+		if hostname == "" {
+			stats.warn("skipping server without hostname")
+			continue
+		}
+		server := pluginEnsureServer(infra, hostname)
+		server.AddSource(pc.Command)
+		if ps.Label != "" {
+			server.Label = ps.Label
+		}
+		server.PublicIP = ps.PublicIP
+		server.TailscaleIP = ps.TailscaleIP
+		server.OS = ps.OS
+		if ps.Type != "" {
+			server.Type = model.ServerType(ps.Type)
+		}
+		if ps.Online != nil {
+			server.Online = *ps.Online
+		}
+		server.AnsibleGroups = append(server.AnsibleGroups, ps.Groups...)
+		for _, svc := range ps.Services {
+			server.AddService(svc.toModel(pc.Command))
+		}
+	}
+
+	for _, svc := range result.Services {
+		hostname := strings.ToLower(svc.Server)
+		if hostname == "" {
+			stats.warn("skipping service %q without server", svc.Name)
+			continue
+		}
+		pluginEnsureServer(infra, hostname).AddService(svc.toModel(pc.Command))
+	}
+
+	for _, pd := range result.Devices {
+		hostname := strings.ToLower(pd.Hostname)
+		if hostname == "" {
+			continue
+		}
+		infra.Devices[hostname] = &model.Device{
+			Hostname:    hostname,
+			OS:          pd.OS,
+			TailscaleIP: pd.TailscaleIP,
+			Online:      pd.Online,
+			Tags:        pd.Tags,
+		}
+		infra.Devices[hostname].AddSource(pc.Command)
+	}
+
+	for _, c := range result.Connections {
+		infra.Connections = append(infra.Connections, model.Connection{
+			From:  c.From,
+			To:    c.To,
+			Label: c.Label,
+			Style: c.Style,
+		})
+	}
+
+	return nil
+}
+
+// run invokes a plugin subcommand with the config on stdin and decodes its
+// JSON answer into result.
+func (pc *PluginCollector) run(ctx context.Context, subcommand string, result any) error {
+	input, err := json.Marshal(pc.configInput())
+	if err != nil {
+		return fmt.Errorf("encoding plugin config: %w", err)
+	}
+
+	args := append(append([]string{}, pc.Args...), subcommand)
+	out, err := runCommand(ctx, input, pc.Command, args...)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", pc.Command, subcommand, err)
+	}
+
+	if err := json.Unmarshal(out, result); err != nil {
+		return fmt.Errorf("parsing %s %s output: %w", pc.Command, subcommand, err)
+	}
+	return nil
+}
+
+// configInput is the plugin's config section, never null on the wire.
+func (pc *PluginCollector) configInput() map[string]any {
+	if pc.Config == nil {
+		return map[string]any{}
+	}
+	return pc.Config
+}
+
+func (ps pluginService) toModel(location string) *model.Service {
+	svc := &model.Service{
+		Name:      ps.Name,
+		Image:     ps.Image,
+		Type:      model.ServiceType(ps.Type),
+		Category:  ps.Category,
+		Networks:  ps.Networks,
+		DependsOn: ps.DependsOn,
+	}
+	if svc.Type == "" {
+		svc.Type = detectServiceType(ps.Image, ps.Name)
+	}
+	for _, p := range ps.Ports {
+		svc.Ports = append(svc.Ports, model.PortMapping{
+			HostIP:        p.HostIP,
+			HostPort:      p.HostPort,
+			ContainerPort: p.ContainerPort,
+			Protocol:      p.Protocol,
+		})
+	}
+	for _, v := range ps.Volumes {
+		svc.Volumes = append(svc.Volumes, model.VolumeMount{Source: v.Source, Target: v.Target})
+	}
+	svc.AddSource(location)
+	return svc
+}
+
+func pluginEnsureServer(infra *model.Infrastructure, hostname string) *model.Server {
+	server, exists := infra.Servers[hostname]
+	if !exists {
+		server = &model.Server{
+			Hostname: hostname,
+			Label:    hostname,
+			Type:     model.ServerTypeLab,
+			Online:   true,
+		}
+		infra.Servers[hostname] = server
+	}
+	return server
+}
@@ -0,0 +1,162 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakePlugin = "../../testdata/plugins/inframap-collector-fake"
+
+func TestAllWithPlugins(t *testing.T) {
+	sources := map[string]any{
+		"tailscale": map[string]any{"enabled": true},
+		"plugins": []any{
+			map[string]any{"name": "cmdb", "command": fakePlugin},
+			map[string]any{"name": "off", "enabled": false},
+		},
+	}
+
+	collectors, merged := AllWithPlugins(sources)
+	require.Len(t, collectors, len(All())+2)
+	assert.Contains(t, merged, "tailscale")
+
+	cmdb := collectors[len(collectors)-2]
+	assert.Equal(t, "plugin:cmdb", cmdb.Metadata().ConfigKey)
+	assert.True(t, cmdb.Enabled(merged))
+	assert.False(t, collectors[len(collectors)-1].Enabled(merged))
+}
+
+func TestAllWithPluginsDuplicateName(t *testing.T) {
+	sources := map[string]any{
+		"plugins": []any{
+			map[string]any{"name": "cmdb", "command": fakePlugin},
+			map[string]any{"name": "cmdb", "command": "/opt/other-cmdb"},
+		},
+	}
+
+	collectors, merged := AllWithPlugins(sources)
+	first, second := collectors[len(collectors)-2], collectors[len(collectors)-1]
+	section, _ := merged["plugin:cmdb"].(map[string]any)
+	assert.Equal(t, fakePlugin, section["command"], "the first entry keeps the key")
+
+	require.NoError(t, first.Configure(section))
+	assert.EqualError(t, second.Configure(section), `sources.plugins[1].name: plugin name "cmdb" is already used by sources.plugins[0]`)
+}
+
+func TestPluginCollectorCollect(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{"command": fakePlugin}))
+
+	tracker := &CollectStats{}
+	infra := model.NewInfrastructure()
+	require.NoError(t, pc.Collect(withStats(context.Background(), tracker), infra))
+
+	atlas := infra.Servers["atlas"]
+	require.NotNil(t, atlas)
+	assert.Equal(t, model.ServerTypeProduction, atlas.Type)
+	assert.Equal(t, "203.0.113.10", atlas.PublicIP)
+	require.Len(t, atlas.Services, 1)
+	assert.Equal(t, 443, atlas.Services[0].Ports[0].HostPort)
+
+	backup := infra.Servers["backup"]
+	require.NotNil(t, backup)
+	assert.Equal(t, model.ServerTypeLab, backup.Type)
+	assert.Equal(t, model.ServiceTypeSystem, backup.Services[0].Type)
+
+	assert.Contains(t, infra.Devices, "phone")
+	assert.Equal(t, []model.Connection{{From: "backup/restic", To: "atlas", Label: "backup", Style: "dashed"}}, infra.Connections)
+	assert.Equal(t, []string{"2 records ignored"}, tracker.Warnings)
+	assert.Equal(t, 2, tracker.APICalls, "metadata and collect")
+}
+
+func TestPluginCollectorCollectProtocol(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{
+		"command": fakePlugin,
+		"config":  map[string]any{"unversioned": true},
+	}))
+
+	// Refused by generate too, which doesn't run Validate.
+	infra := model.NewInfrastructure()
+	err := pc.Collect(context.Background(), infra)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no protocol version")
+	assert.Empty(t, infra.Servers)
+}
+
+func TestPluginCollectorError(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{
+		"command": fakePlugin,
+		"config":  map[string]any{"fail": true},
+	}))
+
+	err := pc.Collect(context.Background(), model.NewInfrastructure())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cmdb unreachable")
+}
+
+func TestPluginCollectorValidate(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb", index: 1}
+	require.NoError(t, pc.Configure(map[string]any{"command": fakePlugin}))
+
+	errs := pc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.plugins[1].config.url", errs[0].Field)
+	assert.Equal(t, "Fake CMDB", pc.Metadata().DisplayName)
+
+	pc = &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{
+		"command": fakePlugin,
+		"config":  map[string]any{"url": "https://cmdb.example"},
+	}))
+	assert.Empty(t, pc.Validate())
+}
+
+func TestPluginCollectorValidateTimeout(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{
+		"command": fakePlugin,
+		"timeout": "200ms",
+		"config":  map[string]any{"hang": true},
+	}))
+	assert.Equal(t, 200*time.Millisecond, pc.Timeout)
+
+	start := time.Now()
+	errs := pc.Validate()
+	assert.Less(t, time.Since(start), 10*time.Second)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Message, "metadata")
+
+	pc = &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{"command": fakePlugin}))
+	assert.Equal(t, pluginValidateTimeout, pc.Timeout)
+}
+
+func TestPluginCollectorValidateProtocol(t *testing.T) {
+	pc := &PluginCollector{Name: "cmdb"}
+	require.NoError(t, pc.Configure(map[string]any{
+		"command": fakePlugin,
+		"config":  map[string]any{"unversioned": true},
+	}))
+
+	errs := pc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.plugins[0].command", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "no protocol version")
+}
+
+func TestPluginCollectorValidateMissingCommand(t *testing.T) {
+	pc := &PluginCollector{Name: "nope"}
+	require.NoError(t, pc.Configure(map[string]any{}))
+	assert.Equal(t, "inframap-collector-nope", pc.Command)
+
+	errs := pc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.plugins[0].command", errs[0].Field)
+}
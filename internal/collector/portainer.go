@@ -1,11 +1,12 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,21 +17,27 @@ func init() {
 	Register(func() RegisteredCollector { return &PortainerCollector{} })
 }
 
-// PortainerCollector collects containers from a Portainer instance.
+// PortainerCollector collects containers from a Portainer instance: from one
+// endpoint, or from every Docker environment it manages, each mapped to its
+// own server, along with the services of swarm environments.
 type PortainerCollector struct {
 	URL      string
 	APIKey   string
 	Endpoint int
 	Server   string // hostname to assign containers to
-	// TestFile for testing (bypasses HTTP calls)
-	TestFile string
+	// AllEndpoints lists every environment instead of reading Endpoint.
+	AllEndpoints bool
+	// Servers maps endpoint names (lowercase) or IDs to server hostnames;
+	// other endpoints are named after themselves.
+	Servers        map[string]string
+	IncludeStopped bool // also list containers and services that aren't running
 }
 
 func (pc *PortainerCollector) Metadata() CollectorMetadata {
 	return CollectorMetadata{
 		Name:        "portainer",
 		DisplayName: "Portainer",
-		Description: "Collects containers from Portainer via its API",
+		Description: "Collects containers, stacks and swarm services from Portainer via its API",
 		ConfigKey:   "portainer",
 		DetectHint:  "",
 	}
@@ -68,8 +75,18 @@ func (pc *PortainerCollector) Configure(section map[string]any) error {
 	if v, ok := section["server"].(string); ok {
 		pc.Server = v
 	}
-	if v, ok := section["test_file"].(string); ok {
-		pc.TestFile = v
+	if v, ok := section["all_endpoints"].(bool); ok {
+		pc.AllEndpoints = v
+	}
+	// Keys are lowercased by the config loader, so names match regardless of case.
+	if m, ok := section["servers"].(map[string]any); ok {
+		pc.Servers = make(map[string]string, len(m))
+		for endpoint, server := range m {
+			pc.Servers[strings.ToLower(endpoint)] = toString(server)
+		}
+	}
+	if v, ok := section["include_stopped"].(bool); ok {
+		pc.IncludeStopped = v
 	}
 	if pc.Endpoint == 0 {
 		pc.Endpoint = 1
@@ -93,6 +110,13 @@ func (pc *PortainerCollector) Validate() []ValidationError {
 			Suggestion: "create an API key in Portainer: User Settings → Access tokens",
 		})
 	}
+	if len(pc.Servers) > 0 && !pc.AllEndpoints {
+		errs = append(errs, ValidationError{
+			Field:      "sources.portainer.servers",
+			Message:    "servers is only used with all_endpoints",
+			Suggestion: "set all_endpoints: true, or use server to name the host of a single endpoint",
+		})
+	}
 	return errs
 }
 
@@ -111,8 +135,34 @@ type portainerPort struct {
 	Type        string `json:"Type"`
 }
 
-func (pc *PortainerCollector) Collect(infra *model.Infrastructure) error {
-	containers, err := pc.getContainers()
+// portainerEndpoint is an environment as /api/endpoints lists it.
+type portainerEndpoint struct {
+	ID        int    `json:"Id"`
+	Name      string `json:"Name"`
+	Type      int    `json:"Type"`   // 1 Docker, 2 agent, 4 Edge agent; 3 and 5-7 are ACI and Kubernetes
+	Status    int    `json:"Status"` // 1 up, 2 down
+	Snapshots []struct {
+		Swarm bool `json:"Swarm"`
+	} `json:"Snapshots"`
+}
+
+// docker tells whether the environment runs the Docker Engine.
+func (ep portainerEndpoint) docker() bool {
+	return ep.Type == 1 || ep.Type == 2 || ep.Type == 4
+}
+
+// swarm tells whether the environment was a swarm manager when Portainer
+// last took a snapshot of it.
+func (ep portainerEndpoint) swarm() bool {
+	return len(ep.Snapshots) > 0 && ep.Snapshots[len(ep.Snapshots)-1].Swarm
+}
+
+func (pc *PortainerCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	if pc.AllEndpoints {
+		return pc.collectEndpoints(ctx, infra)
+	}
+
+	containers, err := pc.getContainers(ctx, pc.Endpoint)
 	if err != nil {
 		return fmt.Errorf("getting containers: %w", err)
 	}
@@ -121,7 +171,90 @@ func (pc *PortainerCollector) Collect(infra *model.Infrastructure) error {
 	if serverName == "" {
 		serverName = "portainer"
 	}
+	pc.addContainers(infra, serverName, containers, pc.location(pc.Endpoint))
+	return nil
+}
+
+// collectEndpoints collects every Docker environment Portainer manages. An
+// environment that is down or fails to answer is skipped with a warning.
+func (pc *PortainerCollector) collectEndpoints(ctx context.Context, infra *model.Infrastructure) error {
+	var endpoints []portainerEndpoint
+	if err := pc.apiGet(ctx, "/api/endpoints", &endpoints); err != nil {
+		return fmt.Errorf("listing endpoints: %w", err)
+	}
+
+	stats := statsFrom(ctx)
+	for _, ep := range endpoints {
+		switch {
+		case !ep.docker():
+			stats.warn("skipping endpoint %s: not a Docker environment", ep.Name)
+		case ep.Status != 1:
+			stats.warn("skipping endpoint %s: environment is down", ep.Name)
+		default:
+			if err := pc.collectEndpoint(ctx, infra, ep); err != nil {
+				stats.warn("skipping endpoint %s: %v", ep.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// collectEndpoint adds the containers of an environment to its server. A
+// swarm's services are read as well; its task containers are left out, since
+// their service stands for them.
+func (pc *PortainerCollector) collectEndpoint(ctx context.Context, infra *model.Infrastructure, ep portainerEndpoint) error {
+	containers, err := pc.getContainers(ctx, ep.ID)
+	if err != nil {
+		return fmt.Errorf("getting containers: %w", err)
+	}
+
+	serverName := pc.serverFor(ep)
+	location := pc.location(ep.ID)
+	if ep.swarm() {
+		objs := swarmObjects{name: serverName, location: location, includeStopped: pc.IncludeStopped}
+		docker := fmt.Sprintf("/api/endpoints/%d/docker", ep.ID)
+		if err := pc.apiGet(ctx, docker+"/nodes", &objs.nodes); err != nil {
+			return fmt.Errorf("getting swarm nodes: %w", err)
+		}
+		if err := pc.apiGet(ctx, docker+"/services", &objs.services); err != nil {
+			return fmt.Errorf("getting swarm services: %w", err)
+		}
+		if err := pc.apiGet(ctx, docker+"/tasks", &objs.tasks); err != nil {
+			return fmt.Errorf("getting swarm tasks: %w", err)
+		}
+		if err := pc.apiGet(ctx, docker+"/networks", &objs.networks); err != nil {
+			return fmt.Errorf("getting networks: %w", err)
+		}
+		objs.build(infra)
+
+		standalone := containers[:0]
+		for _, c := range containers {
+			if c.Labels["com.docker.swarm.task.id"] == "" {
+				standalone = append(standalone, c)
+			}
+		}
+		containers = standalone
+	}
 
+	pc.addContainers(infra, serverName, containers, location)
+	return nil
+}
+
+// serverFor names the server an environment's containers are assigned to.
+func (pc *PortainerCollector) serverFor(ep portainerEndpoint) string {
+	if server, ok := pc.Servers[strconv.Itoa(ep.ID)]; ok {
+		return server
+	}
+	name := strings.ToLower(ep.Name)
+	if server, ok := pc.Servers[name]; ok {
+		return server
+	}
+	return name
+}
+
+// addContainers adds containers as services of serverName, grouped by the
+// compose project or swarm stack they were deployed as.
+func (pc *PortainerCollector) addContainers(infra *model.Infrastructure, serverName string, containers []portainerContainer, location string) {
 	// Ensure server exists
 	server, exists := infra.Servers[serverName]
 	if !exists {
@@ -133,18 +266,23 @@ func (pc *PortainerCollector) Collect(infra *model.Infrastructure) error {
 		}
 		infra.Servers[serverName] = server
 	}
+	server.AddSource(location)
 
 	for _, c := range containers {
-		if c.State != "running" {
+		if c.State != "running" && !pc.IncludeStopped {
 			continue
 		}
 
 		name := containerName(c.Names)
 
 		svc := &model.Service{
-			Name:  name,
-			Image: c.Image,
-			Type:  detectServiceType(c.Image, name),
+			Name:           name,
+			Image:          c.Image,
+			Type:           detectServiceType(c.Image, name),
+			ContainerName:  name,
+			Project:        c.Labels["com.docker.compose.project"],
+			ComposeService: c.Labels["com.docker.compose.service"],
+			Status:         c.State,
 		}
 
 		// Ports
@@ -158,54 +296,53 @@ func (pc *PortainerCollector) Collect(infra *model.Infrastructure) error {
 			}
 		}
 
-		// Use compose project as category if available
+		// Portainer deploys stacks as compose projects, or as swarm stacks
+		// on a swarm; either names the category.
 		if project, ok := c.Labels["com.docker.compose.project"]; ok {
 			svc.Category = project
+		} else if stack, ok := c.Labels["com.docker.stack.namespace"]; ok {
+			svc.Category = stack
+			svc.Project = stack
 		}
+		svc.AddSource(location)
 
 		server.AddService(svc)
 	}
-
-	return nil
 }
 
-func (pc *PortainerCollector) getContainers() ([]portainerContainer, error) {
-	if pc.TestFile != "" {
-		data, err := os.ReadFile(pc.TestFile)
-		if err != nil {
-			return nil, err
-		}
-		var containers []portainerContainer
-		if err := json.Unmarshal(data, &containers); err != nil {
-			return nil, err
-		}
-		return containers, nil
+func (pc *PortainerCollector) getContainers(ctx context.Context, endpoint int) ([]portainerContainer, error) {
+	path := fmt.Sprintf("/api/endpoints/%d/docker/containers/json?all=%t", endpoint, pc.IncludeStopped)
+	var containers []portainerContainer
+	if err := pc.apiGet(ctx, path, &containers); err != nil {
+		return nil, err
 	}
+	return containers, nil
+}
 
-	url := fmt.Sprintf("%s/api/endpoints/%d/docker/containers/json?all=false", pc.URL, pc.Endpoint)
-	req, err := http.NewRequest("GET", url, nil)
+// apiGet decodes the response to a GET of path into v.
+func (pc *PortainerCollector) apiGet(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", pc.URL+path, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Set("X-API-Key", pc.APIKey)
 
 	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	status, body, err := doHTTP(ctx, client, req)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("portainer API returned %d: %s", resp.StatusCode, string(body))
+	if status != http.StatusOK {
+		return fmt.Errorf("portainer API returned %d: %s", status, string(body))
 	}
 
-	var containers []portainerContainer
-	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
-		return nil, err
-	}
-	return containers, nil
+	return json.Unmarshal(body, v)
+}
+
+// location describes where containers were read from, for provenance.
+func (pc *PortainerCollector) location(endpoint int) string {
+	return fmt.Sprintf("%s endpoint %d", pc.URL, endpoint)
 }
 
 // containerName extracts a clean name from Docker container names (removes leading /).
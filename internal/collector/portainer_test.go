@@ -1,6 +1,12 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -11,15 +17,17 @@ import (
 func TestPortainerCollector(t *testing.T) {
 	pc := &PortainerCollector{
 		Server:   "myhost",
-		TestFile: "../../testdata/portainer/containers.json",
+		URL:      "https://portainer.local:9443",
+		Endpoint: 1,
 	}
 
 	infra := model.NewInfrastructure()
-	err := pc.Collect(infra)
+	err := pc.Collect(replaying(t, "portainer"), infra)
 	require.NoError(t, err)
 
 	assert.Contains(t, infra.Servers, "myhost")
 	server := infra.Servers["myhost"]
+	assert.Equal(t, []model.Source{{Location: "https://portainer.local:9443 endpoint 1"}}, server.Sources)
 
 	// Should have 4 running containers (stopped-app excluded)
 	assert.Len(t, server.Services, 4)
@@ -38,11 +46,12 @@ func TestPortainerCollector(t *testing.T) {
 func TestPortainerContainerTypes(t *testing.T) {
 	pc := &PortainerCollector{
 		Server:   "typetest",
-		TestFile: "../../testdata/portainer/containers.json",
+		URL:      "https://portainer.local:9443",
+		Endpoint: 1,
 	}
 
 	infra := model.NewInfrastructure()
-	err := pc.Collect(infra)
+	err := pc.Collect(replaying(t, "portainer"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["typetest"]
@@ -56,11 +65,12 @@ func TestPortainerContainerTypes(t *testing.T) {
 func TestPortainerCategories(t *testing.T) {
 	pc := &PortainerCollector{
 		Server:   "cattest",
-		TestFile: "../../testdata/portainer/containers.json",
+		URL:      "https://portainer.local:9443",
+		Endpoint: 1,
 	}
 
 	infra := model.NewInfrastructure()
-	err := pc.Collect(infra)
+	err := pc.Collect(replaying(t, "portainer"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["cattest"]
@@ -79,11 +89,12 @@ func TestPortainerCategories(t *testing.T) {
 func TestPortainerPorts(t *testing.T) {
 	pc := &PortainerCollector{
 		Server:   "porttest",
-		TestFile: "../../testdata/portainer/containers.json",
+		URL:      "https://portainer.local:9443",
+		Endpoint: 1,
 	}
 
 	infra := model.NewInfrastructure()
-	err := pc.Collect(infra)
+	err := pc.Collect(replaying(t, "portainer"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["porttest"]
@@ -110,3 +121,184 @@ func TestPortainerEnabled(t *testing.T) {
 		},
 	}))
 }
+
+// fakePortainerAPI serves testdata/portainer/api, accepting the API key
+// ptr_secret. Container lists leave out containers that aren't running unless
+// asked for all of them, as Docker does.
+func fakePortainerAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "ptr_secret" {
+			http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/json"), "/api")
+		data, err := os.ReadFile("../../testdata/portainer/api" + path + ".json")
+		if err != nil {
+			http.Error(w, `{"message":"Unable to proxy the request"}`, http.StatusBadGateway)
+			return
+		}
+		if strings.HasSuffix(path, "/containers") && r.URL.Query().Get("all") != "true" {
+			var containers []map[string]any
+			require.NoError(t, json.Unmarshal(data, &containers))
+			running := []map[string]any{}
+			for _, c := range containers {
+				if c["State"] == "running" {
+					running = append(running, c)
+				}
+			}
+			data, _ = json.Marshal(running)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serviceNames(server *model.Server) []string {
+	var names []string
+	for _, svc := range server.Services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+func TestPortainerAllEndpoints(t *testing.T) {
+	srv := fakePortainerAPI(t)
+	pc := &PortainerCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"url":           srv.URL,
+		"api_key":       "ptr_secret",
+		"all_endpoints": true,
+		"servers":       map[string]any{"local": "nas", "3": "pi"},
+	}))
+	require.Empty(t, pc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, pc.Collect(withStats(context.Background(), stats), infra))
+
+	// endpoints, then containers of 1, 2 (with its swarm), 3 and 6
+	assert.Equal(t, 9, stats.APICalls)
+	assert.Equal(t, []string{
+		"skipping endpoint k3s: not a Docker environment",
+		"skipping endpoint old-vps: environment is down",
+		"skipping endpoint lab: getting containers: portainer API returned 502: {\"message\":\"Unable to proxy the request\"}\n",
+	}, stats.Warnings)
+
+	// Environments are mapped by name or ID, or named after themselves.
+	require.Contains(t, infra.Servers, "nas")
+	assert.ElementsMatch(t, []string{"nextcloud", "nextcloud-db"}, serviceNames(infra.Servers["nas"]))
+	for _, svc := range infra.Servers["nas"].Services {
+		assert.Equal(t, "nextcloud", svc.Category, svc.Name)
+		assert.Equal(t, "running", svc.Status, svc.Name)
+	}
+	assert.Equal(t, []model.Source{{Location: srv.URL + " endpoint 1"}}, infra.Servers["nas"].Sources)
+	require.Contains(t, infra.Servers, "pi")
+	assert.Equal(t, []string{"pihole"}, serviceNames(infra.Servers["pi"]))
+	assert.NotContains(t, infra.Servers, "local")
+	assert.NotContains(t, infra.Servers, "lab")
+
+	// The swarm endpoint draws its services, not their task containers,
+	// next to its standalone containers.
+	swarm := infra.Servers["production-swarm"]
+	require.NotNil(t, swarm)
+	assert.Equal(t, model.ServerTypeCluster, swarm.Type)
+	assert.ElementsMatch(t, []string{"web_nginx", "monitoring_node-exporter", "registry"}, serviceNames(swarm))
+
+	byName := make(map[string]*model.Service)
+	for _, svc := range swarm.Services {
+		byName[svc.Name] = svc
+	}
+	nginx := byName["web_nginx"]
+	assert.Equal(t, "nginx:1.27", nginx.Image)
+	assert.Equal(t, "Replicated service", nginx.Kind)
+	assert.Equal(t, 2, nginx.Replicas)
+	assert.Equal(t, "web", nginx.Category)
+	assert.Equal(t, []model.PortMapping{{HostPort: 80, ContainerPort: 80, Protocol: "tcp"}}, nginx.Ports)
+	assert.Equal(t, []string{"web_default"}, nginx.Networks)
+	assert.Equal(t, []model.Source{{Location: srv.URL + " endpoint 2 service web_nginx"}}, nginx.Sources)
+
+	exporter := byName["monitoring_node-exporter"]
+	assert.Equal(t, "Global service", exporter.Kind)
+	assert.Equal(t, 2, exporter.Replicas)
+	assert.Equal(t, "monitoring", exporter.Category)
+	assert.Equal(t, []string{"monitoring_net"}, exporter.Networks, "only overlay networks")
+
+	require.Contains(t, infra.Networks, "web_default")
+	assert.Equal(t, []string{"web_nginx"}, infra.Networks["web_default"].Services)
+	assert.NotContains(t, infra.Networks, "bridge")
+
+	// Nodes are servers of their own, that tasks are placed on.
+	manager := infra.Servers["swarm-manager"]
+	require.NotNil(t, manager)
+	assert.True(t, manager.Online)
+	assert.Equal(t, []string{"192.168.1.41"}, manager.Addresses)
+	assert.Equal(t, []string{"role=manager", "leader"}, manager.Tags)
+	drained := infra.Servers["swarm-worker-2"]
+	require.NotNil(t, drained)
+	assert.False(t, drained.Online)
+	assert.Equal(t, []string{"role=worker", "availability=drain", "status=down"}, drained.Tags)
+
+	assert.ElementsMatch(t, []model.Connection{
+		{From: "production-swarm/web_nginx", To: "swarm-manager", Label: "1 task", Style: "dashed"},
+		{From: "production-swarm/web_nginx", To: "swarm-worker-1", Label: "1 task", Style: "dashed"},
+		{From: "production-swarm/monitoring_node-exporter", To: "swarm-manager", Label: "1 task", Style: "dashed"},
+		{From: "production-swarm/monitoring_node-exporter", To: "swarm-worker-1", Label: "1 task", Style: "dashed"},
+	}, infra.Connections)
+}
+
+func TestPortainerIncludeStopped(t *testing.T) {
+	srv := fakePortainerAPI(t)
+	pc := &PortainerCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"url":             srv.URL,
+		"api_key":         "ptr_secret",
+		"all_endpoints":   true,
+		"include_stopped": true,
+	}))
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, pc.Collect(context.Background(), infra))
+
+	status := make(map[string]string)
+	for _, name := range []string{"local", "production-swarm"} {
+		require.Contains(t, infra.Servers, name)
+		for _, svc := range infra.Servers[name].Services {
+			status[svc.Name] = svc.Status
+		}
+	}
+	assert.Equal(t, "exited", status["restic-backup"])
+	assert.Equal(t, "stopped", status["web_api"])
+	assert.Equal(t, "running", status["web_nginx"])
+}
+
+func TestPortainerSingleEndpointAPI(t *testing.T) {
+	srv := fakePortainerAPI(t)
+	pc := &PortainerCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"url":      srv.URL,
+		"api_key":  "ptr_secret",
+		"endpoint": 3,
+	}))
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, pc.Collect(context.Background(), infra))
+
+	require.Contains(t, infra.Servers, "portainer")
+	assert.Equal(t, []string{"pihole"}, serviceNames(infra.Servers["portainer"]))
+	assert.Equal(t, "dns", infra.Servers["portainer"].Services[0].Category)
+}
+
+func TestPortainerValidateServers(t *testing.T) {
+	pc := &PortainerCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"url":     "https://portainer.local:9443",
+		"api_key": "ptr_secret",
+		"servers": map[string]any{"local": "nas"},
+	}))
+	errs := pc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.portainer.servers", errs[0].Field)
+}
@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/config"
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
+
+// precedenceDefault is the merge.precedence key that applies to every field
+// without a list of its own.
+const precedenceDefault = "default"
+
+// precedence is the merge.precedence policy: for each server field (or
+// "default"), the collectors whose value wins, most trusted first.
+// Collectors not listed rank below those that are.
+type precedence map[string][]string
+
+// serverField is a server field that collectors may disagree on.
+type serverField struct {
+	name string
+	get  func(*model.Server) string
+	set  func(*model.Server, string)
+	// fallback reports whether the incoming value replaces the current one
+	// when the policy does not decide.
+	fallback func(current, incoming string) bool
+}
+
+// lastWins is the historical rule: results merge in registry order and the
+// later collector's value stands.
+func lastWins(_, _ string) bool { return true }
+
+var serverFields = []serverField{
+	{
+		name:     "label",
+		get:      func(s *model.Server) string { return s.Label },
+		set:      func(s *model.Server, v string) { s.Label = v },
+		fallback: func(_, _ string) bool { return false },
+	},
+	{
+		name: "type",
+		get:  func(s *model.Server) string { return string(s.Type) },
+		set:  func(s *model.Server, v string) { s.Type = model.ServerType(v) },
+		fallback: func(current, incoming string) bool {
+			return serverTypeRank[model.ServerType(incoming)] > serverTypeRank[model.ServerType(current)]
+		},
+	},
+	{
+		name:     "os",
+		get:      func(s *model.Server) string { return s.OS },
+		set:      func(s *model.Server, v string) { s.OS = v },
+		fallback: lastWins,
+	},
+	{
+		name:     "online",
+		get:      func(s *model.Server) string { return strconv.FormatBool(s.Online) },
+		set:      func(s *model.Server, v string) { s.Online = v == "true" },
+		fallback: lastWins,
+	},
+	{
+		name:     "public_ip",
+		get:      func(s *model.Server) string { return s.PublicIP },
+		set:      func(s *model.Server, v string) { s.PublicIP = v },
+		fallback: lastWins,
+	},
+	{
+		name:     "tailscale_ip",
+		get:      func(s *model.Server) string { return s.TailscaleIP },
+		set:      func(s *model.Server, v string) { s.TailscaleIP = v },
+		fallback: lastWins,
+	},
+}
+
+// ValidatePrecedence checks the merge.precedence policy against the
+// available collectors.
+func ValidatePrecedence(cfg config.MergeConfig, collectors []RegisteredCollector) error {
+	return precedence(cfg.Precedence).validate(collectors)
+}
+
+// validate checks that the policy only names known fields and collectors.
+func (p precedence) validate(collectors []RegisteredCollector) error {
+	known := make(map[string]bool, len(collectors))
+	for _, c := range collectors {
+		known[c.Metadata().Name] = true
+	}
+	for _, field := range sortedKeys(p) {
+		if field != precedenceDefault && !isServerField(field) {
+			names := []string{precedenceDefault}
+			for _, f := range serverFields {
+				names = append(names, f.name)
+			}
+			return fmt.Errorf("unknown field %q (use %s)", field, strings.Join(names, ", "))
+		}
+		for _, name := range p[field] {
+			if !known[name] {
+				return fmt.Errorf("%s: unknown collector %q", field, name)
+			}
+		}
+	}
+	return nil
+}
+
+func isServerField(name string) bool {
+	for _, f := range serverFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decide reports whether incoming's value replaces current's for field.
+// decided is false when the policy says nothing about the field or ranks
+// both collectors the same.
+func (p precedence) decide(field, current, incoming string) (replace, decided bool) {
+	order, ok := p[field]
+	if !ok {
+		order = p[precedenceDefault]
+	}
+	if len(order) == 0 {
+		return false, false
+	}
+	rc, ri := rankIn(order, current), rankIn(order, incoming)
+	return ri < rc, ri != rc
+}
+
+func rankIn(order []string, collector string) int {
+	for i, name := range order {
+		if name == collector {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// mergeFields folds src's field values into dst under the policy and returns
+// every disagreement as a conflict, whichever rule settled it.
+func (p precedence) mergeFields(dst, src *model.Server) []model.Conflict {
+	var conflicts []model.Conflict
+	for _, f := range serverFields {
+		incoming := f.get(src)
+		if incoming == "" {
+			continue
+		}
+		from := src.FieldSources[f.name]
+		current := f.get(dst)
+		if current == "" {
+			f.set(dst, incoming)
+			setFieldSource(dst, f.name, from)
+			continue
+		}
+		if current == incoming {
+			continue
+		}
+
+		owner := dst.FieldSources[f.name]
+		replace, decided := p.decide(f.name, owner, from)
+		if !decided {
+			replace = f.fallback(current, incoming)
+		}
+		c := model.Conflict{Server: dst.Hostname, Field: f.name,
+			Kept: current, KeptFrom: owner, Dropped: incoming, DroppedFrom: from}
+		if replace {
+			c.Kept, c.KeptFrom, c.Dropped, c.DroppedFrom = incoming, from, current, owner
+		}
+		conflicts = append(conflicts, c)
+		if replace {
+			f.set(dst, incoming)
+			setFieldSource(dst, f.name, from)
+		}
+	}
+	return conflicts
+}
+
+// stampFieldSources attributes every field a collector set on a server to it.
+func stampFieldSources(server *model.Server, collector string) {
+	for _, f := range serverFields {
+		if f.get(server) != "" && server.FieldSources[f.name] == "" {
+			setFieldSource(server, f.name, collector)
+		}
+	}
+}
+
+func setFieldSource(s *model.Server, field, collector string) {
+	if s.FieldSources == nil {
+		s.FieldSources = make(map[string]string)
+	}
+	s.FieldSources[field] = collector
+}
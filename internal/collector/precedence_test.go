@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// precedenceFixture returns the results of three collectors that disagree
+// about the same host.
+func precedenceFixture() (ansible, proxmox, tailscale *model.Infrastructure) {
+	ansible = model.NewInfrastructure()
+	ansible.Servers["pve1"] = &model.Server{Hostname: "pve1", Type: model.ServerTypeLab, Online: true}
+	stampSources(ansible, "ansible")
+
+	proxmox = model.NewInfrastructure()
+	proxmox.Servers["pve1"] = &model.Server{Hostname: "pve1", Type: model.ServerTypeHypervisor, OS: "Proxmox VE", Online: true}
+	stampSources(proxmox, "proxmox")
+
+	tailscale = model.NewInfrastructure()
+	tailscale.Devices["pve1"] = &model.Device{Hostname: "pve1", OS: "linux", Online: false}
+	stampSources(tailscale, "tailscale")
+	return ansible, proxmox, tailscale
+}
+
+func TestPrecedenceDefaultRules(t *testing.T) {
+	ansible, proxmox, tailscale := precedenceFixture()
+
+	dst := model.NewInfrastructure()
+	for _, src := range []*model.Infrastructure{ansible, proxmox, tailscale} {
+		mergeInto(dst, src, nil)
+	}
+
+	pve1 := dst.Servers["pve1"]
+	assert.Equal(t, model.ServerTypeHypervisor, pve1.Type)
+	assert.Equal(t, "linux", pve1.OS)
+	assert.False(t, pve1.Online)
+	assert.Equal(t, "proxmox", pve1.FieldSources["type"])
+	assert.Equal(t, "tailscale", pve1.FieldSources["os"])
+	// Without a policy, the disagreements are still reported.
+	assert.Equal(t, []string{
+		`pve1: type is "hypervisor" per proxmox but "lab" per ansible; kept proxmox`,
+		`pve1: os is "linux" per tailscale but "Proxmox VE" per proxmox; kept tailscale`,
+		`pve1: online is "false" per tailscale but "true" per ansible; kept tailscale`,
+	}, conflictStrings(dst.Conflicts))
+}
+
+func TestPrecedencePerField(t *testing.T) {
+	ansible, proxmox, tailscale := precedenceFixture()
+	prec := precedence{
+		"type": {"ansible", "proxmox"},
+		"os":   {"proxmox"},
+	}
+
+	dst := model.NewInfrastructure()
+	for _, src := range []*model.Infrastructure{ansible, proxmox, tailscale} {
+		mergeInto(dst, src, prec)
+	}
+
+	pve1 := dst.Servers["pve1"]
+	assert.Equal(t, model.ServerTypeLab, pve1.Type)
+	assert.Equal(t, "Proxmox VE", pve1.OS)
+	assert.False(t, pve1.Online, "online is not covered, the last collector wins")
+
+	require.Len(t, dst.Conflicts, 3)
+	assert.Equal(t, model.Conflict{
+		Server: "pve1", Field: "type",
+		Kept: "lab", KeptFrom: "ansible",
+		Dropped: "hypervisor", DroppedFrom: "proxmox",
+	}, dst.Conflicts[0])
+	assert.Equal(t, model.Conflict{
+		Server: "pve1", Field: "os",
+		Kept: "Proxmox VE", KeptFrom: "proxmox",
+		Dropped: "linux", DroppedFrom: "tailscale",
+	}, dst.Conflicts[1])
+	assert.Equal(t, model.Conflict{
+		Server: "pve1", Field: "online",
+		Kept: "false", KeptFrom: "tailscale",
+		Dropped: "true", DroppedFrom: "ansible",
+	}, dst.Conflicts[2], "not covered, but still reported")
+	assert.Equal(t, `pve1: type is "lab" per ansible but "hypervisor" per proxmox; kept ansible`, dst.Conflicts[0].String())
+}
+
+func conflictStrings(conflicts []model.Conflict) []string {
+	var out []string
+	for _, c := range conflicts {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+func TestPrecedenceDefaultList(t *testing.T) {
+	ansible, _, tailscale := precedenceFixture()
+	prec := precedence{precedenceDefault: {"tailscale", "ansible"}}
+
+	dst := model.NewInfrastructure()
+	mergeInto(dst, ansible, prec)
+	mergeInto(dst, tailscale, prec)
+
+	assert.False(t, dst.Servers["pve1"].Online)
+	require.Len(t, dst.Conflicts, 1)
+	assert.Equal(t, "online", dst.Conflicts[0].Field)
+	assert.Equal(t, "tailscale", dst.Conflicts[0].KeptFrom)
+}
+
+func TestPrecedenceValidate(t *testing.T) {
+	collectors := []RegisteredCollector{&AnsibleCollector{}, &ProxmoxCollector{}}
+
+	assert.NoError(t, precedence{"type": {"ansible", "proxmox"}}.validate(collectors))
+	assert.ErrorContains(t, precedence{"hostname": {"ansible"}}.validate(collectors), `unknown field "hostname"`)
+	assert.ErrorContains(t, precedence{"default": {"cmdb"}}.validate(collectors), `unknown collector "cmdb"`)
+}
@@ -1,15 +1,24 @@
 package collector
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
 )
 
 func init() {
@@ -17,14 +26,28 @@ func init() {
 }
 
 // ProxmoxCollector collects VMs and containers from Proxmox VE via its API.
+// It authenticates with an API token, or else logs in with a username and
+// password for a ticket.
 type ProxmoxCollector struct {
 	APIURL   string
 	TokenID  string
 	Token    string
+	Username string // "user@realm", or a user of Realm
+	Password string
+	Realm    string // default: pam
 	Insecure bool
-	// TestData paths for testing (bypasses HTTP calls)
-	TestNodes     string
-	TestResources string
+	// CAFile is a PEM bundle to verify the API's certificate with, and
+	// Fingerprint the SHA-256 fingerprint it must have instead.
+	CAFile      string
+	Fingerprint string
+	// IncludeStopped keeps guests that aren't running, IncludeTemplates
+	// VM and container templates.
+	IncludeStopped   bool
+	IncludeTemplates bool
+
+	client *http.Client
+	ticket string // PVEAuthCookie of a password login
+	csrf   string // CSRFPreventionToken that goes with it
 }
 
 func (pc *ProxmoxCollector) Metadata() CollectorMetadata {
@@ -65,9 +88,33 @@ func (pc *ProxmoxCollector) Configure(section map[string]any) error {
 	if pc.Token == "" {
 		pc.Token = os.Getenv("INFRAMAP_PROXMOX_TOKEN")
 	}
+	if v, ok := section["username"].(string); ok {
+		pc.Username = v
+	}
+	if v, ok := section["password"].(string); ok {
+		pc.Password = v
+	}
+	if pc.Password == "" {
+		pc.Password = os.Getenv("INFRAMAP_PROXMOX_PASSWORD")
+	}
+	if v, ok := section["realm"].(string); ok {
+		pc.Realm = v
+	}
+	if v, ok := section["ca_file"].(string); ok {
+		pc.CAFile = util.ExpandPath(v)
+	}
+	if v, ok := section["fingerprint"].(string); ok {
+		pc.Fingerprint = v
+	}
 	if v, ok := section["insecure"].(bool); ok {
 		pc.Insecure = v
 	}
+	if v, ok := section["include_stopped"].(bool); ok {
+		pc.IncludeStopped = v
+	}
+	if v, ok := section["include_templates"].(bool); ok {
+		pc.IncludeTemplates = v
+	}
 	return nil
 }
 
@@ -80,13 +127,38 @@ func (pc *ProxmoxCollector) Validate() []ValidationError {
 			Suggestion: "set the URL of your Proxmox VE instance, e.g. https://pve.local:8006",
 		})
 	}
-	if pc.TokenID == "" || pc.Token == "" {
+	if (pc.TokenID == "" || pc.Token == "") && (pc.Username == "" || pc.Password == "") {
 		errs = append(errs, ValidationError{
 			Field:      "sources.proxmox.token_id",
-			Message:    "token_id and token are required for API authentication",
+			Message:    "token_id and token, or username and password, are required for API authentication",
 			Suggestion: "create an API token in Proxmox: Datacenter → Permissions → API Tokens",
 		})
 	}
+	if pc.CAFile != "" {
+		if _, err := os.Stat(pc.CAFile); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources.proxmox.ca_file",
+				Message:    fmt.Sprintf("file not found: %s", pc.CAFile),
+				Suggestion: "on a PVE node, the cluster CA is /etc/pve/pve-root-ca.pem",
+			})
+		}
+	}
+	if verify := pc.tlsOptions(); len(verify) > 1 {
+		errs = append(errs, ValidationError{
+			Field:      "sources.proxmox." + verify[1],
+			Message:    fmt.Sprintf("%s cannot be combined: each replaces the others' certificate check", strings.Join(verify, " and ")),
+			Suggestion: "keep one of fingerprint, ca_file or insecure",
+		})
+	}
+	if pc.Fingerprint != "" {
+		if _, err := parseFingerprint(pc.Fingerprint); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources.proxmox.fingerprint",
+				Message:    err.Error(),
+				Suggestion: "copy the SHA-256 fingerprint shown under Node → System → Certificates",
+			})
+		}
+	}
 	return errs
 }
 
@@ -108,25 +180,53 @@ type pveNode struct {
 }
 
 type pveResource struct {
-	ID     string  `json:"id"`
-	Type   string  `json:"type"` // "qemu" or "lxc"
-	Node   string  `json:"node"`
-	VMID   int     `json:"vmid"`
-	Name   string  `json:"name"`
-	Status string  `json:"status"`
-	CPU    float64 `json:"cpu"`
-	MaxCPU int     `json:"maxcpu"`
-	Mem    int64   `json:"mem"`
-	MaxMem int64   `json:"maxmem"`
+	ID       string  `json:"id"`
+	Type     string  `json:"type"` // "qemu" or "lxc"
+	Node     string  `json:"node"`
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	CPU      float64 `json:"cpu"`
+	MaxCPU   int     `json:"maxcpu"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	Tags     string  `json:"tags"` // "k3s;prod"
+	Template int     `json:"template"`
 }
 
-func (pc *ProxmoxCollector) Collect(infra *model.Infrastructure) error {
-	nodes, err := pc.getNodes()
+// pveStorage is one entry of /nodes/{node}/storage.
+type pveStorage struct {
+	Storage string `json:"storage"`
+	Type    string `json:"type"`
+	Active  int    `json:"active"`
+	Used    int64  `json:"used"`
+	Total   int64  `json:"total"`
+}
+
+// pveAgentInterfaces is the QEMU guest agent's network-get-interfaces result.
+type pveAgentInterfaces struct {
+	Result []struct {
+		Name        string `json:"name"`
+		IPAddresses []struct {
+			IPAddress string `json:"ip-address"`
+		} `json:"ip-addresses"`
+	} `json:"result"`
+}
+
+// pveLXCInterface is one entry of /nodes/{node}/lxc/{vmid}/interfaces.
+type pveLXCInterface struct {
+	Name  string `json:"name"`
+	Inet  string `json:"inet"`  // "192.168.1.53/24"
+	Inet6 string `json:"inet6"` // "fd00::53/64"
+}
+
+func (pc *ProxmoxCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	nodes, err := pc.getNodes(ctx)
 	if err != nil {
 		return fmt.Errorf("getting nodes: %w", err)
 	}
 
-	resources, err := pc.getResources()
+	resources, err := pc.getResources(ctx)
 	if err != nil {
 		return fmt.Errorf("getting resources: %w", err)
 	}
@@ -146,11 +246,20 @@ func (pc *ProxmoxCollector) Collect(infra *model.Infrastructure) error {
 		} else {
 			server.Type = model.ServerTypeHypervisor
 		}
+		server.AddSource(pc.location("/api2/json/nodes/" + node.Node))
+		server.Storage = pc.getStorage(ctx, node.Node)
 	}
 
 	// Group resources by node
 	for _, res := range resources {
-		if res.Status != "running" {
+		status := res.Status
+		switch {
+		case res.Template == 1:
+			if !pc.IncludeTemplates {
+				continue
+			}
+			status = "template"
+		case status != "running" && !pc.IncludeStopped:
 			continue
 		}
 
@@ -165,10 +274,19 @@ func (pc *ProxmoxCollector) Collect(infra *model.Infrastructure) error {
 		}
 
 		svc := &model.Service{
-			Name:     res.Name,
-			Type:     svcType,
-			Category: "virtualization",
+			Name:        res.Name,
+			Type:        svcType,
+			Category:    "virtualization",
+			Status:      status,
+			VMID:        res.VMID,
+			Tags:        pveTags(res.Tags),
+			CPUs:        res.MaxCPU,
+			CPUUsage:    res.CPU,
+			Memory:      res.MaxMem,
+			MemoryUsage: res.Mem,
+			Addresses:   pc.guestAddresses(ctx, res),
 		}
+		svc.AddSource(pc.location(fmt.Sprintf("/api2/json/nodes/%s/%s", res.Node, res.ID)))
 
 		server.AddService(svc)
 	}
@@ -176,32 +294,229 @@ func (pc *ProxmoxCollector) Collect(infra *model.Infrastructure) error {
 	return nil
 }
 
-func (pc *ProxmoxCollector) getNodes() ([]pveNode, error) {
-	if pc.TestNodes != "" {
-		return loadPVENodes(pc.TestNodes)
+func (pc *ProxmoxCollector) getNodes(ctx context.Context) ([]pveNode, error) {
+	return pc.apiGetNodes(ctx, "/api2/json/nodes")
+}
+
+func (pc *ProxmoxCollector) getResources(ctx context.Context) ([]pveResource, error) {
+	return pc.apiGetResources(ctx, "/api2/json/cluster/resources?type=vm")
+}
+
+// getStorage lists a node's active storage pools. A node whose storage
+// can't be listed is drawn without it.
+func (pc *ProxmoxCollector) getStorage(ctx context.Context, node string) []model.StoragePool {
+	var storage []pveStorage
+	if err := pc.apiGetData(ctx, "/api2/json/nodes/"+node+"/storage", &storage); err != nil {
+		statsFrom(ctx).warn("storage of node %s: %v", node, err)
+		return nil
+	}
+	var pools []model.StoragePool
+	for _, st := range storage {
+		if st.Active != 1 {
+			continue
+		}
+		pools = append(pools, model.StoragePool{Name: st.Storage, Type: st.Type, Used: st.Used, Total: st.Total})
 	}
-	return pc.apiGetNodes("/api2/json/nodes")
+	return pools
 }
 
-func (pc *ProxmoxCollector) getResources() ([]pveResource, error) {
-	if pc.TestResources != "" {
-		return loadPVEResources(pc.TestResources)
+// guestAddresses asks a running guest for its IP addresses: a VM through its
+// QEMU guest agent, a container through its interfaces. Loopback and
+// link-local addresses are left out. Guests without the agent, or that
+// don't answer, simply have no addresses.
+func (pc *ProxmoxCollector) guestAddresses(ctx context.Context, res pveResource) []string {
+	if res.Status != "running" {
+		return nil
+	}
+	base := fmt.Sprintf("/api2/json/nodes/%s/%s/%d", res.Node, res.Type, res.VMID)
+
+	var ips []string
+	switch res.Type {
+	case "qemu":
+		var agent pveAgentInterfaces
+		if err := pc.apiGetData(ctx, base+"/agent/network-get-interfaces", &agent); err != nil {
+			return nil
+		}
+		for _, iface := range agent.Result {
+			for _, addr := range iface.IPAddresses {
+				ips = append(ips, addr.IPAddress)
+			}
+		}
+	case "lxc":
+		var ifaces []pveLXCInterface
+		if err := pc.apiGetData(ctx, base+"/interfaces", &ifaces); err != nil {
+			return nil
+		}
+		for _, iface := range ifaces {
+			ips = append(ips, iface.Inet, iface.Inet6)
+		}
+	}
+
+	var addrs []string
+	for _, ip := range ips {
+		ip, _, _ = strings.Cut(ip, "/")
+		parsed := net.ParseIP(ip)
+		if parsed == nil || parsed.IsLoopback() || parsed.IsLinkLocalUnicast() || containsStr(addrs, ip) {
+			continue
+		}
+		addrs = append(addrs, ip)
 	}
-	return pc.apiGetResources("/api2/json/cluster/resources?type=vm")
+	return addrs
+}
+
+// pveTags splits a guest's tags, separated by semicolons (or, in older
+// releases, commas and spaces).
+func pveTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
 }
 
-func (pc *ProxmoxCollector) httpClient() *http.Client {
-	client := &http.Client{Timeout: 30 * time.Second}
-	if pc.Insecure {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // user-configured
+// tlsOptions lists the certificate options set. Each replaces the others'
+// check, so only one may be.
+func (pc *ProxmoxCollector) tlsOptions() []string {
+	var set []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{{"fingerprint", pc.Fingerprint != ""}, {"ca_file", pc.CAFile != ""}, {"insecure", pc.Insecure}} {
+		if opt.set {
+			set = append(set, opt.name)
 		}
 	}
-	return client
+	return set
 }
 
-func (pc *ProxmoxCollector) apiGetNodes(path string) ([]pveNode, error) {
-	body, err := pc.apiRequest(path)
+// httpClient returns the client for API calls, built on first use. A
+// fingerprint pins the API's certificate in place of the usual chain
+// verification; a CA file replaces the system roots. Only one of them, or
+// insecure, may be set.
+func (pc *ProxmoxCollector) httpClient() (*http.Client, error) {
+	if pc.client != nil {
+		return pc.client, nil
+	}
+	if verify := pc.tlsOptions(); len(verify) > 1 {
+		return nil, fmt.Errorf("%s cannot be combined", strings.Join(verify, " and "))
+	}
+	tlsConfig := &tls.Config{}
+	switch {
+	case pc.Fingerprint != "":
+		want, err := parseFingerprint(pc.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // verified against the pinned fingerprint below
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("certificate fingerprint %s does not match the configured one", formatFingerprint(got[:]))
+			}
+			return nil
+		}
+	case pc.Insecure:
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // user-configured
+	case pc.CAFile != "":
+		pem, err := os.ReadFile(pc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s: no PEM certificates", pc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	pc.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return pc.client, nil
+}
+
+// parseFingerprint decodes a SHA-256 fingerprint written as hex, with or
+// without colons ("AB:CD:...").
+func parseFingerprint(s string) ([]byte, error) {
+	fp, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(s), ":", ""))
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", s)
+	}
+	return fp, nil
+}
+
+func formatFingerprint(fp []byte) string {
+	parts := make([]string, len(fp))
+	for i, b := range fp {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// login exchanges the username and password for a ticket, sent as the
+// PVEAuthCookie on every later call, and the CSRF token that must go with
+// requests that change anything. The exchange stays off the tape, so that
+// recordings and the cache never hold a live ticket.
+func (pc *ProxmoxCollector) login(ctx context.Context) error {
+	client, err := pc.httpClient()
+	if err != nil {
+		return err
+	}
+
+	user := pc.Username
+	if !strings.Contains(user, "@") {
+		realm := pc.Realm
+		if realm == "" {
+			realm = "pam"
+		}
+		user += "@" + realm
+	}
+	form := url.Values{"username": {user}, "password": {pc.Password}}
+	req, err := http.NewRequestWithContext(ctx, "POST", pc.APIURL+"/api2/json/access/ticket", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	status, body, err := doHTTPUntaped(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("proxmox API returned %d for user %s", status, user)
+	}
+
+	var resp struct {
+		Data struct {
+			Ticket string `json:"ticket"`
+			CSRF   string `json:"CSRFPreventionToken"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Data.Ticket == "" {
+		return fmt.Errorf("no ticket for user %s", user)
+	}
+	pc.ticket, pc.csrf = resp.Data.Ticket, resp.Data.CSRF
+	return nil
+}
+
+// authorize adds the API token, or the login ticket, to a request.
+func (pc *ProxmoxCollector) authorize(req *http.Request) {
+	if pc.ticket == "" {
+		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", pc.TokenID, pc.Token))
+		return
+	}
+	req.AddCookie(&http.Cookie{Name: "PVEAuthCookie", Value: pc.ticket})
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		req.Header.Set("CSRFPreventionToken", pc.csrf)
+	}
+}
+
+func (pc *ProxmoxCollector) apiGetNodes(ctx context.Context, path string) ([]pveNode, error) {
+	body, err := pc.apiRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -212,8 +527,8 @@ func (pc *ProxmoxCollector) apiGetNodes(path string) ([]pveNode, error) {
 	return resp.Data, nil
 }
 
-func (pc *ProxmoxCollector) apiGetResources(path string) ([]pveResource, error) {
-	body, err := pc.apiRequest(path)
+func (pc *ProxmoxCollector) apiGetResources(ctx context.Context, path string) ([]pveResource, error) {
+	body, err := pc.apiRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -224,53 +539,51 @@ func (pc *ProxmoxCollector) apiGetResources(path string) ([]pveResource, error)
 	return resp.Data, nil
 }
 
-func (pc *ProxmoxCollector) apiRequest(path string) ([]byte, error) {
-	client := pc.httpClient()
-
-	req, err := http.NewRequest("GET", pc.APIURL+path, nil)
+// apiGetData decodes the data member of an API response into data.
+func (pc *ProxmoxCollector) apiGetData(ctx context.Context, path string, data any) error {
+	body, err := pc.apiRequest(ctx, path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", pc.TokenID, pc.Token))
+	resp := struct {
+		Data any `json:"data"`
+	}{Data: data}
+	return json.Unmarshal(body, &resp)
+}
 
-	resp, err := client.Do(req)
+func (pc *ProxmoxCollector) apiRequest(ctx context.Context, path string) ([]byte, error) {
+	client, err := pc.httpClient()
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	body, err := io.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, "GET", pc.APIURL+path, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("proxmox API returned %d: %s", resp.StatusCode, string(body))
+	// Log in on the first request that reaches the API: one served from a
+	// recording or the cache needs no ticket.
+	if pc.ticket == "" && (pc.TokenID == "" || pc.Token == "") && pc.Username != "" && !fromTape(ctx, req) {
+		if err := pc.login(ctx); err != nil {
+			return nil, fmt.Errorf("logging in: %w", err)
+		}
 	}
+	pc.authorize(req)
 
-	return body, nil
-}
-
-func loadPVENodes(path string) ([]pveNode, error) {
-	data, err := os.ReadFile(path)
+	status, body, err := doHTTP(ctx, client, req)
 	if err != nil {
 		return nil, err
 	}
-	var resp pveNodeResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return nil, err
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("proxmox API returned %d: %s", status, string(body))
 	}
-	return resp.Data, nil
+
+	return body, nil
 }
 
-func loadPVEResources(path string) ([]pveResource, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var resp pveResourceResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return nil, err
-	}
-	return resp.Data, nil
+// location describes where an entity was read from, for provenance: its API
+// path.
+func (pc *ProxmoxCollector) location(path string) string {
+	return pc.APIURL + path
 }
@@ -1,6 +1,14 @@
 package collector
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -9,13 +17,11 @@ import (
 )
 
 func TestProxmoxCollector(t *testing.T) {
-	pc := &ProxmoxCollector{
-		TestNodes:     "../../testdata/proxmox/nodes.json",
-		TestResources: "../../testdata/proxmox/resources.json",
-	}
+	srv := fakeProxmoxAPI(t)
+	pc := &ProxmoxCollector{APIURL: srv.URL, TokenID: "root@pam!inframap", Token: "secret"}
 
 	infra := model.NewInfrastructure()
-	err := pc.Collect(infra)
+	err := pc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Should have 2 hypervisor servers
@@ -53,6 +59,295 @@ func TestProxmoxCollector(t *testing.T) {
 	assert.Equal(t, "truenas", pve2.Services[0].Name)
 }
 
+// fakeProxmoxAPI serves testdata/proxmox as the Proxmox VE API: each path
+// under /api2/json is the JSON file of that name, and the cluster resources
+// are resources.json. Paths without a file answer like a VM whose guest
+// agent isn't running.
+func fakeProxmoxAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(proxmoxAPIHandler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// proxmoxAPIHandler accepts the API token root@pam!inframap=secret, or the
+// ticket it hands out to inframap@pve logging in with password hunter2.
+func proxmoxAPIHandler() http.Handler {
+	const ticket = "PVE:inframap@pve:6720A1B2::signature"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api2/json/access/ticket" {
+			if r.Method != http.MethodPost || r.PostFormValue("username") != "inframap@pve" || r.PostFormValue("password") != "hunter2" {
+				http.Error(w, `{"data":null}`, http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"username":"inframap@pve","ticket":"` + ticket + `","CSRFPreventionToken":"6720A1B2:csrf"}}`))
+			return
+		}
+		cookie, _ := r.Cookie("PVEAuthCookie")
+		if r.Header.Get("Authorization") != "PVEAPIToken=root@pam!inframap=secret" && (cookie == nil || cookie.Value != ticket) {
+			http.Error(w, `{"data":null}`, http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api2/json")
+		if path == "/cluster/resources" {
+			path = "/resources"
+		}
+		data, err := os.ReadFile("../../testdata/proxmox" + path + ".json")
+		if err != nil {
+			http.Error(w, `{"data":null,"message":"QEMU guest agent is not running\n"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+}
+
+func TestProxmoxIncludeStopped(t *testing.T) {
+	srv := fakeProxmoxAPI(t)
+	guests := func(section map[string]any) map[string]string {
+		section["api_url"] = srv.URL
+		section["token_id"] = "root@pam!inframap"
+		section["token"] = "secret"
+		pc := &ProxmoxCollector{}
+		require.NoError(t, pc.Configure(section))
+		infra := model.NewInfrastructure()
+		require.NoError(t, pc.Collect(context.Background(), infra))
+		status := make(map[string]string)
+		for _, server := range infra.Servers {
+			for _, svc := range server.Services {
+				status[svc.Name] = svc.Status
+			}
+		}
+		return status
+	}
+
+	assert.Equal(t, map[string]string{
+		"ubuntu-server": "running", "docker-host": "running", "pihole": "running", "truenas": "running",
+	}, guests(map[string]any{}))
+
+	stopped := guests(map[string]any{"include_stopped": true})
+	assert.Len(t, stopped, 6)
+	assert.Equal(t, "stopped", stopped["windows-desktop"])
+	assert.Equal(t, "stopped", stopped["nextcloud-old"])
+	assert.NotContains(t, stopped, "debian-12-cloudinit", "templates need their own option")
+
+	templates := guests(map[string]any{"include_templates": true})
+	assert.Len(t, templates, 5)
+	assert.Equal(t, "template", templates["debian-12-cloudinit"])
+}
+
+func TestProxmoxGuestDetails(t *testing.T) {
+	srv := fakeProxmoxAPI(t)
+	pc := &ProxmoxCollector{
+		APIURL:           srv.URL,
+		TokenID:          "root@pam!inframap",
+		Token:            "secret",
+		IncludeStopped:   true,
+		IncludeTemplates: true,
+	}
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, pc.Collect(withStats(context.Background(), stats), infra))
+	// nodes, resources, 2 storage lists, 3 guest agents and 1 container;
+	// stopped guests and templates aren't asked for addresses
+	assert.Equal(t, 8, stats.APICalls)
+	assert.Empty(t, stats.Warnings)
+
+	pve1 := infra.Servers["pve1"]
+	assert.Equal(t, []model.StoragePool{
+		{Name: "local", Type: "dir", Used: 12884901888, Total: 100931731456},
+		{Name: "local-lvm", Type: "lvmthin", Used: 214748364800, Total: 858993459200},
+	}, pve1.Storage, "the inactive NFS storage is left out")
+
+	guests := make(map[string]*model.Service)
+	for _, svc := range pve1.Services {
+		guests[svc.Name] = svc
+	}
+
+	// Loopback and link-local addresses are left out.
+	vm := guests["ubuntu-server"]
+	assert.Equal(t, 100, vm.VMID)
+	assert.Equal(t, []string{"192.168.1.50", "100.64.0.50"}, vm.Addresses)
+	assert.Equal(t, []string{"prod", "web"}, vm.Tags)
+	assert.Equal(t, 2, vm.CPUs)
+	assert.InDelta(t, 0.05, vm.CPUUsage, 1e-9)
+	assert.Equal(t, int64(4294967296), vm.Memory)
+	assert.Equal(t, int64(2147483648), vm.MemoryUsage)
+
+	ct := guests["pihole"]
+	assert.Equal(t, []string{"192.168.1.53", "fd00::53"}, ct.Addresses)
+	assert.Equal(t, []string{"dns"}, ct.Tags)
+
+	// No guest agent: no addresses, but the rest is known.
+	assert.Empty(t, guests["docker-host"].Addresses)
+	assert.Equal(t, 4, guests["docker-host"].CPUs)
+
+	assert.Equal(t, []string{"192.168.1.60"}, infra.Servers["pve2"].Services[0].Addresses)
+	assert.Empty(t, guests["nextcloud-old"].Addresses)
+}
+
+func TestProxmoxTicketLogin(t *testing.T) {
+	srv := fakeProxmoxAPI(t)
+	pc := &ProxmoxCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"api_url":  srv.URL,
+		"username": "inframap",
+		"password": "hunter2",
+		"realm":    "pve",
+	}))
+	assert.Empty(t, pc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, pc.Collect(withStats(context.Background(), stats), infra))
+	assert.Len(t, infra.Servers["pve1"].Services, 3)
+	assert.Equal(t, 9, stats.APICalls, "one login, then the same calls as with a token")
+
+	// Requests that change something also carry the CSRF token.
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api2/json/nodes/pve1/qemu/100/status/start", nil)
+	require.NoError(t, err)
+	pc.authorize(req)
+	assert.Equal(t, "6720A1B2:csrf", req.Header.Get("CSRFPreventionToken"))
+	cookie, err := req.Cookie("PVEAuthCookie")
+	require.NoError(t, err)
+	assert.Equal(t, "PVE:inframap@pve:6720A1B2::signature", cookie.Value)
+
+	pc = &ProxmoxCollector{APIURL: srv.URL, Username: "inframap@pve", Password: "wrong"}
+	err = pc.Collect(context.Background(), model.NewInfrastructure())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "logging in: proxmox API returned 401 for user inframap@pve")
+}
+
+func TestProxmoxTicketNotRecorded(t *testing.T) {
+	srv := fakeProxmoxAPI(t)
+	dir := t.TempDir()
+	collect := func(tape *Tape) (*model.Infrastructure, *CollectStats) {
+		t.Helper()
+		cas, err := tape.cassette("proxmox")
+		require.NoError(t, err)
+		pc := &ProxmoxCollector{APIURL: srv.URL, Username: "inframap@pve", Password: "hunter2"}
+		infra := model.NewInfrastructure()
+		stats := &CollectStats{}
+		require.NoError(t, pc.Collect(withStats(withCassette(context.Background(), cas), stats), infra))
+		require.NoError(t, cas.save())
+		return infra, stats
+	}
+
+	rec, err := NewRecorder(dir)
+	require.NoError(t, err)
+	recorded, _ := collect(rec)
+
+	data, err := os.ReadFile(filepath.Join(dir, "proxmox.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "access/ticket")
+	assert.NotContains(t, string(data), "PVE:inframap@pve")
+	assert.NotContains(t, string(data), "6720A1B2:csrf")
+
+	// Replaying needs neither the ticket nor the API.
+	srv.Close()
+	play, err := NewReplayer(dir)
+	require.NoError(t, err)
+	replayed, stats := collect(play)
+	assert.Equal(t, 8, stats.APICalls, "no login")
+	assert.Equal(t, recorded.Servers["pve1"].Services, replayed.Servers["pve1"].Services)
+}
+
+func TestProxmoxTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(proxmoxAPIHandler())
+	t.Cleanup(srv.Close)
+
+	collect := func(section map[string]any) error {
+		section["api_url"] = srv.URL
+		section["token_id"] = "root@pam!inframap"
+		section["token"] = "secret"
+		pc := &ProxmoxCollector{}
+		require.NoError(t, pc.Configure(section))
+		require.Empty(t, pc.Validate())
+		return pc.Collect(context.Background(), model.NewInfrastructure())
+	}
+
+	// A self-signed certificate is refused by default.
+	err := collect(map[string]any{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+
+	// Trusted through the cluster's CA...
+	caFile := filepath.Join(t.TempDir(), "pve-root-ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	assert.NoError(t, collect(map[string]any{"ca_file": caFile}))
+
+	// ...or pinned by fingerprint, as Proxmox shows it.
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	assert.NoError(t, collect(map[string]any{"fingerprint": formatFingerprint(sum[:])}))
+
+	sum[0] ^= 0xff
+	err = collect(map[string]any{"fingerprint": formatFingerprint(sum[:])})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match the configured one")
+
+	// Combined options are refused even when Validate isn't run, as by
+	// generate, rather than one silently winning.
+	pc := &ProxmoxCollector{}
+	require.NoError(t, pc.Configure(map[string]any{
+		"api_url": srv.URL, "token_id": "root@pam!inframap", "token": "secret",
+		"ca_file": caFile, "insecure": true,
+	}))
+	err = pc.Collect(context.Background(), model.NewInfrastructure())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ca_file and insecure cannot be combined")
+}
+
+func TestProxmoxValidateAuth(t *testing.T) {
+	pc := &ProxmoxCollector{APIURL: "https://pve.local:8006", Username: "root"}
+	errs := pc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.proxmox.token_id", errs[0].Field)
+
+	pc = &ProxmoxCollector{
+		APIURL:      "https://pve.local:8006",
+		Username:    "root",
+		Password:    "secret",
+		CAFile:      "/nonexistent/pve-root-ca.pem",
+		Fingerprint: "AB:CD",
+	}
+	var fields []string
+	for _, e := range pc.Validate() {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{"sources.proxmox.ca_file", "sources.proxmox.ca_file", "sources.proxmox.fingerprint"}, fields, "missing, combined with a fingerprint, and invalid")
+}
+
+func TestProxmoxValidateVerification(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "pve-root-ca.pem")
+	require.NoError(t, os.WriteFile(caFile, nil, 0o600))
+	fingerprint := strings.Repeat("AB:", sha256.Size-1) + "AB"
+
+	for _, tc := range []struct {
+		section map[string]any
+		message string
+	}{
+		{map[string]any{"ca_file": caFile, "insecure": true}, "ca_file and insecure cannot be combined"},
+		{map[string]any{"fingerprint": fingerprint, "insecure": true}, "fingerprint and insecure cannot be combined"},
+		{map[string]any{"fingerprint": fingerprint, "ca_file": caFile}, "fingerprint and ca_file cannot be combined"},
+	} {
+		tc.section["api_url"] = "https://pve.local:8006"
+		tc.section["token_id"] = "root@pam!inframap"
+		tc.section["token"] = "secret"
+		pc := &ProxmoxCollector{}
+		require.NoError(t, pc.Configure(tc.section))
+		errs := pc.Validate()
+		require.Len(t, errs, 1, tc.message)
+		assert.Contains(t, errs[0].Message, tc.message)
+	}
+}
+
+func TestProxmoxTags(t *testing.T) {
+	assert.Equal(t, []string{"k3s", "prod"}, pveTags("k3s;prod"))
+	assert.Equal(t, []string{"k3s", "prod"}, pveTags("k3s,prod"))
+	assert.Empty(t, pveTags(""))
+}
+
 func TestProxmoxMetadata(t *testing.T) {
 	pc := &ProxmoxCollector{}
 	meta := pc.Metadata()
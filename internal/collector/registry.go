@@ -1,6 +1,10 @@
 package collector
 
-import "github.com/ThomasCrouzet/inframap-d2/internal/model"
+import (
+	"context"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
 
 // RegisteredCollector defines the interface for self-registering data sources.
 type RegisteredCollector interface {
@@ -8,7 +12,9 @@ type RegisteredCollector interface {
 	Enabled(sources map[string]any) bool
 	Configure(section map[string]any) error
 	Validate() []ValidationError
-	Collect(infra *model.Infrastructure) error
+	// Collect writes what the source reports into infra. Collectors run
+	// concurrently, each with its own infra, and must stop when ctx is done.
+	Collect(ctx context.Context, infra *model.Infrastructure) error
 }
 
 // CollectorMetadata describes a collector for discovery and documentation.
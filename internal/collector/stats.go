@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CollectStats summarises what one collector contributed to a run.
+type CollectStats struct {
+	ServersAdded   int           `json:"servers_added"`
+	ServersUpdated int           `json:"servers_updated"`
+	ServicesAdded  int           `json:"services_added"`
+	DevicesAdded   int           `json:"devices_added"`
+	DevicesUpdated int           `json:"devices_updated"`
+	FilesParsed    int           `json:"files_parsed"`
+	APICalls       int           `json:"api_calls"` // HTTP requests and commands run against the source
+	Warnings       []string      `json:"warnings,omitempty"`
+	Cached         bool          `json:"cached,omitempty"` // served from the on-disk cache
+	Elapsed        time.Duration `json:"-"`
+}
+
+type statsKey struct{}
+
+// withStats attaches a stats tracker to the context handed to a collector.
+func withStats(ctx context.Context, s *CollectStats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// statsFrom returns the tracker for the running collector. Collectors called
+// directly (as in tests) get a throwaway one, so callers never check for nil.
+func statsFrom(ctx context.Context) *CollectStats {
+	if s, ok := ctx.Value(statsKey{}).(*CollectStats); ok {
+		return s
+	}
+	return &CollectStats{}
+}
+
+func (s *CollectStats) fileParsed() { s.FilesParsed++ }
+
+func (s *CollectStats) apiCall() { s.APICalls++ }
+
+func (s *CollectStats) warn(format string, args ...any) {
+	s.Warnings = append(s.Warnings, fmt.Sprintf(format, args...))
+}
+
+// add folds merge counts into s.
+func (s *CollectStats) add(o CollectStats) {
+	s.ServersAdded += o.ServersAdded
+	s.ServersUpdated += o.ServersUpdated
+	s.ServicesAdded += o.ServicesAdded
+	s.DevicesAdded += o.DevicesAdded
+	s.DevicesUpdated += o.DevicesUpdated
+}
+
+// Summary renders the stats as a short line for the CLI,
+// e.g. "3 servers (1 updated), 6 services, 2 files, 14ms".
+func (s CollectStats) Summary() string {
+	var parts []string
+	if n := s.ServersAdded + s.ServersUpdated; n > 0 {
+		parts = append(parts, withUpdated(plural(n, "server"), s.ServersUpdated))
+	}
+	if s.ServicesAdded > 0 {
+		parts = append(parts, plural(s.ServicesAdded, "service"))
+	}
+	if n := s.DevicesAdded + s.DevicesUpdated; n > 0 {
+		parts = append(parts, withUpdated(plural(n, "device"), s.DevicesUpdated))
+	}
+	if s.FilesParsed > 0 {
+		parts = append(parts, plural(s.FilesParsed, "file"))
+	}
+	if s.APICalls > 0 {
+		parts = append(parts, plural(s.APICalls, "API call"))
+	}
+	if len(s.Warnings) > 0 {
+		parts = append(parts, plural(len(s.Warnings), "warning"))
+	}
+	if s.Cached {
+		parts = append(parts, "cached")
+	}
+	if s.Elapsed < time.Millisecond {
+		parts = append(parts, "<1ms")
+	} else {
+		parts = append(parts, s.Elapsed.Round(time.Millisecond).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func withUpdated(s string, updated int) string {
+	if updated == 0 {
+		return s
+	}
+	return fmt.Sprintf("%s (%d updated)", s, updated)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
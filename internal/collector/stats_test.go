@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectStatsSummary(t *testing.T) {
+	s := CollectStats{
+		ServersAdded:   2,
+		ServersUpdated: 1,
+		ServicesAdded:  1,
+		FilesParsed:    2,
+		APICalls:       3,
+		Warnings:       []string{"skipping x"},
+		Elapsed:        1500 * time.Microsecond,
+	}
+	assert.Equal(t, "3 servers (1 updated), 1 service, 2 files, 3 API calls, 1 warning, 2ms", s.Summary())
+	assert.Equal(t, "<1ms", CollectStats{}.Summary())
+}
+
+func TestStatsFromWithoutTracker(t *testing.T) {
+	// Collectors called directly must not need a tracker.
+	assert.NotPanics(t, func() { statsFrom(context.Background()).fileParsed() })
+}
+
+func TestCollectReportsStats(t *testing.T) {
+	collectors := []RegisteredCollector{
+		&fakeCollector{name: "first", collect: func(infra *model.Infrastructure) {
+			infra.Servers["atlas"] = &model.Server{Hostname: "atlas"}
+		}},
+		&statsCollector{fakeCollector{name: "second", collect: func(infra *model.Infrastructure) {
+			infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Services: []*model.Service{{Name: "web"}}}
+			infra.Servers["nexus"] = &model.Server{Hostname: "nexus"}
+			infra.Devices["phone"] = &model.Device{Hostname: "phone"}
+		}}},
+	}
+	cfg := testConfig(map[string]any{"first": map[string]any{}, "second": map[string]any{}})
+
+	_, results, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+
+	s := results[1].Stats
+	assert.Equal(t, 1, s.ServersAdded)
+	assert.Equal(t, 1, s.ServersUpdated)
+	assert.Equal(t, 1, s.ServicesAdded)
+	assert.Equal(t, 1, s.DevicesAdded)
+	assert.Equal(t, 1, s.FilesParsed)
+	assert.Equal(t, 2, s.APICalls)
+	assert.Equal(t, []string{"skipping broken.yml"}, s.Warnings)
+	assert.Contains(t, results[1].Detail, "2 servers (1 updated)")
+}
+
+// statsCollector reports file, API and warning counts like a real collector.
+type statsCollector struct {
+	fakeCollector
+}
+
+func (s *statsCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	stats := statsFrom(ctx)
+	stats.fileParsed()
+	stats.apiCall()
+	stats.apiCall()
+	stats.warn("skipping %s", "broken.yml")
+	return s.fakeCollector.Collect(ctx, infra)
+}
+
+func TestAnsibleCollectorStats(t *testing.T) {
+	stats := &CollectStats{}
+	ac := &AnsibleCollector{
+		InventoryPath: "../../testdata/ansible/hosts.yml",
+		GroupVarsPath: "../../testdata/ansible/group_vars",
+	}
+
+	err := ac.Collect(withStats(context.Background(), stats), model.NewInfrastructure())
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.FilesParsed)
+	assert.Zero(t, stats.APICalls)
+}
@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
+)
+
+func init() {
+	Register(func() RegisteredCollector { return &SwarmCollector{} })
+}
+
+// swarmFiles are the Docker Engine API listings a saved swarm consists of,
+// each saved as <name>.json.
+var swarmFiles = []string{"nodes", "services", "tasks", "networks"}
+
+// SwarmCollector collects the nodes, services and overlay networks of a
+// Docker Swarm from a manager's Engine API, or from listings saved as JSON.
+type SwarmCollector struct {
+	Host           string // unix:///path/to/docker.sock or tcp://host:port of a manager
+	CertPath       string // directory with ca.pem, cert.pem and key.pem for TLS
+	JSONDir        string // directory with nodes.json, services.json, tasks.json and networks.json
+	Name           string // server standing for the swarm
+	IncludeStopped bool   // also list services without running tasks
+}
+
+func (sc *SwarmCollector) Metadata() CollectorMetadata {
+	return CollectorMetadata{
+		Name:        "swarm",
+		DisplayName: "Docker Swarm",
+		Description: "Collects Swarm nodes, services, task placement and overlay networks from the Docker Engine API",
+		ConfigKey:   "swarm",
+		DetectHint:  "/var/run/docker.sock",
+	}
+}
+
+func (sc *SwarmCollector) Enabled(sources map[string]any) bool {
+	section, ok := sources["swarm"].(map[string]any)
+	if !ok {
+		return false
+	}
+	if enabled, ok := section["enabled"].(bool); ok {
+		return enabled
+	}
+	host, _ := section["host"].(string)
+	dir, _ := section["json_dir"].(string)
+	return host != "" || dir != ""
+}
+
+func (sc *SwarmCollector) Configure(section map[string]any) error {
+	if section != nil {
+		if v, ok := section["host"].(string); ok {
+			sc.Host = v
+		}
+		if v, ok := section["cert_path"].(string); ok {
+			sc.CertPath = util.ExpandPath(v)
+		}
+		if v, ok := section["json_dir"].(string); ok {
+			sc.JSONDir = util.ExpandPath(v)
+		}
+		if v, ok := section["name"].(string); ok {
+			sc.Name = strings.ToLower(v)
+		}
+		if v, ok := section["include_stopped"].(bool); ok {
+			sc.IncludeStopped = v
+		}
+	}
+	if sc.JSONDir == "" {
+		sc.Host, sc.CertPath = dockerEnvDefaults(sc.Host, sc.CertPath)
+	}
+	if sc.Name == "" {
+		sc.Name = "swarm"
+	}
+	return nil
+}
+
+func (sc *SwarmCollector) Validate() []ValidationError {
+	if sc.JSONDir == "" {
+		return validateDockerHost("swarm", sc.Host, sc.CertPath)
+	}
+
+	var errs []ValidationError
+	for _, name := range swarmFiles {
+		path := filepath.Join(sc.JSONDir, name+".json")
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      "sources.swarm.json_dir",
+				Message:    fmt.Sprintf("file not found: %s", path),
+				Suggestion: fmt.Sprintf("save it with: curl --unix-socket /var/run/docker.sock http://docker/%s > %s.json", name, name),
+			})
+		}
+	}
+	return errs
+}
+
+func (sc *SwarmCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	objs := swarmObjects{name: sc.Name, location: sc.location(), includeStopped: sc.IncludeStopped}
+	lists := map[string]any{
+		"nodes":    &objs.nodes,
+		"services": &objs.services,
+		"tasks":    &objs.tasks,
+		"networks": &objs.networks,
+	}
+
+	if sc.JSONDir != "" {
+		stats := statsFrom(ctx)
+		for _, name := range swarmFiles {
+			path := filepath.Join(sc.JSONDir, name+".json")
+			if err := loadJSONFile(path, lists[name]); err != nil {
+				return fmt.Errorf("loading %s: %w", path, err)
+			}
+			stats.fileParsed()
+		}
+	} else {
+		client, base, err := dockerClient(sc.Host, sc.CertPath)
+		if err != nil {
+			return err
+		}
+		for _, name := range swarmFiles {
+			if err := sc.apiGet(ctx, client, base+"/"+name, lists[name]); err != nil {
+				return fmt.Errorf("listing %s: %w", name, err)
+			}
+		}
+	}
+
+	objs.build(infra)
+	return nil
+}
+
+// apiGet decodes the response to a GET of url into v. A daemon that isn't a
+// swarm manager answers 503 with an explanation.
+func (sc *SwarmCollector) apiGet(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	status, body, err := doHTTP(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("docker API returned %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// location describes where the swarm was read from, for provenance.
+func (sc *SwarmCollector) location() string {
+	if sc.JSONDir != "" {
+		return sc.JSONDir
+	}
+	return sc.Host
+}
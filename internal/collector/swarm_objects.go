@@ -0,0 +1,254 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+)
+
+// swarmNode is a node as the Docker Engine API's /nodes lists it.
+type swarmNode struct {
+	ID          string `json:"ID"`
+	Description struct {
+		Hostname string `json:"Hostname"`
+	} `json:"Description"`
+	Spec struct {
+		Role         string `json:"Role"`         // manager or worker
+		Availability string `json:"Availability"` // active, pause or drain
+	} `json:"Spec"`
+	Status struct {
+		State string `json:"State"` // ready, down, ...
+		Addr  string `json:"Addr"`
+	} `json:"Status"`
+	ManagerStatus *struct {
+		Leader bool   `json:"Leader"`
+		Addr   string `json:"Addr"` // "10.0.0.1:2377"
+	} `json:"ManagerStatus"`
+}
+
+// swarmService is a service as /services lists it.
+type swarmService struct {
+	ID   string `json:"ID"`
+	Spec struct {
+		Name         string            `json:"Name"`
+		Labels       map[string]string `json:"Labels"`
+		TaskTemplate struct {
+			ContainerSpec struct {
+				Image string `json:"Image"`
+			} `json:"ContainerSpec"`
+			Networks []struct {
+				Target string `json:"Target"`
+			} `json:"Networks"`
+		} `json:"TaskTemplate"`
+		Mode struct {
+			Replicated *struct {
+				Replicas int `json:"Replicas"`
+			} `json:"Replicated"`
+			Global *struct{} `json:"Global"`
+		} `json:"Mode"`
+	} `json:"Spec"`
+	Endpoint struct {
+		Ports []struct {
+			Protocol      string `json:"Protocol"`
+			TargetPort    int    `json:"TargetPort"`
+			PublishedPort int    `json:"PublishedPort"`
+			PublishMode   string `json:"PublishMode"` // ingress or host
+		} `json:"Ports"`
+	} `json:"Endpoint"`
+}
+
+// swarmTask is one replica of a service, as /tasks lists it.
+type swarmTask struct {
+	ID        string `json:"ID"`
+	ServiceID string `json:"ServiceID"`
+	NodeID    string `json:"NodeID"`
+	Status    struct {
+		State string `json:"State"`
+	} `json:"Status"`
+}
+
+// swarmNetwork is a network as /networks lists it.
+type swarmNetwork struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Driver string `json:"Driver"`
+	Scope  string `json:"Scope"`
+}
+
+// swarmObjects are the nodes, services, tasks and networks of a swarm.
+type swarmObjects struct {
+	name           string // server standing for the swarm
+	location       string // where they were read from, for provenance
+	includeStopped bool   // keep services without running tasks
+	nodes          []swarmNode
+	services       []swarmService
+	tasks          []swarmTask
+	networks       []swarmNetwork
+}
+
+// build adds the swarm to infra. Services are drawn on a server of their own
+// named after the swarm, labeled with their running (and, when replicated,
+// desired) replicas and published ports; each node is a server named after
+// its hostname, so that it correlates with what other collectors know of the
+// machine, and services are linked to the nodes their tasks run on. Overlay
+// networks are recorded with the services attached to them.
+func (objs *swarmObjects) build(infra *model.Infrastructure) {
+	cluster, exists := infra.Servers[objs.name]
+	if !exists {
+		cluster = &model.Server{
+			Hostname: objs.name,
+			Label:    objs.name,
+			Type:     model.ServerTypeCluster,
+			Online:   true,
+		}
+		infra.Servers[objs.name] = cluster
+	}
+	cluster.AddSource(objs.location)
+
+	nodes := make(map[string]string) // node ID → server
+	for _, node := range objs.nodes {
+		nodes[node.ID] = objs.nodeServer(infra, node).Hostname
+	}
+
+	networks := make(map[string]swarmNetwork)
+	for _, n := range objs.networks {
+		networks[n.ID] = n
+	}
+
+	running := make(map[string]map[string]int) // service ID → node → tasks
+	for _, task := range objs.tasks {
+		if task.Status.State != "running" {
+			continue
+		}
+		if running[task.ServiceID] == nil {
+			running[task.ServiceID] = make(map[string]int)
+		}
+		running[task.ServiceID][task.NodeID]++
+	}
+
+	for _, ss := range objs.services {
+		replicas := 0
+		for _, n := range running[ss.ID] {
+			replicas += n
+		}
+		if replicas == 0 && !objs.includeStopped {
+			continue
+		}
+
+		name := ss.Spec.Name
+		image, _, _ := strings.Cut(ss.Spec.TaskTemplate.ContainerSpec.Image, "@")
+		stack := ss.Spec.Labels["com.docker.stack.namespace"]
+		svc := &model.Service{
+			Name:     name,
+			Image:    image,
+			Type:     detectServiceType(image, name),
+			Kind:     "Replicated service",
+			Replicas: replicas,
+			Category: stack,
+			Project:  stack,
+			Status:   "running",
+		}
+		if ss.Spec.Mode.Global != nil {
+			svc.Kind = "Global service"
+		}
+		if r := ss.Spec.Mode.Replicated; r != nil {
+			svc.Desired = r.Replicas
+		}
+		if replicas == 0 {
+			svc.Status = "stopped"
+		}
+		for _, p := range ss.Endpoint.Ports {
+			if p.PublishedPort > 0 {
+				svc.Ports = append(svc.Ports, model.PortMapping{
+					HostPort:      p.PublishedPort,
+					ContainerPort: p.TargetPort,
+					Protocol:      portProtocol(p.Protocol),
+				})
+			}
+		}
+		for _, att := range ss.Spec.TaskTemplate.Networks {
+			n, ok := networks[att.Target]
+			if !ok || n.Driver != "overlay" {
+				continue
+			}
+			svc.Networks = append(svc.Networks, n.Name)
+			network, ok := infra.Networks[n.Name]
+			if !ok {
+				network = &model.Network{Name: n.Name, Driver: n.Driver}
+				infra.Networks[n.Name] = network
+			}
+			if !containsStr(network.Services, name) {
+				network.Services = append(network.Services, name)
+			}
+		}
+		svc.AddSource(fmt.Sprintf("%s service %s", objs.location, name))
+		cluster.AddService(svc)
+
+		for _, nodeID := range sortedKeys(running[ss.ID]) {
+			server, ok := nodes[nodeID]
+			if !ok {
+				continue
+			}
+			infra.Connections = append(infra.Connections, model.Connection{
+				From:  objs.name + "/" + name,
+				To:    server,
+				Label: tasksLabel(running[ss.ID][nodeID]),
+				Style: "dashed",
+			})
+		}
+	}
+}
+
+// nodeServer adds a swarm node as a server of type cluster: online when
+// ready, reachable at its address, tagged with its role.
+func (objs *swarmObjects) nodeServer(infra *model.Infrastructure, node swarmNode) *model.Server {
+	name := strings.ToLower(node.Description.Hostname)
+	if name == "" {
+		name = node.ID
+	}
+	server, exists := infra.Servers[name]
+	if !exists {
+		server = &model.Server{
+			Hostname: name,
+			Label:    name,
+			Type:     model.ServerTypeCluster,
+		}
+		infra.Servers[name] = server
+	}
+	server.Online = node.Status.State == "ready"
+
+	// Managers may report 0.0.0.0; their manager address is the real one.
+	addr := node.Status.Addr
+	if node.ManagerStatus != nil && (addr == "" || addr == "0.0.0.0") {
+		if host, _, err := net.SplitHostPort(node.ManagerStatus.Addr); err == nil {
+			addr = host
+		}
+	}
+	if ip := net.ParseIP(addr); ip != nil && !ip.IsUnspecified() {
+		server.AddAddress(addr)
+	}
+
+	if node.Spec.Role != "" {
+		server.Tags = append(server.Tags, "role="+node.Spec.Role)
+	}
+	if node.ManagerStatus != nil && node.ManagerStatus.Leader {
+		server.Tags = append(server.Tags, "leader")
+	}
+	if a := node.Spec.Availability; a != "" && a != "active" {
+		server.Tags = append(server.Tags, "availability="+a)
+	}
+	if !server.Online {
+		server.Tags = append(server.Tags, "status="+node.Status.State)
+	}
+	server.AddSource(fmt.Sprintf("%s node %s", objs.location, name))
+	return server
+}
+
+func tasksLabel(n int) string {
+	if n == 1 {
+		return "1 task"
+	}
+	return fmt.Sprintf("%d tasks", n)
+}
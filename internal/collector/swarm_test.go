@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSwarmSocket serves testdata/swarm as a manager's Engine API on a unix
+// socket and returns its path. With notManager, it answers like a daemon
+// outside a swarm.
+func fakeSwarmSocket(t *testing.T, notManager bool) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if notManager {
+			http.Error(w, `{"message":"This node is not a swarm manager. Use \"docker swarm init\" or \"docker swarm join\" to connect this node to swarm and try again."}`, http.StatusServiceUnavailable)
+			return
+		}
+		data, err := os.ReadFile("../../testdata/swarm" + r.URL.Path + ".json")
+		if err != nil {
+			http.Error(w, `{"message":"page not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return socket
+}
+
+func TestSwarmCollectorJSON(t *testing.T) {
+	sc := &SwarmCollector{}
+	require.NoError(t, sc.Configure(map[string]any{"json_dir": "../../testdata/swarm"}))
+	require.Empty(t, sc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, sc.Collect(withStats(context.Background(), stats), infra))
+	assert.Equal(t, 4, stats.FilesParsed)
+
+	swarm := infra.Servers["swarm"]
+	require.NotNil(t, swarm)
+	assert.Equal(t, model.ServerTypeCluster, swarm.Type)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/swarm"}}, swarm.Sources)
+
+	services := make(map[string]*model.Service)
+	for _, svc := range swarm.Services {
+		services[svc.Name] = svc
+	}
+	require.Len(t, services, 3, "services without running tasks are skipped")
+
+	traefik := services["proxy_traefik"]
+	require.NotNil(t, traefik)
+	assert.Equal(t, "traefik:v3.1", traefik.Image)
+	assert.Equal(t, "Global service", traefik.Kind)
+	assert.Equal(t, 3, traefik.Replicas)
+	assert.Zero(t, traefik.Desired, "a global service runs wherever it can")
+	assert.Equal(t, "proxy", traefik.Category)
+	assert.Equal(t, "proxy", traefik.Project)
+	assert.Equal(t, []model.PortMapping{
+		{HostPort: 80, ContainerPort: 80, Protocol: "tcp"},
+		{HostPort: 443, ContainerPort: 443, Protocol: "tcp"},
+	}, traefik.Ports)
+	assert.Equal(t, []string{"traefik-public"}, traefik.Networks)
+
+	whoami := services["apps_whoami"]
+	require.NotNil(t, whoami)
+	assert.Equal(t, "Replicated service", whoami.Kind)
+	assert.Equal(t, 3, whoami.Replicas)
+	assert.Equal(t, 3, whoami.Desired)
+	assert.Equal(t, []string{"traefik-public", "apps_default"}, whoami.Networks)
+	assert.Equal(t, "running", whoami.Status)
+
+	redis := services["apps_redis"]
+	require.NotNil(t, redis)
+	assert.Equal(t, 1, redis.Replicas, "failed and pending tasks don't count")
+	assert.Equal(t, 2, redis.Desired)
+	assert.Empty(t, redis.Ports, "unpublished ports are not shown")
+
+	assert.ElementsMatch(t, []string{"proxy_traefik", "apps_whoami"}, infra.Networks["traefik-public"].Services)
+	assert.Equal(t, "overlay", infra.Networks["apps_default"].Driver)
+	assert.NotContains(t, infra.Networks, "ingress", "no service is attached to it by its spec")
+	assert.NotContains(t, infra.Networks, "bridge")
+
+	// Nodes are named after their hostname and tagged with their role.
+	leader := infra.Servers["node-1"]
+	require.NotNil(t, leader)
+	assert.True(t, leader.Online)
+	assert.Equal(t, []string{"192.168.1.21"}, leader.Addresses)
+	assert.Equal(t, []string{"role=manager", "leader"}, leader.Tags)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/swarm node node-1"}}, leader.Sources)
+	require.Contains(t, infra.Servers, "node-2")
+	assert.Equal(t, []string{"192.168.1.22"}, infra.Servers["node-2"].Addresses, "the manager address stands in for 0.0.0.0")
+	require.Contains(t, infra.Servers, "node-3")
+	assert.Equal(t, []string{"role=worker", "availability=pause"}, infra.Servers["node-3"].Tags)
+
+	assert.ElementsMatch(t, []model.Connection{
+		{From: "swarm/proxy_traefik", To: "node-1", Label: "1 task", Style: "dashed"},
+		{From: "swarm/proxy_traefik", To: "node-2", Label: "1 task", Style: "dashed"},
+		{From: "swarm/proxy_traefik", To: "node-3", Label: "1 task", Style: "dashed"},
+		{From: "swarm/apps_whoami", To: "node-2", Label: "1 task", Style: "dashed"},
+		{From: "swarm/apps_whoami", To: "node-3", Label: "2 tasks", Style: "dashed"},
+		{From: "swarm/apps_redis", To: "node-2", Label: "1 task", Style: "dashed"},
+	}, infra.Connections)
+}
+
+func TestSwarmCollectorAPI(t *testing.T) {
+	socket := fakeSwarmSocket(t, false)
+
+	sc := &SwarmCollector{}
+	require.NoError(t, sc.Configure(map[string]any{
+		"host":            "unix://" + socket,
+		"name":            "Lab-Swarm",
+		"include_stopped": true,
+	}))
+	require.Empty(t, sc.Validate())
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, sc.Collect(withStats(context.Background(), stats), infra))
+	assert.Equal(t, 4, stats.APICalls)
+
+	swarm := infra.Servers["lab-swarm"]
+	require.NotNil(t, swarm)
+	require.Len(t, swarm.Services, 4)
+	backup := swarm.Services[3]
+	assert.Equal(t, "backup", backup.Name)
+	assert.Equal(t, "stopped", backup.Status)
+	assert.Zero(t, backup.Replicas)
+	assert.Equal(t, []model.Source{{Location: "unix://" + socket + " service backup"}}, backup.Sources)
+	assert.Len(t, infra.Connections, 6, "a stopped service is placed nowhere")
+}
+
+func TestSwarmCollectorNotManager(t *testing.T) {
+	socket := fakeSwarmSocket(t, true)
+
+	sc := &SwarmCollector{}
+	require.NoError(t, sc.Configure(map[string]any{"host": "unix://" + socket}))
+
+	err := sc.Collect(context.Background(), model.NewInfrastructure())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "listing nodes: docker API returned 503")
+	assert.Contains(t, err.Error(), "not a swarm manager")
+}
+
+func TestSwarmCollectorValidate(t *testing.T) {
+	sc := &SwarmCollector{}
+	require.NoError(t, sc.Configure(map[string]any{"json_dir": t.TempDir()}))
+	errs := sc.Validate()
+	require.Len(t, errs, 4, "one per missing listing")
+	assert.Equal(t, "sources.swarm.json_dir", errs[0].Field)
+
+	sc = &SwarmCollector{}
+	require.NoError(t, sc.Configure(map[string]any{"host": "ssh://user@manager"}))
+	errs = sc.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "sources.swarm.host", errs[0].Field)
+}
+
+func TestSwarmCollectorEnabled(t *testing.T) {
+	sc := &SwarmCollector{}
+	assert.False(t, sc.Enabled(map[string]any{}))
+	assert.False(t, sc.Enabled(map[string]any{"swarm": map[string]any{"enabled": false, "host": "tcp://manager:2376"}}))
+	assert.True(t, sc.Enabled(map[string]any{"swarm": map[string]any{"json_dir": "./swarm"}}))
+	assert.True(t, sc.Enabled(map[string]any{"swarm": map[string]any{"enabled": true}}))
+}
@@ -1,10 +1,9 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -20,11 +19,10 @@ type SystemdCollector struct {
 }
 
 type systemdServer struct {
-	Host     string
-	SSH      string   // user@host for remote execution
-	Filter   []string // include only these service names
-	Exclude  []string // exclude these service names
-	TestFile string   // path to test JSON data
+	Host    string
+	SSH     string   // user@host for remote execution
+	Filter  []string // include only these service names
+	Exclude []string // exclude these service names
 }
 
 func (sc *SystemdCollector) Metadata() CollectorMetadata {
@@ -80,9 +78,6 @@ func (sc *SystemdCollector) Configure(section map[string]any) error {
 				}
 			}
 		}
-		if v, ok := m["test_file"].(string); ok {
-			srv.TestFile = v
-		}
 		sc.Servers = append(sc.Servers, srv)
 	}
 	return nil
@@ -110,9 +105,9 @@ type systemdUnit struct {
 	Description string `json:"description"`
 }
 
-func (sc *SystemdCollector) Collect(infra *model.Infrastructure) error {
+func (sc *SystemdCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
 	for _, srv := range sc.Servers {
-		units, err := sc.getUnits(srv)
+		units, err := sc.getUnits(ctx, srv)
 		if err != nil {
 			return fmt.Errorf("getting units for %s: %w", srv.Host, err)
 		}
@@ -128,6 +123,7 @@ func (sc *SystemdCollector) Collect(infra *model.Infrastructure) error {
 			}
 			infra.Servers[srv.Host] = server
 		}
+		server.AddSource(srv.location())
 
 		for _, unit := range units {
 			name := strings.TrimSuffix(unit.Unit, ".service")
@@ -149,6 +145,7 @@ func (sc *SystemdCollector) Collect(infra *model.Infrastructure) error {
 				Name: name,
 				Type: svcType,
 			}
+			svc.AddSource(srv.location())
 
 			server.AddService(svc)
 		}
@@ -157,31 +154,18 @@ func (sc *SystemdCollector) Collect(infra *model.Infrastructure) error {
 	return nil
 }
 
-func (sc *SystemdCollector) getUnits(srv systemdServer) ([]systemdUnit, error) {
-	if srv.TestFile != "" {
-		data, err := os.ReadFile(srv.TestFile)
-		if err != nil {
-			return nil, err
-		}
-		var units []systemdUnit
-		if err := json.Unmarshal(data, &units); err != nil {
-			return nil, err
-		}
-		return units, nil
-	}
-
+func (sc *SystemdCollector) getUnits(ctx context.Context, srv systemdServer) ([]systemdUnit, error) {
 	args := []string{"list-units", "--type=service", "--state=running", "--output=json"}
 
-	var cmd *exec.Cmd
+	var out []byte
+	var err error
 	if srv.SSH != "" {
 		sshArgs := []string{srv.SSH, "systemctl"}
 		sshArgs = append(sshArgs, args...)
-		cmd = exec.Command("ssh", sshArgs...)
+		out, err = runCommand(ctx, nil, "ssh", sshArgs...)
 	} else {
-		cmd = exec.Command("systemctl", args...)
+		out, err = runCommand(ctx, nil, "systemctl", args...)
 	}
-
-	out, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("systemctl: %w", err)
 	}
@@ -193,6 +177,14 @@ func (sc *SystemdCollector) getUnits(srv systemdServer) ([]systemdUnit, error) {
 	return units, nil
 }
 
+// location describes where a server's units were read from, for provenance.
+func (srv systemdServer) location() string {
+	if srv.SSH != "" {
+		return "ssh " + srv.SSH
+	}
+	return "local systemctl"
+}
+
 func matchesAny(name string, patterns []string) bool {
 	lower := strings.ToLower(name)
 	for _, p := range patterns {
@@ -12,21 +12,21 @@ func TestSystemdCollector(t *testing.T) {
 	sc := &SystemdCollector{
 		Servers: []systemdServer{
 			{
-				Host:     "myserver",
-				TestFile: "../../testdata/systemd/units.json",
+				Host: "myserver",
 			},
 		},
 	}
 
 	infra := model.NewInfrastructure()
-	err := sc.Collect(infra)
+	err := sc.Collect(replaying(t, "systemd"), infra)
 	require.NoError(t, err)
 
 	assert.Contains(t, infra.Servers, "myserver")
 	server := infra.Servers["myserver"]
 
-	// Should have all 6 services from the test data
+	// Should have all 6 services from the recording
 	assert.Len(t, server.Services, 6)
+	assert.Equal(t, []model.Source{{Location: "local systemctl"}}, server.Sources)
 
 	svcNames := make(map[string]bool)
 	for _, svc := range server.Services {
@@ -42,15 +42,14 @@ func TestSystemdCollectorWithFilter(t *testing.T) {
 	sc := &SystemdCollector{
 		Servers: []systemdServer{
 			{
-				Host:     "filtered",
-				Filter:   []string{"docker", "nginx"},
-				TestFile: "../../testdata/systemd/units.json",
+				Host:   "filtered",
+				Filter: []string{"docker", "nginx"},
 			},
 		},
 	}
 
 	infra := model.NewInfrastructure()
-	err := sc.Collect(infra)
+	err := sc.Collect(replaying(t, "systemd"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["filtered"]
@@ -61,15 +60,14 @@ func TestSystemdCollectorWithExclude(t *testing.T) {
 	sc := &SystemdCollector{
 		Servers: []systemdServer{
 			{
-				Host:     "excluded",
-				Exclude:  []string{"cron", "network", "sshd"},
-				TestFile: "../../testdata/systemd/units.json",
+				Host:    "excluded",
+				Exclude: []string{"cron", "network", "sshd"},
 			},
 		},
 	}
 
 	infra := model.NewInfrastructure()
-	err := sc.Collect(infra)
+	err := sc.Collect(replaying(t, "systemd"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["excluded"]
@@ -89,15 +87,14 @@ func TestSystemdCollectorPostgresType(t *testing.T) {
 	sc := &SystemdCollector{
 		Servers: []systemdServer{
 			{
-				Host:     "dbserver",
-				Filter:   []string{"postgresql"},
-				TestFile: "../../testdata/systemd/units.json",
+				Host:   "dbserver",
+				Filter: []string{"postgresql"},
 			},
 		},
 	}
 
 	infra := model.NewInfrastructure()
-	err := sc.Collect(infra)
+	err := sc.Collect(replaying(t, "systemd"), infra)
 	require.NoError(t, err)
 
 	server := infra.Servers["dbserver"]
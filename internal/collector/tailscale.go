@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -98,8 +99,8 @@ type tailscalePeer struct {
 	Tags         []string `json:"Tags"`
 }
 
-func (tc *TailscaleCollector) Collect(infra *model.Infrastructure) error {
-	data, err := tc.getData()
+func (tc *TailscaleCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	data, err := tc.getData(ctx)
 	if err != nil {
 		return fmt.Errorf("getting tailscale data: %w", err)
 	}
@@ -128,13 +129,14 @@ func (tc *TailscaleCollector) Collect(infra *model.Infrastructure) error {
 	return nil
 }
 
-func (tc *TailscaleCollector) getData() ([]byte, error) {
+func (tc *TailscaleCollector) getData(ctx context.Context) ([]byte, error) {
+	stats := statsFrom(ctx)
 	if tc.JsonFile != "" {
+		stats.fileParsed()
 		return os.ReadFile(tc.JsonFile)
 	}
 
-	cmd := exec.Command("tailscale", "status", "--json")
-	output, err := cmd.Output()
+	output, err := runCommand(ctx, nil, "tailscale", "status", "--json")
 	if err != nil {
 		return nil, fmt.Errorf("running tailscale status: %w", err)
 	}
@@ -157,6 +159,8 @@ func (tc *TailscaleCollector) processPeer(infra *model.Infrastructure, peer tail
 		server.TailscaleIP = tsIP
 		server.OS = peer.OS
 		server.Online = peer.Online
+		server.AddAlias(magicDNSName(peer.DNSName))
+		server.AddSource(tc.location())
 		return
 	}
 
@@ -178,6 +182,8 @@ func (tc *TailscaleCollector) processPeer(infra *model.Infrastructure, peer tail
 			Online:      peer.Online,
 			Type:        model.ServerTypeLab,
 		}
+		infra.Servers[hostname].AddAlias(magicDNSName(peer.DNSName))
+		infra.Servers[hostname].AddSource(tc.location())
 		return
 	}
 
@@ -189,4 +195,21 @@ func (tc *TailscaleCollector) processPeer(infra *model.Infrastructure, peer tail
 		Online:      peer.Online,
 		Tags:        peer.Tags,
 	}
+	if dns := magicDNSName(peer.DNSName); dns != "" && dns != hostname {
+		infra.Devices[hostname].Aliases = append(infra.Devices[hostname].Aliases, dns)
+	}
+	infra.Devices[hostname].AddSource(tc.location())
+}
+
+// magicDNSName returns a peer's MagicDNS name without the trailing dot.
+func magicDNSName(dnsName string) string {
+	return strings.TrimSuffix(strings.ToLower(dnsName), ".")
+}
+
+// location describes where peers were read from, for provenance.
+func (tc *TailscaleCollector) location() string {
+	if tc.JsonFile != "" {
+		return tc.JsonFile
+	}
+	return "tailscale status"
 }
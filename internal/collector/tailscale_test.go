@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ThomasCrouzet/inframap-d2/internal/model"
@@ -16,7 +17,7 @@ func TestTailscaleCollector(t *testing.T) {
 		IncludeOffline: false,
 	}
 
-	err := tc.Collect(infra)
+	err := tc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Tailnet name
@@ -34,6 +35,7 @@ func TestTailscaleCollector(t *testing.T) {
 	assert.Equal(t, "100.64.0.2", gateway.TailscaleIP)
 	assert.Equal(t, "linux", gateway.OS)
 	assert.True(t, gateway.Online)
+	assert.Equal(t, []string{"gateway.tail12345.ts.net"}, gateway.Aliases)
 
 	// Devices: homelab (self, no server tag), user-phone, homeassistant
 	assert.Contains(t, infra.Devices, "homelab")
@@ -53,7 +55,7 @@ func TestTailscaleCollectorIncludeOffline(t *testing.T) {
 		IncludeOffline: true,
 	}
 
-	err := tc.Collect(infra)
+	err := tc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	// Offline laptop should be included now
@@ -75,7 +77,7 @@ func TestTailscaleCollectorEnrichesExistingServers(t *testing.T) {
 		JsonFile: "../../testdata/tailscale/status.json",
 	}
 
-	err := tc.Collect(infra)
+	err := tc.Collect(context.Background(), infra)
 	require.NoError(t, err)
 
 	gateway := infra.Servers["gateway"]
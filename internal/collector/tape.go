@@ -0,0 +1,286 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tape records the raw data collectors read from commands and HTTP APIs
+// (--record), or plays it back instead of touching the network (--replay),
+// so that a run can be reproduced offline. Each collector gets its own
+// cassette file in the tape directory.
+type Tape struct {
+	dir    string
+	replay bool
+}
+
+// NewRecorder returns a tape that saves everything collectors read to dir.
+func NewRecorder(dir string) (*Tape, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating record directory: %w", err)
+	}
+	return &Tape{dir: dir}, nil
+}
+
+// NewReplayer returns a tape that serves collectors from a recording in dir.
+func NewReplayer(dir string) (*Tape, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening replay directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("replay path %s is not a directory", dir)
+	}
+	return &Tape{dir: dir, replay: true}, nil
+}
+
+// tapeEntry is one recorded command run or HTTP exchange.
+type tapeEntry struct {
+	Key    string `json:"key"`
+	Status int    `json:"status,omitempty"` // HTTP only
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// cassette holds one collector's share of a tape.
+type cassette struct {
+	path   string
+	replay bool
+	cached bool // part of the cache: misses go to the source, failed runs are not kept
+
+	mu      sync.Mutex
+	entries []tapeEntry
+	next    map[string]int // replay: entries already served per key
+	dirty   bool           // entries were added since the cassette was opened
+}
+
+// cassette opens the cassette for the named collector.
+func (t *Tape) cassette(name string) (*cassette, error) {
+	c := &cassette{
+		path:   filepath.Join(t.dir, cassetteFile(name)),
+		replay: t.replay,
+		next:   make(map[string]int),
+	}
+	if !t.replay {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil // the collector made no calls when recorded
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("parsing recording %s: %w", c.path, err)
+	}
+	return c, nil
+}
+
+// cassetteFile turns a collector name ("plugin:cmdb") into a file name.
+func cassetteFile(name string) string {
+	return strings.NewReplacer(":", "-", "/", "-").Replace(name) + ".json"
+}
+
+// save writes the cassette to disk if anything was recorded. Collectors
+// that made no calls leave no file behind.
+func (c *cassette) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o600)
+}
+
+func (c *cassette) record(e tapeEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, e)
+	c.dirty = true
+}
+
+// play returns the next recorded entry for key. Repeated calls with the same
+// key are served in the order they were recorded.
+func (c *cassette) play(key string) (tapeEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := 0
+	for _, e := range c.entries {
+		if e.Key != key {
+			continue
+		}
+		if seen == c.next[key] {
+			c.next[key]++
+			return e, nil
+		}
+		seen++
+	}
+	return tapeEntry{}, fmt.Errorf("no recorded response for %q in %s", key, c.path)
+}
+
+// has tells whether play would find a recorded entry for key.
+func (c *cassette) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := 0
+	for _, e := range c.entries {
+		if e.Key == key {
+			seen++
+		}
+	}
+	return seen > c.next[key]
+}
+
+type cassetteKey struct{}
+
+func withCassette(ctx context.Context, c *cassette) context.Context {
+	return context.WithValue(ctx, cassetteKey{}, c)
+}
+
+func cassetteFrom(ctx context.Context) *cassette {
+	c, _ := ctx.Value(cassetteKey{}).(*cassette)
+	return c
+}
+
+// runCommand runs an external command with optional stdin and returns its
+// stdout. A failing command's stderr is included in the error. Every
+// collector runs its commands through here so they can be recorded and
+// replayed.
+func runCommand(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	statsFrom(ctx).apiCall()
+
+	key := strings.Join(append([]string{"exec", name}, args...), " ")
+	if len(stdin) > 0 {
+		// Hash rather than store stdin: it may carry secrets.
+		sum := sha256.Sum256(stdin)
+		key += " <stdin:" + hex.EncodeToString(sum[:6]) + ">"
+	}
+
+	c := cassetteFrom(ctx)
+	if c != nil && c.replay {
+		e, err := c.play(key)
+		switch {
+		case err == nil && e.Error != "":
+			return nil, errors.New(e.Error)
+		case err == nil:
+			return []byte(e.Output), nil
+		case !c.cached:
+			return nil, err
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Once ctx is done, don't wait on children of a killed script that
+	// still hold its output open.
+	cmd.WaitDelay = time.Second
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+
+	if c != nil {
+		e := tapeEntry{Key: key, Output: string(out)}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		c.record(e)
+	}
+	return out, err
+}
+
+// doHTTP sends req and returns the response status and body, going through
+// the tape like runCommand. Request headers (credentials) are never recorded.
+func doHTTP(ctx context.Context, client *http.Client, req *http.Request) (int, []byte, error) {
+	statsFrom(ctx).apiCall()
+
+	key := req.Method + " " + req.URL.String()
+
+	c := cassetteFrom(ctx)
+	if c != nil && c.replay {
+		e, err := c.play(key)
+		switch {
+		case err == nil && e.Error != "":
+			return 0, nil, errors.New(e.Error)
+		case err == nil:
+			return e.Status, []byte(e.Output), nil
+		case !c.cached:
+			return 0, nil, err
+		}
+	}
+
+	status, body, err := sendHTTP(client, req)
+
+	if c != nil {
+		e := tapeEntry{Key: key, Status: status, Output: string(body)}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		c.record(e)
+	}
+	return status, body, err
+}
+
+// fromTape tells whether doHTTP answers req from the tape instead of sending
+// it: always when replaying, and when the cache holds a response for it.
+func fromTape(ctx context.Context, req *http.Request) bool {
+	c := cassetteFrom(ctx)
+	if c == nil || !c.replay {
+		return false
+	}
+	return !c.cached || c.has(req.Method+" "+req.URL.String())
+}
+
+// doHTTPUntaped sends req like doHTTP but keeps it off the tape, for
+// exchanges whose response is a credential (a login ticket): it is neither
+// recorded, cached nor replayed.
+func doHTTPUntaped(ctx context.Context, client *http.Client, req *http.Request) (int, []byte, error) {
+	statsFrom(ctx).apiCall()
+	return sendHTTP(client, req)
+}
+
+func sendHTTP(client *http.Client, req *http.Request) (int, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
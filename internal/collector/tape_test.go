@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTapeCommandRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	rec, err := NewRecorder(dir)
+	require.NoError(t, err)
+	cas, err := rec.cassette("systemd")
+	require.NoError(t, err)
+	ctx := withCassette(context.Background(), cas)
+
+	out, err := runCommand(ctx, nil, "echo", "hello")
+	require.NoError(t, err)
+	_, failErr := runCommand(ctx, nil, "sh", "-c", "echo boom >&2; exit 3")
+	require.Error(t, failErr)
+	assert.Contains(t, failErr.Error(), "boom")
+	require.NoError(t, cas.save())
+
+	play, err := NewReplayer(dir)
+	require.NoError(t, err)
+	cas, err = play.cassette("systemd")
+	require.NoError(t, err)
+	ctx = withCassette(context.Background(), cas)
+
+	replayed, err := runCommand(ctx, nil, "echo", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, out, replayed)
+
+	_, err = runCommand(ctx, nil, "sh", "-c", "echo boom >&2; exit 3")
+	require.Error(t, err)
+	assert.Equal(t, failErr.Error(), err.Error())
+
+	// Each recorded call is served once.
+	_, err = runCommand(ctx, nil, "echo", "hello")
+	assert.ErrorContains(t, err, "no recorded response")
+}
+
+func TestTapeHTTPRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte(`{"data": []}`))
+	}))
+	dir := t.TempDir()
+
+	rec, err := NewRecorder(dir)
+	require.NoError(t, err)
+	cas, err := rec.cassette("proxmox")
+	require.NoError(t, err)
+	ctx := withCassette(context.Background(), cas)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api2/json/nodes", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "secret-token")
+	status, body, err := doHTTP(ctx, srv.Client(), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, status)
+	require.NoError(t, cas.save())
+	srv.Close()
+
+	play, err := NewReplayer(dir)
+	require.NoError(t, err)
+	cas, err = play.cassette("proxmox")
+	require.NoError(t, err)
+	ctx = withCassette(context.Background(), cas)
+
+	req, err = http.NewRequestWithContext(ctx, "GET", srv.URL+"/api2/json/nodes", nil)
+	require.NoError(t, err)
+	replayedStatus, replayedBody, err := doHTTP(ctx, http.DefaultClient, req)
+	require.NoError(t, err)
+	assert.Equal(t, status, replayedStatus)
+	assert.Equal(t, body, replayedBody)
+
+	for _, e := range cas.entries {
+		assert.NotContains(t, e.Key+e.Output, "secret-token")
+	}
+}
+
+// commandCollector reads a value that changes on every live run.
+type commandCollector struct {
+	fakeCollector
+}
+
+func (c *commandCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	out, err := runCommand(ctx, nil, "date", "+%s%N")
+	if err != nil {
+		return err
+	}
+	infra.Servers["atlas"] = &model.Server{Hostname: "atlas", OS: strings.TrimSpace(string(out))}
+	return nil
+}
+
+func TestCollectRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	collectors := []RegisteredCollector{&commandCollector{fakeCollector{name: "clock"}}}
+
+	cfg := testConfig(map[string]any{"clock": map[string]any{}})
+	cfg.Collect.Record = dir
+	recorded, _, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+	assert.FileExists(t, dir+"/clock.json")
+
+	cfg = testConfig(map[string]any{"clock": map[string]any{}})
+	cfg.Collect.Replay = dir
+	replayed, _, err := collectWith(context.Background(), cfg, collectors)
+	require.NoError(t, err)
+	assert.Equal(t, recorded.Servers["atlas"].OS, replayed.Servers["atlas"].OS)
+}
+
+func TestCollectRecordAndReplayExclusive(t *testing.T) {
+	cfg := testConfig(map[string]any{})
+	cfg.Collect.Record = t.TempDir()
+	cfg.Collect.Replay = t.TempDir()
+
+	_, _, err := collectWith(context.Background(), cfg, nil)
+	assert.Error(t, err)
+}
+
+// replaying serves a collector's commands and API calls from the recording
+// in testdata/<name>/replay, as generate --replay does.
+func replaying(t *testing.T, name string) context.Context {
+	t.Helper()
+	tape, err := NewReplayer(filepath.Join("../../testdata", name, "replay"))
+	require.NoError(t, err)
+	cas, err := tape.cassette(name)
+	require.NoError(t, err)
+	return withCassette(context.Background(), cas)
+}
@@ -0,0 +1,392 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/ThomasCrouzet/inframap-d2/internal/util"
+)
+
+func init() {
+	Register(func() RegisteredCollector { return &TerraformCollector{} })
+}
+
+// TerraformCollector reads compute resources from Terraform state files.
+type TerraformCollector struct {
+	Paths      []string         // state files or directories of state files
+	ServerType model.ServerType // type given to the servers found
+}
+
+func (tc *TerraformCollector) Metadata() CollectorMetadata {
+	return CollectorMetadata{
+		Name:        "terraform",
+		DisplayName: "Terraform State",
+		Description: "Reads servers from Terraform state files (format version 4)",
+		ConfigKey:   "terraform",
+		DetectHint:  "terraform.tfstate",
+	}
+}
+
+func (tc *TerraformCollector) Enabled(sources map[string]any) bool {
+	section, ok := sources["terraform"].(map[string]any)
+	if !ok {
+		return false
+	}
+	paths, ok := section["paths"].([]any)
+	return ok && len(paths) > 0
+}
+
+func (tc *TerraformCollector) Configure(section map[string]any) error {
+	if section == nil {
+		return nil
+	}
+	if v, ok := section["paths"].([]any); ok {
+		for _, p := range v {
+			tc.Paths = append(tc.Paths, util.ExpandPath(toString(p)))
+		}
+	}
+	if v, ok := section["server_type"].(string); ok {
+		tc.ServerType = model.ServerType(v)
+	}
+	if tc.ServerType == "" {
+		tc.ServerType = model.ServerTypeLab
+	}
+	return nil
+}
+
+func (tc *TerraformCollector) Validate() []ValidationError {
+	var errs []ValidationError
+	for i, p := range tc.Paths {
+		if _, err := os.Stat(p); err != nil {
+			errs = append(errs, ValidationError{
+				Field:      fmt.Sprintf("sources.terraform.paths[%d]", i),
+				Message:    fmt.Sprintf("state path not found: %s", p),
+				Suggestion: "point to a terraform.tfstate file or a directory containing state files (terraform state pull > terraform.tfstate for remote state)",
+			})
+		}
+	}
+	return errs
+}
+
+// tfState is the part of a Terraform state file (format version 4) we read.
+type tfState struct {
+	Version   int          `json:"version"`
+	Resources []tfResource `json:"resources"`
+}
+
+type tfResource struct {
+	Module    string       `json:"module"`
+	Mode      string       `json:"mode"`
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Instances []tfInstance `json:"instances"`
+}
+
+type tfInstance struct {
+	IndexKey   any            `json:"index_key"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// tfServer is what a compute resource says about the machine it created.
+type tfServer struct {
+	name      string
+	publicIP  string
+	addresses []string // private and secondary IPs
+	tags      []string
+	online    bool
+}
+
+// tfComputeTypes maps the compute resource types we know to a function
+// reading their attributes.
+var tfComputeTypes = map[string]func(attrs map[string]any) tfServer{
+	"hcloud_server": func(a map[string]any) tfServer {
+		s := tfServer{
+			name:     attrString(a, "name"),
+			publicIP: attrString(a, "ipv4_address"),
+			tags:     attrLabels(a, "labels"),
+			online:   attrString(a, "status") != "off",
+		}
+		for _, n := range attrList(a, "network") {
+			s.addresses = append(s.addresses, attrString(n, "ip"))
+		}
+		return s
+	},
+	"aws_instance": func(a map[string]any) tfServer {
+		tags := attrMap(a, "tags")
+		name := toString(tags["Name"])
+		if name == "" {
+			name = attrString(a, "id")
+		}
+		delete(tags, "Name")
+		return tfServer{
+			name:      name,
+			publicIP:  attrString(a, "public_ip"),
+			addresses: []string{attrString(a, "private_ip")},
+			tags:      labelList(tags),
+			online:    attrString(a, "instance_state") != "stopped",
+		}
+	},
+	"digitalocean_droplet": func(a map[string]any) tfServer {
+		return tfServer{
+			name:      attrString(a, "name"),
+			publicIP:  attrString(a, "ipv4_address"),
+			addresses: []string{attrString(a, "ipv4_address_private")},
+			tags:      attrStrings(a, "tags"),
+			online:    attrString(a, "status") != "off",
+		}
+	},
+	"linode_instance": func(a map[string]any) tfServer {
+		return tfServer{
+			name:      attrString(a, "label"),
+			publicIP:  attrString(a, "ip_address"),
+			addresses: []string{attrString(a, "private_ip_address")},
+			tags:      attrStrings(a, "tags"),
+			online:    attrString(a, "status") != "offline",
+		}
+	},
+	"vultr_instance": func(a map[string]any) tfServer {
+		name := attrString(a, "hostname")
+		if name == "" {
+			name = attrString(a, "label")
+		}
+		return tfServer{
+			name:      name,
+			publicIP:  attrString(a, "main_ip"),
+			addresses: []string{attrString(a, "internal_ip")},
+			tags:      attrStrings(a, "tags"),
+			online:    attrString(a, "power_status") != "stopped",
+		}
+	},
+	"google_compute_instance": func(a map[string]any) tfServer {
+		s := tfServer{
+			name:   attrString(a, "name"),
+			tags:   append(attrStrings(a, "tags"), attrLabels(a, "labels")...),
+			online: attrString(a, "current_status") != "TERMINATED",
+		}
+		for _, nic := range attrList(a, "network_interface") {
+			s.addresses = append(s.addresses, attrString(nic, "network_ip"))
+			for _, ac := range attrList(nic, "access_config") {
+				if s.publicIP == "" {
+					s.publicIP = attrString(ac, "nat_ip")
+				}
+			}
+		}
+		return s
+	},
+	"proxmox_vm_qemu": func(a map[string]any) tfServer {
+		return tfServer{
+			name:      attrString(a, "name"),
+			addresses: []string{attrString(a, "default_ipv4_address"), attrString(a, "ssh_host")},
+			tags:      splitProxmoxTags(attrString(a, "tags")),
+			online:    attrString(a, "vm_state") != "stopped",
+		}
+	},
+	"proxmox_lxc": func(a map[string]any) tfServer {
+		s := tfServer{
+			name:   attrString(a, "hostname"),
+			tags:   splitProxmoxTags(attrString(a, "tags")),
+			online: attrString(a, "start") != "false",
+		}
+		for _, n := range attrList(a, "network") {
+			ip, _, _ := strings.Cut(attrString(n, "ip"), "/")
+			s.addresses = append(s.addresses, ip)
+		}
+		return s
+	},
+}
+
+func (tc *TerraformCollector) Collect(ctx context.Context, infra *model.Infrastructure) error {
+	files, err := tc.stateFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := tc.parseState(ctx, infra, path); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// stateFiles expands the configured paths into state files. Directories are
+// searched recursively for *.tfstate, which covers workspaces kept in
+// terraform.tfstate.d/.
+func (tc *TerraformCollector) stateFiles() ([]string, error) {
+	var files []string
+	for _, p := range tc.Paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // skip inaccessible paths
+			}
+			if info.IsDir() {
+				// .terraform/terraform.tfstate holds backend settings, not resources
+				if path != p && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(info.Name(), ".tfstate") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (tc *TerraformCollector) parseState(ctx context.Context, infra *model.Infrastructure, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var state tfState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing state: %w", err)
+	}
+	stats := statsFrom(ctx)
+	if state.Version != 4 {
+		stats.warn("skipping %s: state format version %d, only 4 is supported", path, state.Version)
+		return nil
+	}
+	stats.fileParsed()
+
+	for _, res := range state.Resources {
+		read, ok := tfComputeTypes[res.Type]
+		if res.Mode != "managed" || !ok {
+			continue
+		}
+		for _, inst := range res.Instances {
+			s := read(inst.Attributes)
+			hostname := strings.ToLower(s.name)
+			if hostname == "" {
+				stats.warn("skipping %s: no name", res.address(inst))
+				continue
+			}
+
+			server, exists := infra.Servers[hostname]
+			if !exists {
+				server = &model.Server{
+					Hostname: hostname,
+					Label:    hostname,
+					Type:     tc.ServerType,
+				}
+				infra.Servers[hostname] = server
+			}
+			server.Online = s.online
+			if s.publicIP != "" {
+				server.PublicIP = s.publicIP
+			}
+			for _, addr := range s.addresses {
+				if net.ParseIP(addr) != nil {
+					server.AddAddress(addr)
+				} else {
+					server.AddAlias(strings.ToLower(addr))
+				}
+			}
+			for _, tag := range s.tags {
+				if !containsStr(server.Tags, tag) {
+					server.Tags = append(server.Tags, tag)
+				}
+			}
+			server.AddSource(fmt.Sprintf("%s %s", path, res.address(inst)))
+		}
+	}
+	return nil
+}
+
+// address is the instance's Terraform address, e.g.
+// module.web.hcloud_server.node[0].
+func (r tfResource) address(inst tfInstance) string {
+	addr := r.Type + "." + r.Name
+	if r.Module != "" {
+		addr = r.Module + "." + addr
+	}
+	switch k := inst.IndexKey.(type) {
+	case string:
+		addr += fmt.Sprintf("[%q]", k)
+	case float64:
+		addr += fmt.Sprintf("[%d]", int(k))
+	}
+	return addr
+}
+
+func attrString(attrs map[string]any, key string) string {
+	return toString(attrs[key])
+}
+
+func attrMap(attrs map[string]any, key string) map[string]any {
+	m, _ := attrs[key].(map[string]any)
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// attrList returns a nested block list (network interfaces, networks...).
+func attrList(attrs map[string]any, key string) []map[string]any {
+	items, _ := attrs[key].([]any)
+	var out []map[string]any
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func attrStrings(attrs map[string]any, key string) []string {
+	items, _ := attrs[key].([]any)
+	var out []string
+	for _, item := range items {
+		if s := toString(item); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// attrLabels turns a key/value map attribute into sorted "key=value" tags.
+func attrLabels(attrs map[string]any, key string) []string {
+	return labelList(attrMap(attrs, key))
+}
+
+func labelList(labels map[string]any) []string {
+	var out []string
+	for _, k := range sortedKeys(labels) {
+		if v := toString(labels[k]); v != "" {
+			out = append(out, k+"="+v)
+		} else {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// splitProxmoxTags splits a Proxmox tag string ("web;prod" or "web,prod").
+func splitProxmoxTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThomasCrouzet/inframap-d2/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerraformCollector(t *testing.T) {
+	tc := &TerraformCollector{}
+	require.NoError(t, tc.Configure(map[string]any{
+		"paths": []any{"../../testdata/terraform/cloud/terraform.tfstate"},
+	}))
+	assert.Empty(t, tc.Validate())
+
+	infra := model.NewInfrastructure()
+	require.NoError(t, tc.Collect(context.Background(), infra))
+
+	assert.ElementsMatch(t, []string{"gateway", "worker-0", "i-0abc123def4567891", "nexus.example.com"}, sortedKeys(infra.Servers))
+
+	gateway := infra.Servers["gateway"]
+	assert.Equal(t, model.ServerTypeLab, gateway.Type)
+	assert.Equal(t, "203.0.113.10", gateway.PublicIP)
+	assert.Equal(t, []string{"10.0.1.2"}, gateway.Addresses)
+	assert.Equal(t, []string{"env=prod", "role=edge"}, gateway.Tags)
+	assert.True(t, gateway.Online)
+	assert.Equal(t, []model.Source{{Location: "../../testdata/terraform/cloud/terraform.tfstate hcloud_server.gateway"}}, gateway.Sources)
+
+	worker := infra.Servers["worker-0"]
+	assert.Equal(t, "198.51.100.21", worker.PublicIP)
+	assert.Equal(t, []string{"Team=platform"}, worker.Tags)
+	assert.Equal(t, "../../testdata/terraform/cloud/terraform.tfstate module.workers.aws_instance.node[0]", worker.Sources[0].Location)
+	assert.False(t, infra.Servers["i-0abc123def4567891"].Online)
+
+	nexus := infra.Servers["nexus.example.com"]
+	assert.Equal(t, []string{"lab", "backup"}, nexus.Tags)
+	assert.Contains(t, nexus.Sources[0].Location, `digitalocean_droplet.vps["nexus"]`)
+}
+
+func TestTerraformCollectorDirectory(t *testing.T) {
+	tc := &TerraformCollector{}
+	require.NoError(t, tc.Configure(map[string]any{
+		"paths":       []any{"../../testdata/terraform/lab"},
+		"server_type": "production",
+	}))
+
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, tc.Collect(withStats(context.Background(), stats), infra))
+
+	// Workspace states are found; .terraform/ backend state is not read.
+	require.Len(t, infra.Servers, 1)
+	atlas := infra.Servers["atlas"]
+	assert.Equal(t, model.ServerTypeProduction, atlas.Type)
+	assert.Equal(t, []string{"192.168.1.20"}, atlas.Addresses)
+	assert.Equal(t, []string{"atlas.home.lan"}, atlas.Aliases)
+	assert.Equal(t, []string{"lab", "docker"}, atlas.Tags)
+	assert.Equal(t, 1, stats.FilesParsed)
+	assert.Empty(t, stats.Warnings)
+}
+
+func TestTerraformCollectorOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terraform.tfstate")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 3, "modules": []}`), 0o600))
+
+	tc := &TerraformCollector{Paths: []string{path}}
+	infra := model.NewInfrastructure()
+	stats := &CollectStats{}
+	require.NoError(t, tc.Collect(withStats(context.Background(), stats), infra))
+
+	assert.Empty(t, infra.Servers)
+	require.Len(t, stats.Warnings, 1)
+	assert.Contains(t, stats.Warnings[0], "state format version 3")
+}
+
+func TestTerraformCorrelatesWithAnsible(t *testing.T) {
+	ansible := model.NewInfrastructure()
+	ansible.Servers["nexus"] = &model.Server{Hostname: "nexus", Type: model.ServerTypeLab, Addresses: []string{"203.0.113.30"}}
+	stampSources(ansible, "ansible")
+
+	tc := &TerraformCollector{Paths: []string{"../../testdata/terraform/cloud/terraform.tfstate"}, ServerType: model.ServerTypeLab}
+	terraform := model.NewInfrastructure()
+	require.NoError(t, tc.Collect(context.Background(), terraform))
+	stampSources(terraform, "terraform")
+
+	infra := model.NewInfrastructure()
+	mergeInto(infra, ansible, nil)
+	mergeInto(infra, terraform, nil)
+	correlate(infra, nil, nil)
+
+	nexus := infra.Servers["nexus"]
+	require.NotNil(t, nexus)
+	assert.NotContains(t, infra.Servers, "nexus.example.com")
+	assert.Equal(t, "203.0.113.30", nexus.PublicIP)
+	assert.Equal(t, []string{"lab", "backup"}, nexus.Tags)
+}
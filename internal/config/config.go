@@ -1,15 +1,23 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type Config struct {
-	Output     string       `mapstructure:"output"`
-	Layout     string       `mapstructure:"layout"`
-	Direction  string       `mapstructure:"direction"`
-	Theme      string       `mapstructure:"theme"`
-	Sources    Sources      `mapstructure:"sources"`
-	Display    Display      `mapstructure:"display"`
-	Render     RenderConfig `mapstructure:"render"`
+	Output     string        `mapstructure:"output"`
+	Layout     string        `mapstructure:"layout"`
+	Direction  string        `mapstructure:"direction"`
+	Theme      string        `mapstructure:"theme"`
+	Sources    Sources       `mapstructure:"sources"`
+	Display    Display       `mapstructure:"display"`
+	Render     RenderConfig  `mapstructure:"render"`
+	Collect    CollectConfig `mapstructure:"collect"`
+	Cache      CacheConfig   `mapstructure:"cache"`
+	Merge      MergeConfig   `mapstructure:"merge"`
 	RawSources map[string]any
 }
 
@@ -26,8 +34,9 @@ type AnsibleSource struct {
 }
 
 type ComposeSource struct {
-	Files    []ComposeFile `mapstructure:"files"`
-	ScanDirs []ScanDir     `mapstructure:"scan_dirs"`
+	Files        []ComposeFile `mapstructure:"files"`
+	ScanDirs     []ScanDir     `mapstructure:"scan_dirs"`
+	TemplateVars TemplateVars  `mapstructure:"template_vars"`
 }
 
 type ComposeFile struct {
@@ -36,6 +45,14 @@ type ComposeFile struct {
 	Template bool   `mapstructure:"template"`
 }
 
+// TemplateVars points at the Ansible inventory whose variables render
+// compose templates.
+type TemplateVars struct {
+	Inventory string `mapstructure:"inventory"`
+	GroupVars string `mapstructure:"group_vars"`
+	HostVars  string `mapstructure:"host_vars"`
+}
+
 type ScanDir struct {
 	Path   string `mapstructure:"path"`
 	Server string `mapstructure:"server"`
@@ -59,6 +76,31 @@ type RenderConfig struct {
 	Format      string `mapstructure:"format"` // svg, png
 }
 
+type CollectConfig struct {
+	Timeout         time.Duration `mapstructure:"timeout"`           // per-collector default, overridable with sources.<name>.timeout
+	OnError         string        `mapstructure:"on_error"`          // fail, continue
+	PartialExitCode int           `mapstructure:"partial_exit_code"` // exit code when on_error is continue and a source failed
+	Record          string        `mapstructure:"record"`            // directory to save raw source data to
+	Replay          string        `mapstructure:"replay"`            // directory to read raw source data from instead of the sources
+}
+
+type CacheConfig struct {
+	TTL     time.Duration `mapstructure:"ttl"`     // how long raw source data is reused; 0 disables the cache
+	Dir     string        `mapstructure:"dir"`     // default: inframap-d2 under the user cache directory
+	Refresh bool          `mapstructure:"refresh"` // query every source and overwrite the cache
+}
+
+type MergeConfig struct {
+	Aliases    map[string][]string `mapstructure:"aliases"`    // hostname → other names and IPs of the same machine
+	Precedence map[string][]string `mapstructure:"precedence"` // field or "default" → collectors, most trusted first
+}
+
+// Values for CollectConfig.OnError.
+const (
+	OnErrorFail     = "fail"
+	OnErrorContinue = "continue"
+)
+
 func Load() (*Config, error) {
 	cfg := &Config{
 		Output:    "infrastructure.d2",
@@ -71,13 +113,45 @@ func Load() (*Config, error) {
 	cfg.Display.GroupBy = "category"
 	cfg.Render.DetailLevel = "standard"
 	cfg.Render.Format = "svg"
+	cfg.Collect.Timeout = 2 * time.Minute
+	cfg.Collect.OnError = OnErrorFail
 
 	if err := viper.Unmarshal(cfg); err != nil {
 		return nil, err
 	}
+	// Unmarshal reads a bare number as nanoseconds; read durations the way
+	// sources.<name>.timeout is read instead.
+	for key, d := range map[string]*time.Duration{"collect.timeout": &cfg.Collect.Timeout, "cache.ttl": &cfg.Cache.TTL} {
+		if !viper.IsSet(key) {
+			continue
+		}
+		v, err := ParseTimeout(viper.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		*d = v
+	}
 
 	// Populate RawSources for the registry-based orchestrator
 	cfg.RawSources = viper.GetStringMap("sources")
 
 	return cfg, nil
 }
+
+// ParseTimeout reads a duration from raw config: either a Go duration string
+// ("30s", "2m") or a number of seconds.
+func ParseTimeout(v any) (time.Duration, error) {
+	switch t := v.(type) {
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: %w", t, err)
+		}
+		return d, nil
+	case int:
+		return time.Duration(t) * time.Second, nil
+	case float64:
+		return time.Duration(t * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("invalid timeout %v", v)
+}
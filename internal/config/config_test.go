@@ -0,0 +1,57 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadYAML(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(strings.NewReader(yaml)))
+	return Load()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := loadYAML(t, "output: out.d2\n")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, cfg.Collect.Timeout)
+	assert.Zero(t, cfg.Cache.TTL)
+}
+
+func TestLoadDurations(t *testing.T) {
+	// A bare number is seconds, as for sources.<name>.timeout.
+	cfg, err := loadYAML(t, "collect:\n  timeout: 30\ncache:\n  ttl: 1.5\nsources:\n  systemd:\n    timeout: 30\n")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.Collect.Timeout)
+	assert.Equal(t, 1500*time.Millisecond, cfg.Cache.TTL)
+
+	cfg, err = loadYAML(t, "collect:\n  timeout: 90s\ncache:\n  ttl: 10m\n")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.Collect.Timeout)
+	assert.Equal(t, 10*time.Minute, cfg.Cache.TTL)
+
+	_, err = loadYAML(t, "collect:\n  timeout: soon\n")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "collect.timeout")
+}
+
+func TestParseTimeout(t *testing.T) {
+	d, err := ParseTimeout("90s")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	d, err = ParseTimeout(30)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, d)
+
+	_, err = ParseTimeout(true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package model
+
+import "fmt"
+
+// Conflict records two collectors reporting different values for the same
+// server field, and which value was kept.
+type Conflict struct {
+	Server      string
+	Field       string
+	Kept        string
+	KeptFrom    string
+	Dropped     string
+	DroppedFrom string
+}
+
+// String describes the conflict for a warning.
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s is %q per %s but %q per %s; kept %s",
+		c.Server, c.Field, c.Kept, orUnknown(c.KeptFrom), c.Dropped, orUnknown(c.DroppedFrom), orUnknown(c.KeptFrom))
+}
+
+func orUnknown(collector string) string {
+	if collector == "" {
+		return "unknown"
+	}
+	return collector
+}
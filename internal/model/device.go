@@ -7,4 +7,12 @@ type Device struct {
 	TailscaleIP string
 	Online      bool
 	Tags        []string
+	Sources     []Source // collectors that reported this device
+	Aliases     []string // other names the device is known by (MagicDNS name)
+}
+
+// AddSource records that a collector reported this device from location.
+// The collector name may be left empty; the orchestrator fills it in.
+func (d *Device) AddSource(location string) {
+	d.Sources = AddSources(d.Sources, Source{Location: location})
 }
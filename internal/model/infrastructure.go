@@ -7,6 +7,15 @@ type Infrastructure struct {
 	Devices      map[string]*Device
 	Networks     map[string]*Network
 	TailnetName  string
+	// Connections are explicit links between entities, referenced as
+	// "hostname" or "hostname/service".
+	Connections []Connection
+	// FailedSources lists collectors that failed when collection was
+	// allowed to continue, so the diagram can say it is incomplete.
+	FailedSources []FailedSource
+	// Conflicts lists server fields that collectors disagree on, where the
+	// merge precedence policy had to pick a value.
+	Conflicts []Conflict
 }
 
 // NewInfrastructure creates an initialized Infrastructure.
@@ -19,6 +28,12 @@ func NewInfrastructure() *Infrastructure {
 	}
 }
 
+// FailedSource records a collector that failed during a partial run.
+type FailedSource struct {
+	Name  string
+	Error string
+}
+
 // ServerGroup groups servers by type or role.
 type ServerGroup struct {
 	Name    string
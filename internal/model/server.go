@@ -22,6 +22,52 @@ type Server struct {
 	Online        bool
 	AnsibleGroups []string
 	Services      []*Service
+	Sources       []Source      // collectors that reported this server
+	Aliases       []string      // other names the host is known by (FQDN, MagicDNS name, inventory name)
+	Addresses     []string      // other IPs it is reachable at (ansible_host, LAN address)
+	Tags          []string      // provider tags and labels ("env=prod")
+	Cluster       string        // Kubernetes cluster a namespace server belongs to, when there are several
+	Storage       []StoragePool // a hypervisor's storage pools
+	// FieldSources names the collector whose value was kept for each merged
+	// field ("type", "os", ...), for merge precedence and conflict reports.
+	FieldSources map[string]string
+}
+
+// StoragePool is a storage a hypervisor keeps guest disks and backups on.
+type StoragePool struct {
+	Name  string
+	Type  string // dir, lvmthin, zfspool, nfs, ...
+	Used  int64  // bytes
+	Total int64  // bytes
+}
+
+// AddAlias records another name for this server.
+func (s *Server) AddAlias(name string) {
+	if name != "" && name != s.Hostname && !contains(s.Aliases, name) {
+		s.Aliases = append(s.Aliases, name)
+	}
+}
+
+// AddAddress records another IP address for this server.
+func (s *Server) AddAddress(ip string) {
+	if ip != "" && !contains(s.Addresses, ip) {
+		s.Addresses = append(s.Addresses, ip)
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSource records that a collector reported this server from location.
+// The collector name may be left empty; the orchestrator fills it in.
+func (s *Server) AddSource(location string) {
+	s.Sources = AddSources(s.Sources, Source{Location: location})
 }
 
 // AddService appends a service to this server.
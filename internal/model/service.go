@@ -24,7 +24,35 @@ type Service struct {
 	Volumes     []VolumeMount
 	HealthCheck *HealthCheck
 	ComposeFile string
-	Category    string // for grouping (media, productivity, infra, etc.)
+	Category    string   // for grouping (media, productivity, infra, etc.)
+	Status      string   // "running", "stopped", "template", ...; empty when the source doesn't say
+	Sources     []Source // collectors that reported this service
+
+	// Container identity, used to recognise the same container reported by
+	// several collectors.
+	ContainerName  string // container_name or the running container's name
+	Project        string // compose project (com.docker.compose.project)
+	ComposeService string // service key in the compose file (com.docker.compose.service)
+
+	// Kubernetes workload or Swarm service, for services that stand for one.
+	Kind     string // Deployment, StatefulSet, DaemonSet, Job, CronJob, Pod, or Replicated or Global service
+	Replicas int    // pods or tasks running, or desired in a manifest
+	Desired  int    // replicas asked for, when the source reports both; 0 when unknown
+
+	// Virtual machine or container, for services that stand for a guest.
+	VMID        int
+	Addresses   []string // IPs the guest reports
+	Tags        []string // provider tags
+	CPUs        int      // vCPUs allocated
+	CPUUsage    float64  // share of the allocated vCPUs in use, 0 to 1
+	Memory      int64    // bytes allocated
+	MemoryUsage int64    // bytes in use
+}
+
+// AddSource records that a collector reported this service from location.
+// The collector name may be left empty; the orchestrator fills it in.
+func (s *Service) AddSource(location string) {
+	s.Sources = AddSources(s.Sources, Source{Location: location})
 }
 
 // VolumeMount represents a volume binding.
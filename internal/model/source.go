@@ -0,0 +1,33 @@
+package model
+
+// Source records which collector contributed an entity and where the data
+// came from: a compose file, an inventory group, an API endpoint...
+type Source struct {
+	Collector string
+	Location  string
+}
+
+// String renders the source as "collector (location)".
+func (s Source) String() string {
+	if s.Location == "" {
+		return s.Collector
+	}
+	return s.Collector + " (" + s.Location + ")"
+}
+
+// AddSources appends the given sources to list, skipping duplicates.
+func AddSources(list []Source, sources ...Source) []Source {
+	for _, src := range sources {
+		dup := false
+		for _, existing := range list {
+			if existing == src {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			list = append(list, src)
+		}
+	}
+	return list
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSources(t *testing.T) {
+	list := AddSources(nil, Source{Collector: "ansible", Location: "hosts.yml"})
+	list = AddSources(list,
+		Source{Collector: "ansible", Location: "hosts.yml"},
+		Source{Collector: "tailscale"},
+	)
+
+	assert.Equal(t, []Source{
+		{Collector: "ansible", Location: "hosts.yml"},
+		{Collector: "tailscale"},
+	}, list)
+	assert.Equal(t, "ansible (hosts.yml)", list[0].String())
+	assert.Equal(t, "tailscale", list[1].String())
+}
@@ -34,6 +34,10 @@ func (r *D2Renderer) Render(infra *model.Infrastructure, cfg *config.Config) str
 
 	fmt.Fprintf(&b, "direction: %s\n\n", direction)
 
+	if len(infra.FailedSources) > 0 {
+		r.renderFailedSources(&b, infra, theme)
+	}
+
 	// Tailnet wrapper
 	tailnetLabel := "Tailscale VPN"
 	if infra.TailnetName != "" {
@@ -70,8 +74,11 @@ func (r *D2Renderer) Render(infra *model.Infrastructure, cfg *config.Config) str
 		b.WriteString("\n")
 
 		for _, server := range servers {
-			r.renderServer(&b, server, theme, cfg, "    ")
+			if server.Cluster == "" {
+				r.renderServer(&b, server, theme, cfg, "    ")
+			}
 		}
+		r.renderClusters(&b, servers, theme, cfg)
 
 		b.WriteString("  }\n\n")
 	}
@@ -86,6 +93,7 @@ func (r *D2Renderer) Render(infra *model.Infrastructure, cfg *config.Config) str
 	// External connections
 	if r.detail() != "minimal" {
 		r.renderExternalConnections(&b, infra)
+		r.renderConnections(&b, infra, cfg)
 	}
 
 	return b.String()
@@ -107,8 +115,21 @@ func (r *D2Renderer) renderServer(b *strings.Builder, server *model.Server, them
 		}
 	}
 
+	var tooltip []string
 	if server.TailscaleIP != "" && r.detail() != "minimal" {
-		fmt.Fprintf(b, "%s  tooltip: %q\n", indent, fmt.Sprintf("Tailscale: %s", server.TailscaleIP))
+		tooltip = append(tooltip, fmt.Sprintf("Tailscale: %s", server.TailscaleIP))
+	}
+	if r.detail() == "detailed" && len(server.Tags) > 0 {
+		tooltip = append(tooltip, "Tags: "+strings.Join(server.Tags, ", "))
+	}
+	if r.detail() == "detailed" && len(server.Storage) > 0 {
+		tooltip = append(tooltip, storageTooltip(server.Storage))
+	}
+	if r.detail() == "detailed" && len(server.Sources) > 0 {
+		tooltip = append(tooltip, sourcesTooltip(server.Sources))
+	}
+	if len(tooltip) > 0 {
+		fmt.Fprintf(b, "%s  tooltip: %q\n", indent, strings.Join(tooltip, "\n"))
 	}
 
 	if r.detail() != "minimal" {
@@ -131,6 +152,40 @@ func (r *D2Renderer) renderServer(b *strings.Builder, server *model.Server, them
 	fmt.Fprintf(b,"%s}\n", indent)
 }
 
+// renderClusters nests the namespaces of each named Kubernetes cluster in a
+// group of its own.
+func (r *D2Renderer) renderClusters(b *strings.Builder, servers []*model.Server, theme *Theme, cfg *config.Config) {
+	clusters := make(map[string][]*model.Server)
+	var names []string
+	for _, server := range servers {
+		if server.Cluster == "" {
+			continue
+		}
+		if _, ok := clusters[server.Cluster]; !ok {
+			names = append(names, server.Cluster)
+		}
+		clusters[server.Cluster] = append(clusters[server.Cluster], server)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(b, "    %s: %s {\n", util.SanitizeID(name), util.Quote(name))
+		for _, server := range clusters[name] {
+			r.renderServer(b, server, theme, cfg, "      ")
+		}
+		b.WriteString("    }\n")
+	}
+}
+
+// serverPath is the D2 path of a server's container.
+func serverPath(server *model.Server) string {
+	path := "tailnet." + util.SanitizeID(string(server.Type))
+	if server.Cluster != "" {
+		path += "." + util.SanitizeID(server.Cluster)
+	}
+	return path + "." + util.SanitizeID(server.Hostname)
+}
+
 // filterServices returns the services to render based on detail level.
 func (r *D2Renderer) filterServices(services []*model.Service) []*model.Service {
 	if r.detail() == "detailed" {
@@ -240,8 +295,20 @@ func (r *D2Renderer) renderService(b *strings.Builder, svc *model.Service, theme
 func (r *D2Renderer) serviceLabel(svc *model.Service) string {
 	// Smart label: use image-derived name if the service name is generic
 	displayName := smartServiceName(svc.Name, svc.Image)
+	switch {
+	case svc.Desired > 0 && svc.Replicas != svc.Desired:
+		// Running out of desired, so a degraded service doesn't look healthy.
+		displayName = fmt.Sprintf("%s ×%d/%d", displayName, svc.Replicas, svc.Desired)
+	case svc.Replicas > 1:
+		displayName = fmt.Sprintf("%s ×%d", displayName, svc.Replicas)
+	}
 
 	if r.detail() == "detailed" {
+		// Guests show where they are on the network
+		if len(svc.Addresses) > 0 {
+			return fmt.Sprintf("%s — %s", displayName, svc.Addresses[0])
+		}
+
 		// Show all ports
 		if len(svc.Ports) > 0 {
 			var portStrs []string
@@ -318,6 +385,19 @@ func (r *D2Renderer) serviceProperties(svc *model.Service, theme *Theme) []strin
 		props = append(props, "shape: hexagon")
 	}
 
+	// Shapes for Kubernetes objects; databases keep their cylinder
+	if shape, ok := kindShapes[svc.Kind]; ok && svc.Type != model.ServiceTypeDatabase {
+		props = append(props, "shape: "+shape)
+	}
+	if svc.Replicas > 1 {
+		props = append(props, "style.multiple: true")
+	}
+
+	// Stopped guests and templates are drawn faded, with a dashed border
+	if !isRunning(svc) {
+		props = append(props, "style.stroke-dash: 3", "style.opacity: 0.5")
+	}
+
 	// Shape for system services
 	if svc.Type == model.ServiceTypeSystem {
 		color := theme.ColorForElement("system")
@@ -332,9 +412,119 @@ func (r *D2Renderer) serviceProperties(svc *model.Service, theme *Theme) []strin
 		}
 	}
 
+	if r.detail() == "detailed" {
+		var tooltip []string
+		if !isRunning(svc) {
+			tooltip = append(tooltip, "Status: "+svc.Status)
+		}
+		if svc.Kind != "" {
+			tooltip = append(tooltip, workloadTooltip(svc))
+		}
+		if svc.Type == model.ServiceTypeVM || svc.Type == model.ServiceTypeLXC {
+			tooltip = append(tooltip, guestTooltip(svc)...)
+		}
+		if len(svc.Sources) > 0 {
+			tooltip = append(tooltip, sourcesTooltip(svc.Sources))
+		}
+		if len(tooltip) > 0 {
+			props = append(props, fmt.Sprintf("tooltip: %q", strings.Join(tooltip, "\n")))
+		}
+	}
+
 	return props
 }
 
+// isRunning reports whether a service is up, or doesn't say.
+func isRunning(svc *model.Service) bool {
+	return svc.Status == "" || svc.Status == "running"
+}
+
+// kindShapes tells Kubernetes object kinds and Swarm service modes apart.
+// Deployments, bare pods and replicated services keep the default rectangle.
+var kindShapes = map[string]string{
+	"StatefulSet": "stored_data",
+	"DaemonSet":   "parallelogram",
+	"Job":         "step",
+	"CronJob":     "oval",
+	"Service":     "hexagon",
+	"Ingress":     "cloud",
+	// Swarm services running a task on every node are like DaemonSets.
+	"Global service": "parallelogram",
+}
+
+// workloadTooltip describes a Kubernetes workload, e.g. "Deployment, 3 replicas",
+// or "Replicated service, 2 of 3 replicas" when fewer run than desired.
+func workloadTooltip(svc *model.Service) string {
+	if svc.Desired > 0 && svc.Replicas != svc.Desired {
+		return fmt.Sprintf("%s, %d of %d replicas", svc.Kind, svc.Replicas, svc.Desired)
+	}
+	switch svc.Replicas {
+	case 0:
+		return svc.Kind
+	case 1:
+		return svc.Kind + ", 1 replica"
+	}
+	return fmt.Sprintf("%s, %d replicas", svc.Kind, svc.Replicas)
+}
+
+// guestTooltip describes a VM or container: its ID, addresses, resources
+// and tags.
+func guestTooltip(svc *model.Service) []string {
+	var lines []string
+	if svc.VMID > 0 {
+		kind := "VM"
+		if svc.Type == model.ServiceTypeLXC {
+			kind = "CT"
+		}
+		lines = append(lines, fmt.Sprintf("%s %d", kind, svc.VMID))
+	}
+	if len(svc.Addresses) > 0 {
+		lines = append(lines, "IPs: "+strings.Join(svc.Addresses, ", "))
+	}
+	if svc.CPUs > 0 {
+		lines = append(lines, fmt.Sprintf("CPU: %.0f%% of %d vCPU", svc.CPUUsage*100, svc.CPUs))
+	}
+	if svc.Memory > 0 {
+		lines = append(lines, fmt.Sprintf("Memory: %s of %s", formatBytes(svc.MemoryUsage), formatBytes(svc.Memory)))
+	}
+	if len(svc.Tags) > 0 {
+		lines = append(lines, "Tags: "+strings.Join(svc.Tags, ", "))
+	}
+	return lines
+}
+
+// storageTooltip lists a hypervisor's storage pools and how full they are.
+func storageTooltip(pools []model.StoragePool) string {
+	parts := make([]string, len(pools))
+	for i, p := range pools {
+		parts[i] = fmt.Sprintf("%s (%s) %s of %s", p.Name, p.Type, formatBytes(p.Used), formatBytes(p.Total))
+	}
+	return "Storage: " + strings.Join(parts, ", ")
+}
+
+// formatBytes renders a size in binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// sourcesTooltip lists the collectors an entity came from.
+func sourcesTooltip(sources []model.Source) string {
+	parts := make([]string, len(sources))
+	for i, src := range sources {
+		parts[i] = src.String()
+	}
+	return "Sources: " + strings.Join(parts, ", ")
+}
+
 func (r *D2Renderer) renderDevices(b *strings.Builder, infra *model.Infrastructure, theme *Theme) {
 	color := theme.ColorForElement("devices")
 	b.WriteString("  devices: \"Other Devices\" {\n")
@@ -352,8 +542,19 @@ func (r *D2Renderer) renderDevices(b *strings.Builder, infra *model.Infrastructu
 
 		fmt.Fprintf(b,"    %s: %s", id, util.Quote(label))
 
+		var props []string
 		if icon := LookupOSIcon(dev.OS); icon != "" {
-			fmt.Fprintf(b," {\n      icon: %s\n    }\n", icon)
+			props = append(props, fmt.Sprintf("icon: %s", icon))
+		}
+		if r.detail() == "detailed" && len(dev.Sources) > 0 {
+			props = append(props, fmt.Sprintf("tooltip: %q", sourcesTooltip(dev.Sources)))
+		}
+		if len(props) > 0 {
+			b.WriteString(" {\n")
+			for _, prop := range props {
+				fmt.Fprintf(b, "      %s\n", prop)
+			}
+			b.WriteString("    }\n")
 		} else {
 			b.WriteString("\n")
 		}
@@ -362,6 +563,25 @@ func (r *D2Renderer) renderDevices(b *strings.Builder, infra *model.Infrastructu
 	b.WriteString("  }\n\n")
 }
 
+// renderFailedSources adds a note marking the diagram as incomplete.
+func (r *D2Renderer) renderFailedSources(b *strings.Builder, infra *model.Infrastructure, theme *Theme) {
+	names := make([]string, len(infra.FailedSources))
+	details := make([]string, len(infra.FailedSources))
+	for i, fs := range infra.FailedSources {
+		names[i] = fs.Name
+		details[i] = fmt.Sprintf("%s: %s", fs.Name, fs.Error)
+	}
+
+	color := theme.ColorForElement("warning")
+	label := "Partial diagram — failed sources: " + strings.Join(names, ", ")
+	fmt.Fprintf(b, "collection-warning: %s {\n", util.Quote(label))
+	b.WriteString("  shape: page\n")
+	fmt.Fprintf(b, "  style.fill: %q\n", color.Fill)
+	fmt.Fprintf(b, "  style.stroke: %q\n", color.Stroke)
+	fmt.Fprintf(b, "  tooltip: %q\n", strings.Join(details, "; "))
+	b.WriteString("}\n\n")
+}
+
 func (r *D2Renderer) renderExternalConnections(b *strings.Builder, infra *model.Infrastructure) {
 	// Check if there's a production server that implies cloudflare
 	hasProduction := false
@@ -407,18 +627,17 @@ func (r *D2Renderer) renderExternalConnections(b *strings.Builder, infra *model.
 	// Render internal connections (depends_on)
 	if r.detail() != "minimal" {
 		for _, server := range infra.Servers {
-			sid := util.SanitizeID(server.Hostname)
-			groupID := util.SanitizeID(string(server.Type))
+			path := serverPath(server)
 			for _, svc := range server.Services {
 				svcID := util.SanitizeID(svc.Name)
 				for _, dep := range svc.DependsOn {
 					depID := util.SanitizeID(dep)
 					if r.detail() == "detailed" {
-						fmt.Fprintf(b,"tailnet.%s.%s.%s -> tailnet.%s.%s.%s: \"depends_on\" { style.stroke-dash: 3 }\n",
-							groupID, sid, svcID, groupID, sid, depID)
+						fmt.Fprintf(b,"%s.%s -> %s.%s: \"depends_on\" { style.stroke-dash: 3 }\n",
+							path, svcID, path, depID)
 					} else {
-						fmt.Fprintf(b,"tailnet.%s.%s.%s -> tailnet.%s.%s.%s { style.stroke-dash: 3 }\n",
-							groupID, sid, svcID, groupID, sid, depID)
+						fmt.Fprintf(b,"%s.%s -> %s.%s { style.stroke-dash: 3 }\n",
+							path, svcID, path, depID)
 					}
 				}
 			}
@@ -426,6 +645,76 @@ func (r *D2Renderer) renderExternalConnections(b *strings.Builder, infra *model.
 	}
 }
 
+// renderConnections draws the explicit links collected into
+// infra.Connections. Links whose endpoints are not on the diagram are skipped.
+func (r *D2Renderer) renderConnections(b *strings.Builder, infra *model.Infrastructure, cfg *config.Config) {
+	for _, conn := range infra.Connections {
+		from, ok := r.connectionEndpoint(infra, cfg, conn.From)
+		if !ok {
+			continue
+		}
+		to, ok := r.connectionEndpoint(infra, cfg, conn.To)
+		if !ok {
+			continue
+		}
+
+		b.WriteString(from + " -> " + to)
+		if conn.Label != "" {
+			fmt.Fprintf(b, ": %s", util.Quote(conn.Label))
+		}
+		if conn.Style == "dashed" {
+			b.WriteString(" { style.stroke-dash: 3 }")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// connectionEndpoint resolves a connection reference ("hostname" or
+// "hostname/service") to its D2 path.
+func (r *D2Renderer) connectionEndpoint(infra *model.Infrastructure, cfg *config.Config, ref string) (string, bool) {
+	host, svcName, _ := strings.Cut(ref, "/")
+
+	server, ok := infra.Servers[host]
+	if !ok {
+		if _, ok := infra.Devices[host]; ok && svcName == "" && cfg.Display.ShowDevices {
+			return "tailnet.devices." + util.SanitizeID(host), true
+		}
+		return "", false
+	}
+
+	path := serverPath(server)
+	if svcName == "" {
+		return path, true
+	}
+
+	services := r.filterServices(server.Services)
+	var target *model.Service
+	categories := make(map[string]bool)
+	for _, svc := range services {
+		cat := svc.Category
+		if cat == "" {
+			cat = "services"
+		}
+		categories[cat] = true
+		if svc.Name == svcName {
+			target = svc
+		}
+	}
+	if target == nil {
+		return "", false
+	}
+
+	// Mirror renderGroupedServices: local servers nest services by category.
+	if server.Type == model.ServerTypeLocal && cfg.Display.GroupBy == "category" && len(categories) > 1 {
+		cat := target.Category
+		if cat == "" {
+			cat = "services"
+		}
+		path += "." + util.SanitizeID(cat)
+	}
+	return path + "." + util.SanitizeID(target.Name), true
+}
+
 func serversOfType(infra *model.Infrastructure, stype model.ServerType) []*model.Server {
 	var servers []*model.Server
 	for _, s := range infra.Servers {
@@ -168,3 +168,218 @@ func TestD2RendererDependsOn(t *testing.T) {
 
 	assert.True(t, strings.Contains(output, "tailnet.lab.srv.web -> tailnet.lab.srv.db"))
 }
+
+func TestD2RendererFailedSources(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.FailedSources = []model.FailedSource{
+		{Name: "Portainer", Error: "connection refused"},
+		{Name: "Proxmox VE", Error: "timed out after 2m0s"},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	output := RenderD2(infra, cfg)
+
+	assert.Contains(t, output, `collection-warning: "Partial diagram — failed sources: Portainer, Proxmox VE"`)
+	assert.Contains(t, output, `tooltip: "Portainer: connection refused; Proxmox VE: timed out after 2m0s"`)
+}
+
+func TestD2RendererNoWarningWhenComplete(t *testing.T) {
+	infra := model.NewInfrastructure()
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	assert.NotContains(t, RenderD2(infra, cfg), "collection-warning")
+}
+
+func TestD2RendererConnections(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["atlas"] = &model.Server{Hostname: "atlas", Type: model.ServerTypeProduction}
+	infra.Servers["nas"] = &model.Server{
+		Hostname: "nas",
+		Type:     model.ServerTypeLab,
+		Services: []*model.Service{{Name: "restic", Type: model.ServiceTypeContainer}},
+	}
+	infra.Connections = []model.Connection{
+		{From: "nas/restic", To: "atlas", Label: "backup", Style: "dashed"},
+		{From: "nas/missing", To: "atlas"},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	output := RenderD2(infra, cfg)
+
+	assert.Contains(t, output, `tailnet.lab.nas.restic -> tailnet.production.atlas: "backup" { style.stroke-dash: 3 }`)
+	assert.NotContains(t, output, "missing")
+}
+
+func TestD2RendererSourcesTooltip(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["atlas"] = &model.Server{
+		Hostname:    "atlas",
+		Type:        model.ServerTypeLab,
+		TailscaleIP: "100.64.0.3",
+		Tags:        []string{"env=prod", "docker"},
+		Sources:     []model.Source{{Collector: "ansible", Location: "hosts.yml [tailnet]"}, {Collector: "tailscale"}},
+		Services: []*model.Service{{
+			Name:    "web",
+			Type:    model.ServiceTypeContainer,
+			Sources: []model.Source{{Collector: "compose", Location: "docker-compose.yml"}},
+		}},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	cfg.Render.DetailLevel = "detailed"
+	detailed := RenderD2(infra, cfg)
+	assert.Contains(t, detailed, `tooltip: "Tailscale: 100.64.0.3\nTags: env=prod, docker\nSources: ansible (hosts.yml [tailnet]), tailscale"`)
+	assert.Contains(t, detailed, `tooltip: "Sources: compose (docker-compose.yml)"`)
+
+	cfg.Render.DetailLevel = "standard"
+	standard := RenderD2(infra, cfg)
+	assert.NotContains(t, standard, "Sources:")
+	assert.NotContains(t, standard, "Tags:")
+}
+
+func TestD2RendererKubernetesWorkloads(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["k8s-default"] = &model.Server{
+		Hostname: "k8s-default",
+		Type:     model.ServerTypeCluster,
+		Services: []*model.Service{
+			{Name: "web", Type: model.ServiceTypeContainer, Kind: "Deployment", Replicas: 3, Ports: []model.PortMapping{{HostPort: 80}}},
+			{Name: "postgres", Type: model.ServiceTypeDatabase, Kind: "StatefulSet", Replicas: 1},
+			{Name: "queue", Type: model.ServiceTypeContainer, Kind: "StatefulSet", Replicas: 1},
+			{Name: "backup", Type: model.ServiceTypeContainer, Kind: "CronJob"},
+			{Name: "svc/web", Type: model.ServiceTypeApp, Kind: "Service"},
+			{Name: "ingress/web", Type: model.ServiceTypeApp, Kind: "Ingress"},
+		},
+	}
+	infra.Connections = []model.Connection{
+		{From: "k8s-default/ingress/web", To: "k8s-default/svc/web", Label: "app.example.com"},
+		{From: "k8s-default/svc/web", To: "k8s-default/web", Label: "80→8080"},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	cfg.Render.DetailLevel = "detailed"
+	output := RenderD2(infra, cfg)
+	assert.Contains(t, output, `web: "web ×3 :80" {`)
+	assert.Contains(t, output, "style.multiple: true")
+	assert.Contains(t, output, `tooltip: "Deployment, 3 replicas"`)
+	assert.Contains(t, output, "shape: stored_data")
+	assert.Contains(t, output, "shape: oval")
+	assert.Contains(t, output, `tooltip: "CronJob"`)
+	assert.Equal(t, 1, strings.Count(output, "shape: stored_data"), "the database keeps its cylinder")
+	assert.Contains(t, output, `svc-web: "svc/web" {`)
+	assert.Contains(t, output, "shape: hexagon")
+	assert.Contains(t, output, "shape: cloud")
+	assert.Contains(t, output, `tailnet.cluster.k8s-default.ingress-web -> tailnet.cluster.k8s-default.svc-web: "app.example.com"`)
+	assert.Contains(t, output, `tailnet.cluster.k8s-default.svc-web -> tailnet.cluster.k8s-default.web: "80→8080"`)
+}
+
+func TestD2RendererKubernetesClusters(t *testing.T) {
+	infra := model.NewInfrastructure()
+	for _, cluster := range []string{"home", "work"} {
+		name := "k8s-" + cluster + "_default"
+		infra.Servers[name] = &model.Server{
+			Hostname: name,
+			Label:    "k8s/" + cluster + "/default",
+			Type:     model.ServerTypeCluster,
+			Cluster:  cluster,
+			Services: []*model.Service{
+				{Name: "web", Type: model.ServiceTypeContainer, Kind: "Deployment"},
+				{Name: "svc/web", Type: model.ServiceTypeApp, Kind: "Service"},
+			},
+		}
+	}
+	infra.Servers["k8s-default"] = &model.Server{Hostname: "k8s-default", Type: model.ServerTypeCluster}
+	infra.Connections = []model.Connection{
+		{From: "k8s-work_default/svc/web", To: "k8s-work_default/web", Label: "80"},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	output := RenderD2(infra, cfg)
+	assert.Contains(t, output, "    home: \"home\" {\n      k8s-home_default: ")
+	assert.Contains(t, output, "    work: \"work\" {\n      k8s-work_default: ")
+	assert.Contains(t, output, "\n    k8s-default: ")
+	assert.Contains(t, output, `tailnet.cluster.work.k8s-work_default.svc-web -> tailnet.cluster.work.k8s-work_default.web: "80"`)
+}
+
+func TestD2RendererProxmoxGuests(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["pve1"] = &model.Server{
+		Hostname: "pve1",
+		Type:     model.ServerTypeHypervisor,
+		Storage:  []model.StoragePool{{Name: "local-lvm", Type: "lvmthin", Used: 200 << 30, Total: 800 << 30}},
+		Services: []*model.Service{
+			{
+				Name: "ubuntu-server", Type: model.ServiceTypeVM, VMID: 100,
+				Addresses: []string{"192.168.1.50", "100.64.0.50"}, Tags: []string{"prod", "web"},
+				CPUs: 2, CPUUsage: 0.05, Memory: 4 << 30, MemoryUsage: 1536 << 20,
+			},
+			{Name: "pihole", Type: model.ServiceTypeLXC, VMID: 200},
+		},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	cfg.Render.DetailLevel = "detailed"
+	output := RenderD2(infra, cfg)
+	assert.Contains(t, output, `ubuntu-server: "ubuntu-server — 192.168.1.50" {`)
+	assert.Contains(t, output, `tooltip: "VM 100\nIPs: 192.168.1.50, 100.64.0.50\nCPU: 5% of 2 vCPU\nMemory: 1.5 GiB of 4.0 GiB\nTags: prod, web"`)
+	assert.Contains(t, output, `tooltip: "CT 200"`)
+	assert.Contains(t, output, `tooltip: "Storage: local-lvm (lvmthin) 200.0 GiB of 800.0 GiB"`)
+
+	// The standard view keeps guests to their names.
+	cfg.Render.DetailLevel = "standard"
+	output = RenderD2(infra, cfg)
+	assert.Contains(t, output, `ubuntu-server: "ubuntu-server" {`)
+	assert.NotContains(t, output, "Storage:")
+}
+
+func TestD2RendererStoppedServices(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["pve1"] = &model.Server{
+		Hostname: "pve1",
+		Type:     model.ServerTypeHypervisor,
+		Services: []*model.Service{
+			{Name: "docker-host", Type: model.ServiceTypeVM, Status: "running"},
+			{Name: "windows", Type: model.ServiceTypeVM, Status: "stopped"},
+			{Name: "debian-tpl", Type: model.ServiceTypeVM, Status: "template"},
+		},
+	}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	cfg.Render.DetailLevel = "detailed"
+	output := RenderD2(infra, cfg)
+	assert.Equal(t, 2, strings.Count(output, "style.opacity: 0.5"))
+	assert.Equal(t, 2, strings.Count(output, "style.stroke-dash: 3"))
+	assert.Contains(t, output, `tooltip: "Status: stopped"`)
+	assert.Contains(t, output, `tooltip: "Status: template"`)
+}
+
+func TestD2RendererSwarm(t *testing.T) {
+	infra := model.NewInfrastructure()
+	infra.Servers["swarm"] = &model.Server{
+		Hostname: "swarm",
+		Type:     model.ServerTypeCluster,
+		Services: []*model.Service{
+			{Name: "proxy_traefik", Type: model.ServiceTypeContainer, Kind: "Global service", Replicas: 3},
+			{Name: "apps_whoami", Type: model.ServiceTypeContainer, Kind: "Replicated service", Replicas: 2, Desired: 3},
+			{Name: "apps_redis", Type: model.ServiceTypeDatabase, Kind: "Replicated service", Replicas: 1, Desired: 1},
+		},
+	}
+	infra.Servers["node-1"] = &model.Server{Hostname: "node-1", Type: model.ServerTypeCluster, Online: true}
+	infra.Connections = []model.Connection{
+		{From: "swarm/proxy_traefik", To: "node-1", Label: "1 task", Style: "dashed"},
+	}
+	infra.ServerGroups["cluster"] = &model.ServerGroup{Name: "cluster", Label: "Clusters", Servers: []string{"node-1", "swarm"}}
+
+	cfg := &config.Config{Direction: "right", Theme: "default"}
+	cfg.Render.DetailLevel = "detailed"
+	output := RenderD2(infra, cfg)
+	assert.Contains(t, output, `cluster: "Clusters" {`)
+	assert.Contains(t, output, `proxy_traefik: "proxy_traefik ×3" {`)
+	assert.Contains(t, output, "shape: parallelogram")
+	assert.Contains(t, output, `tooltip: "Global service, 3 replicas"`)
+	// An under-replicated service shows what runs out of what is desired.
+	assert.Contains(t, output, `apps_whoami: "apps_whoami ×2/3" {`)
+	assert.Contains(t, output, `tooltip: "Replicated service, 2 of 3 replicas"`)
+	assert.Contains(t, output, `tooltip: "Replicated service, 1 replica"`)
+	assert.Equal(t, 1, strings.Count(output, "shape: parallelogram"), "replicated services keep the rectangle")
+	assert.Contains(t, output, `tailnet.cluster.swarm.proxy_traefik -> tailnet.cluster.node-1: "1 task" { style.stroke-dash: 3 }`)
+}
@@ -28,6 +28,7 @@ var themes = map[string]*Theme{
 			"cloud":      {Fill: "#DBEAFE", Stroke: "#2563EB", Font: "#1E40AF"},
 			"database":   {Fill: "#EDE9FE", Stroke: "#7C3AED", Font: "#5B21B6"},
 			"system":     {Fill: "#E0E7FF", Stroke: "#4F46E5", Font: "#3730A3"},
+			"warning":    {Fill: "#FEF3C7", Stroke: "#D97706", Font: "#92400E"},
 		},
 	},
 	"dark": {
@@ -42,6 +43,7 @@ var themes = map[string]*Theme{
 			"cloud":      {Fill: "#1E3A5F", Stroke: "#3B82F6", Font: "#93C5FD"},
 			"database":   {Fill: "#2E1065", Stroke: "#A78BFA", Font: "#C4B5FD"},
 			"system":     {Fill: "#1E1B4B", Stroke: "#818CF8", Font: "#A5B4FC"},
+			"warning":    {Fill: "#451A03", Stroke: "#F59E0B", Font: "#FCD34D"},
 		},
 	},
 	"monochrome": {
@@ -56,6 +58,7 @@ var themes = map[string]*Theme{
 			"cloud":      {Fill: "#E5E7EB", Stroke: "#6B7280", Font: "#374151"},
 			"database":   {Fill: "#D1D5DB", Stroke: "#4B5563", Font: "#1F2937"},
 			"system":     {Fill: "#E5E7EB", Stroke: "#6B7280", Font: "#374151"},
+			"warning":    {Fill: "#F9FAFB", Stroke: "#111827", Font: "#111827"},
 		},
 	},
 	"ocean": {
@@ -70,6 +73,7 @@ var themes = map[string]*Theme{
 			"cloud":      {Fill: "#E0F2FE", Stroke: "#0EA5E9", Font: "#0C4A6E"},
 			"database":   {Fill: "#C7D2FE", Stroke: "#6366F1", Font: "#3730A3"},
 			"system":     {Fill: "#DBEAFE", Stroke: "#3B82F6", Font: "#1E40AF"},
+			"warning":    {Fill: "#FEF3C7", Stroke: "#D97706", Font: "#92400E"},
 		},
 	},
 }
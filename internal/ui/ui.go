@@ -36,10 +36,9 @@ func CollectorStarted(name string) {
 func CollectorDone(name, detail string) {
 	msg := successStyle.Render("  OK ") + " " + name
 	if detail != "" {
-		msg += " " + dimStyle.Render(detail)
+		msg += " " + dimStyle.Render("("+detail+")")
 	}
-	// overwrite the "started" line by moving up
-	fmt.Printf("\033[1A\033[2K%s\n", msg)
+	fmt.Println(msg)
 }
 
 // CollectorSkipped prints a styled status when a collector is not enabled.
@@ -0,0 +1,1007 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RenderJinja2 renders a Jinja2 template with the given variables. It covers
+// what compose templates usually need and no more: {{ expressions }} made of
+// variables with attribute and index access, literals and the default,
+// bool, lower and join filters; {% if %} blocks on such expressions,
+// compared with == or != or tested with "is defined"; {% for x in list %}
+// blocks; and {# comments #}. As in Ansible, trim_blocks is on: the newline
+// after a block tag is dropped.
+//
+// Anything else can't be resolved, and is handled as StripJinja2 would: an
+// expression (an undefined variable, another filter, arithmetic, a function
+// call) is replaced with PLACEHOLDER; an if or for on one keeps its body,
+// stripped of expressions and tags; any other tag ({% set %},
+// {% include %}...) is dropped. Each is listed in unresolved as written.
+// An error means the template itself is malformed.
+func RenderJinja2(content string, vars map[string]any) (string, []string, error) {
+	r := &jinjaRenderer{vars: vars}
+	out, err := r.renderString(content, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return out, r.unresolved, nil
+}
+
+// maxJinjaDepth bounds variables whose values are themselves templates.
+const maxJinjaDepth = 10
+
+type jinjaRenderer struct {
+	vars       map[string]any
+	unresolved []string
+	depth      int
+}
+
+// jinjaScope holds the variables set by {% for %}.
+type jinjaScope struct {
+	vars   map[string]any
+	parent *jinjaScope
+}
+
+func (s *jinjaScope) lookup(name string) (any, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// jinjaUndefined is the value of a variable that isn't set. It only
+// survives the default filter and the defined/undefined tests.
+type jinjaUndefined struct{ name string }
+
+func (r *jinjaRenderer) renderString(content string, scope *jinjaScope) (string, error) {
+	toks, err := lexJinja(content)
+	if err != nil {
+		return "", err
+	}
+	p := &jinjaParser{toks: toks}
+	nodes, _, _, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	r.render(&b, nodes, scope)
+	return b.String(), nil
+}
+
+// --- template structure ---
+
+type jinjaTokenKind int
+
+const (
+	jinjaText jinjaTokenKind = iota
+	jinjaOutputTok
+	jinjaTag
+)
+
+type jinjaToken struct {
+	kind jinjaTokenKind
+	text string
+}
+
+var jinjaClosers = map[string]string{"{{": "}}", "{%": "%}", "{#": "#}"}
+
+// lexJinja splits a template into text, {{ output }} and {% tag %} tokens,
+// applying whitespace control and trim_blocks.
+func lexJinja(s string) ([]jinjaToken, error) {
+	var toks []jinjaToken
+	stripNext, newlineNext := false, false
+	for len(s) > 0 {
+		i := indexJinjaOpen(s)
+		text := s
+		if i >= 0 {
+			text = s[:i]
+		}
+		switch {
+		case stripNext:
+			text = strings.TrimLeft(text, " \t\r\n")
+		case newlineNext:
+			text = strings.TrimPrefix(strings.TrimPrefix(text, "\r"), "\n")
+		}
+		stripNext, newlineNext = false, false
+		if i < 0 {
+			toks = append(toks, jinjaToken{jinjaText, text})
+			break
+		}
+
+		open := s[i : i+2]
+		rest := s[i+2:]
+		if strings.HasPrefix(rest, "-") {
+			text = strings.TrimRight(text, " \t\r\n")
+			rest = rest[1:]
+		} else if strings.HasPrefix(rest, "+") {
+			rest = rest[1:]
+		}
+		if text != "" {
+			toks = append(toks, jinjaToken{jinjaText, text})
+		}
+
+		end := indexJinjaClose(rest, jinjaClosers[open], open != "{#")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed %s at %q", open, firstLine(s[i:]))
+		}
+		inner := rest[:end]
+		s = rest[end+2:]
+		if strings.HasSuffix(inner, "-") {
+			inner = inner[:len(inner)-1]
+			stripNext = true
+		}
+
+		switch open {
+		case "{{":
+			toks = append(toks, jinjaToken{jinjaOutputTok, strings.TrimSpace(inner)})
+		case "{%":
+			toks = append(toks, jinjaToken{jinjaTag, strings.TrimSpace(inner)})
+			newlineNext = true
+		case "{#":
+			newlineNext = true
+		}
+	}
+	return toks, nil
+}
+
+func indexJinjaOpen(s string) int {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '{' && (s[i+1] == '{' || s[i+1] == '%' || s[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexJinjaClose finds closer in s, skipping quoted strings in tags.
+func indexJinjaClose(s, closer string, quotes bool) int {
+	var quote byte
+	for i := 0; i+1 < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case quotes && (c == '\'' || c == '"'):
+			quote = c
+		case s[i:i+2] == closer:
+			return i
+		}
+	}
+	return -1
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+type jinjaNode interface{}
+
+type jinjaTextNode string
+
+type jinjaOutputNode string
+
+// jinjaDroppedNode is a tag RenderJinja2 doesn't support.
+type jinjaDroppedNode string
+
+type jinjaIfNode struct {
+	conds  []string
+	bodies [][]jinjaNode
+	orElse []jinjaNode
+}
+
+type jinjaForNode struct {
+	arg  string // as written, for unresolved
+	name string // empty when the loop is unsupported, as in "for k, v in ..."
+	iter string
+	body []jinjaNode
+}
+
+type jinjaParser struct {
+	toks []jinjaToken
+	pos  int
+}
+
+// jinjaEnds are the tags that close or split an if or for block; outside
+// one, they make the template malformed.
+var jinjaEnds = []string{"elif", "else", "endif", "endfor"}
+
+// parse reads nodes up to one of the given end tags, returning the tag word
+// and argument that stopped it.
+func (p *jinjaParser) parse(ends ...string) ([]jinjaNode, string, string, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		p.pos++
+		switch t.kind {
+		case jinjaText:
+			nodes = append(nodes, jinjaTextNode(t.text))
+		case jinjaOutputTok:
+			nodes = append(nodes, jinjaOutputNode(t.text))
+		case jinjaTag:
+			word, arg, _ := strings.Cut(t.text, " ")
+			arg = strings.TrimSpace(arg)
+			if containsString(ends, word) {
+				return nodes, word, arg, nil
+			}
+			if containsString(jinjaEnds, word) {
+				return nil, "", "", fmt.Errorf("unexpected {%% %s %%}", t.text)
+			}
+			switch word {
+			case "if":
+				node, err := p.parseIf(arg)
+				if err != nil {
+					return nil, "", "", err
+				}
+				nodes = append(nodes, node)
+			case "for":
+				node, err := p.parseFor(arg)
+				if err != nil {
+					return nil, "", "", err
+				}
+				nodes = append(nodes, node)
+			default:
+				nodes = append(nodes, jinjaDroppedNode(t.text))
+			}
+		}
+	}
+	if len(ends) > 0 {
+		return nil, "", "", fmt.Errorf("missing {%% %s %%}", ends[len(ends)-1])
+	}
+	return nodes, "", "", nil
+}
+
+func (p *jinjaParser) parseIf(cond string) (jinjaNode, error) {
+	n := &jinjaIfNode{}
+	for {
+		body, end, arg, err := p.parse("elif", "else", "endif")
+		if err != nil {
+			return nil, err
+		}
+		n.conds = append(n.conds, cond)
+		n.bodies = append(n.bodies, body)
+		switch end {
+		case "elif":
+			cond = arg
+		case "else":
+			n.orElse, _, _, err = p.parse("endif")
+			return n, err
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *jinjaParser) parseFor(arg string) (jinjaNode, error) {
+	body, _, _, err := p.parse("endfor")
+	if err != nil {
+		return nil, err
+	}
+	n := &jinjaForNode{arg: arg, body: body}
+	if name, iter, ok := strings.Cut(arg, " in "); ok && isJinjaName(strings.TrimSpace(name)) {
+		n.name, n.iter = strings.TrimSpace(name), strings.TrimSpace(iter)
+	}
+	return n, nil
+}
+
+func isJinjaName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// --- rendering ---
+
+func (r *jinjaRenderer) render(b *strings.Builder, nodes []jinjaNode, scope *jinjaScope) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case jinjaTextNode:
+			b.WriteString(string(n))
+		case jinjaOutputNode:
+			v, err := r.eval(string(n), scope)
+			if err != nil {
+				r.unresolved = append(r.unresolved, "{{ "+string(n)+" }}")
+				b.WriteString("PLACEHOLDER")
+				continue
+			}
+			b.WriteString(jinjaString(v))
+		case jinjaDroppedNode:
+			r.unresolved = append(r.unresolved, "{% "+string(n)+" %}")
+		case *jinjaIfNode:
+			r.renderIf(b, n, scope)
+		case *jinjaForNode:
+			r.renderFor(b, n, scope)
+		}
+	}
+}
+
+// renderIf renders the first branch whose condition holds. Once one can't
+// be evaluated, the branches from there on are all kept, stripped.
+func (r *jinjaRenderer) renderIf(b *strings.Builder, n *jinjaIfNode, scope *jinjaScope) {
+	for i, cond := range n.conds {
+		v, err := r.eval(cond, scope)
+		if err != nil {
+			tag := "if"
+			if i > 0 {
+				tag = "elif"
+			}
+			r.unresolved = append(r.unresolved, "{% "+tag+" "+cond+" %}")
+			for _, body := range n.bodies[i:] {
+				strip(b, body)
+			}
+			strip(b, n.orElse)
+			return
+		}
+		if jinjaTruthy(v) {
+			r.render(b, n.bodies[i], scope)
+			return
+		}
+	}
+	r.render(b, n.orElse, scope)
+}
+
+// strip writes nodes the way StripJinja2 would: text kept, expressions
+// replaced with PLACEHOLDER, tags dropped and every block's body kept.
+func strip(b *strings.Builder, nodes []jinjaNode) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case jinjaTextNode:
+			b.WriteString(string(n))
+		case jinjaOutputNode:
+			b.WriteString("PLACEHOLDER")
+		case *jinjaIfNode:
+			for _, body := range n.bodies {
+				strip(b, body)
+			}
+			strip(b, n.orElse)
+		case *jinjaForNode:
+			strip(b, n.body)
+		}
+	}
+}
+
+func (r *jinjaRenderer) renderFor(b *strings.Builder, n *jinjaForNode, scope *jinjaScope) {
+	if n.name == "" {
+		r.unresolved = append(r.unresolved, "{% for "+n.arg+" %}")
+		strip(b, n.body)
+		return
+	}
+	var items []any
+	v, err := r.eval(n.iter, scope)
+	switch it := v.(type) {
+	case []any:
+		items = it
+	case map[string]any:
+		for _, k := range sortedMapKeys(it) {
+			items = append(items, k)
+		}
+	default:
+		if err == nil && it != nil {
+			err = fmt.Errorf("cannot loop over %s", jinjaString(v))
+		}
+	}
+	if err != nil {
+		r.unresolved = append(r.unresolved, "{% for "+n.arg+" %}")
+		strip(b, n.body)
+		return
+	}
+
+	for i, item := range items {
+		inner := &jinjaScope{vars: map[string]any{
+			n.name: item,
+			"loop": map[string]any{
+				"index":  i + 1,
+				"index0": i,
+				"first":  i == 0,
+				"last":   i == len(items)-1,
+				"length": len(items),
+			},
+		}, parent: scope}
+		r.render(b, n.body, inner)
+	}
+}
+
+// eval evaluates an expression; an undefined result is an error.
+func (r *jinjaRenderer) eval(src string, scope *jinjaScope) (any, error) {
+	toks, err := tokenizeJinjaExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &jinjaExprParser{toks: toks}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unsupported %q", p.toks[p.pos].val)
+	}
+	v, err := expr(&jinjaEnv{r: r, scope: scope})
+	if u, ok := v.(jinjaUndefined); ok && err == nil {
+		err = fmt.Errorf("undefined variable %s", u.name)
+	}
+	return v, err
+}
+
+// resolve renders variable values that are templates themselves, as
+// Ansible does lazily.
+func (r *jinjaRenderer) resolve(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok || !strings.Contains(s, "{{") && !strings.Contains(s, "{%") {
+		return v, nil
+	}
+	if r.depth >= maxJinjaDepth {
+		return nil, fmt.Errorf("template nested too deep: %s", s)
+	}
+	nested := &jinjaRenderer{vars: r.vars, depth: r.depth + 1}
+	out, err := nested.renderString(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(nested.unresolved) > 0 {
+		return nil, fmt.Errorf("cannot resolve %s", nested.unresolved[0])
+	}
+	return out, nil
+}
+
+// --- expressions ---
+
+type jinjaEnv struct {
+	r     *jinjaRenderer
+	scope *jinjaScope
+}
+
+type jinjaExpr func(env *jinjaEnv) (any, error)
+
+type jinjaExprToken struct {
+	kind byte // 'n' name, '0' number, 's' string, 'o' operator
+	val  string
+}
+
+func tokenizeJinjaExpr(s string) ([]jinjaExprToken, error) {
+	var toks []jinjaExprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			toks = append(toks, jinjaExprToken{'n', s[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' && j+1 < len(s) && s[j+1] >= '0' && s[j+1] <= '9') {
+				j++
+			}
+			toks = append(toks, jinjaExprToken{'0', s[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			toks = append(toks, jinjaExprToken{'s', b.String()})
+			i = j + 1
+		default:
+			op := string(c)
+			if i+1 < len(s) && (s[i:i+2] == "==" || s[i:i+2] == "!=") {
+				op = s[i : i+2]
+			}
+			if !strings.Contains("|.,()[]", op) && op != "==" && op != "!=" {
+				return nil, fmt.Errorf("unsupported %q in %q", op, s)
+			}
+			toks = append(toks, jinjaExprToken{'o', op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+type jinjaExprParser struct {
+	toks []jinjaExprToken
+	pos  int
+}
+
+func (p *jinjaExprParser) peek() jinjaExprToken {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return jinjaExprToken{}
+}
+
+// accept consumes the next token if it is the operator or keyword val.
+func (p *jinjaExprParser) accept(val string) bool {
+	t := p.peek()
+	if (t.kind == 'o' || t.kind == 'n') && t.val == val {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) expect(val string) error {
+	if !p.accept(val) {
+		if p.pos >= len(p.toks) {
+			return fmt.Errorf("expected %q, got end of expression", val)
+		}
+		return fmt.Errorf("expected %q, got %q", val, p.peek().val)
+	}
+	return nil
+}
+
+func (p *jinjaExprParser) or() (jinjaExpr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("or") {
+		var right jinjaExpr
+		if right, err = p.and(); err != nil {
+			break
+		}
+		l := left
+		left = func(env *jinjaEnv) (any, error) {
+			v, err := l(env)
+			if err != nil || jinjaTruthy(v) {
+				return v, err
+			}
+			return right(env)
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) and() (jinjaExpr, error) {
+	left, err := p.not()
+	for err == nil && p.accept("and") {
+		var right jinjaExpr
+		if right, err = p.not(); err != nil {
+			break
+		}
+		l := left
+		left = func(env *jinjaEnv) (any, error) {
+			v, err := l(env)
+			if err != nil || !jinjaTruthy(v) {
+				return v, err
+			}
+			return right(env)
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) not() (jinjaExpr, error) {
+	if p.accept("not") {
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *jinjaEnv) (any, error) {
+			v, err := inner(env)
+			if err == nil {
+				err = checkDefined(v)
+			}
+			return !jinjaTruthy(v), err
+		}, nil
+	}
+	return p.compare()
+}
+
+// compare: filtered [('==' | '!=') filtered | 'is' ['not'] ('defined' | 'undefined')]
+func (p *jinjaExprParser) compare() (jinjaExpr, error) {
+	left, err := p.filtered()
+	if err != nil {
+		return nil, err
+	}
+	switch t := p.peek(); {
+	case t.kind == 'o' && (t.val == "==" || t.val == "!="):
+		p.pos++
+		right, err := p.filtered()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *jinjaEnv) (any, error) {
+			a, err := left(env)
+			if err != nil {
+				return nil, err
+			}
+			b, err := right(env)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkDefined(a); err != nil {
+				return nil, err
+			}
+			if err := checkDefined(b); err != nil {
+				return nil, err
+			}
+			return jinjaEqual(a, b) == (t.val == "=="), nil
+		}, nil
+	case t.kind == 'n' && t.val == "is":
+		p.pos++
+		negate := p.accept("not")
+		var want bool
+		switch {
+		case p.accept("defined"):
+			want = true
+		case p.accept("undefined"):
+		default:
+			return nil, fmt.Errorf("unsupported test %q", p.peek().val)
+		}
+		return func(env *jinjaEnv) (any, error) {
+			v, err := left(env)
+			if err != nil {
+				return nil, err
+			}
+			_, undefined := v.(jinjaUndefined)
+			return (!undefined == want) != negate, nil
+		}, nil
+	}
+	return left, nil
+}
+
+// filtered: postfix ('|' name ['(' args ')'])*
+func (p *jinjaExprParser) filtered() (jinjaExpr, error) {
+	value, err := p.postfix()
+	for err == nil && p.accept("|") {
+		name := p.peek()
+		if name.kind != 'n' {
+			return nil, fmt.Errorf("expected a filter name after |")
+		}
+		p.pos++
+		var args []jinjaExpr
+		if p.accept("(") {
+			if args, err = p.args(); err != nil {
+				break
+			}
+		}
+		v := value
+		value = func(env *jinjaEnv) (any, error) {
+			in, err := v(env)
+			if err != nil {
+				return nil, err
+			}
+			argv := make([]any, len(args))
+			for i, arg := range args {
+				if argv[i], err = arg(env); err != nil {
+					return nil, err
+				}
+			}
+			return jinjaFilter(name.val, in, argv)
+		}
+	}
+	return value, err
+}
+
+// postfix: primary ('.' name | '[' literal ']')*
+func (p *jinjaExprParser) postfix() (jinjaExpr, error) {
+	value, err := p.primary()
+	for err == nil {
+		var key any
+		switch {
+		case p.accept("."):
+			attr := p.peek()
+			if attr.kind != 'n' && attr.kind != '0' {
+				return nil, fmt.Errorf("expected an attribute name after .")
+			}
+			p.pos++
+			key = attr.val
+			if n, err := strconv.Atoi(attr.val); err == nil {
+				key = n
+			}
+		case p.accept("["):
+			lit := p.peek()
+			p.pos++
+			switch lit.kind {
+			case 's':
+				key = lit.val
+			case '0':
+				n, err := strconv.Atoi(lit.val)
+				if err != nil {
+					return nil, fmt.Errorf("unsupported index %s", lit.val)
+				}
+				key = n
+			default:
+				return nil, fmt.Errorf("unsupported index %q", lit.val)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		case p.accept("("):
+			return nil, fmt.Errorf("unsupported call")
+		default:
+			return value, nil
+		}
+		recv := value
+		value = func(env *jinjaEnv) (any, error) {
+			v, err := recv(env)
+			if err != nil {
+				return nil, err
+			}
+			return env.index(v, key)
+		}
+	}
+	return value, err
+}
+
+// args reads filter arguments up to the closing parenthesis.
+func (p *jinjaExprParser) args() ([]jinjaExpr, error) {
+	var args []jinjaExpr
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (p *jinjaExprParser) primary() (jinjaExpr, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case 's':
+		return func(*jinjaEnv) (any, error) { return t.val, nil }, nil
+	case '0':
+		var v any
+		if n, err := strconv.Atoi(t.val); err == nil {
+			v = n
+		} else {
+			f, _ := strconv.ParseFloat(t.val, 64)
+			v = f
+		}
+		return func(*jinjaEnv) (any, error) { return v, nil }, nil
+	case 'n':
+		switch t.val {
+		case "true", "True":
+			return func(*jinjaEnv) (any, error) { return true, nil }, nil
+		case "false", "False":
+			return func(*jinjaEnv) (any, error) { return false, nil }, nil
+		case "none", "None":
+			return func(*jinjaEnv) (any, error) { return nil, nil }, nil
+		}
+		return func(env *jinjaEnv) (any, error) { return env.lookup(t.val) }, nil
+	}
+	if t.val == "(" {
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return nil, fmt.Errorf("unsupported %q", t.val)
+}
+
+func (env *jinjaEnv) lookup(name string) (any, error) {
+	if v, ok := env.scope.lookup(name); ok {
+		return v, nil
+	}
+	if v, ok := env.r.vars[name]; ok {
+		return env.r.resolve(v)
+	}
+	return jinjaUndefined{name: name}, nil
+}
+
+// index is attribute and subscript access. Missing keys are undefined, so
+// that default can still apply.
+func (env *jinjaEnv) index(v, key any) (any, error) {
+	switch c := v.(type) {
+	case jinjaUndefined:
+		return jinjaUndefined{name: c.name + "." + jinjaString(key)}, nil
+	case map[string]any:
+		if item, ok := c[jinjaString(key)]; ok {
+			return env.r.resolve(item)
+		}
+		return jinjaUndefined{name: jinjaString(key)}, nil
+	case []any:
+		i, ok := key.(int)
+		if !ok {
+			return nil, fmt.Errorf("list index must be a number, not %s", jinjaString(key))
+		}
+		if i >= len(c) {
+			return jinjaUndefined{name: fmt.Sprintf("[%d]", i)}, nil
+		}
+		return env.r.resolve(c[i])
+	}
+	return nil, fmt.Errorf("cannot read %s of %s", jinjaString(key), jinjaString(v))
+}
+
+// jinjaFilter applies default, bool, lower or join. Any other filter is
+// unresolvable.
+func jinjaFilter(name string, v any, args []any) (any, error) {
+	switch name {
+	case "default", "d":
+		def, boolean := any(""), false
+		if len(args) > 0 {
+			def = args[0]
+		}
+		if len(args) > 1 {
+			boolean = jinjaTruthy(args[1])
+		}
+		if _, undefined := v.(jinjaUndefined); undefined || boolean && !jinjaTruthy(v) {
+			return def, nil
+		}
+		return v, nil
+	case "bool":
+		if err := checkDefined(v); err != nil {
+			return nil, err
+		}
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(jinjaString(v))) {
+		case "yes", "on", "1", "1.0", "true", "y", "t":
+			return true, nil
+		}
+		return false, nil
+	case "lower":
+		if err := checkDefined(v); err != nil {
+			return nil, err
+		}
+		return strings.ToLower(jinjaString(v)), nil
+	case "join":
+		if err := checkDefined(v); err != nil {
+			return nil, err
+		}
+		items, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("join needs a list, not %s", jinjaString(v))
+		}
+		sep := ""
+		if len(args) > 0 {
+			sep = jinjaString(args[0])
+		}
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = jinjaString(item)
+		}
+		return strings.Join(parts, sep), nil
+	}
+	return nil, fmt.Errorf("unsupported filter %s", name)
+}
+
+func checkDefined(v any) error {
+	if u, ok := v.(jinjaUndefined); ok {
+		return fmt.Errorf("undefined variable %s", u.name)
+	}
+	return nil
+}
+
+// --- values ---
+
+func jinjaTruthy(v any) bool {
+	switch x := v.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return x
+	case string:
+		return x != ""
+	case int:
+		return x != 0
+	case int64:
+		return x != 0
+	case float64:
+		return x != 0
+	case []any:
+		return len(x) > 0
+	case map[string]any:
+		return len(x) > 0
+	}
+	return true
+}
+
+// jinjaString formats a value as Jinja2 prints it (Python's str).
+func jinjaString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "None"
+	case string:
+		return x
+	case bool:
+		if x {
+			return "True"
+		}
+		return "False"
+	case float64:
+		// Python keeps the decimal point of a whole float: 3.0, not 3.
+		if x == math.Trunc(x) && math.Abs(x) < 1e16 {
+			return strconv.FormatFloat(x, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case []any:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = jinjaRepr(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]any:
+		var parts []string
+		for _, k := range sortedMapKeys(x) {
+			parts = append(parts, jinjaRepr(k)+": "+jinjaRepr(x[k]))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func jinjaRepr(v any) string {
+	if s, ok := v.(string); ok {
+		return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+	}
+	return jinjaString(v)
+}
+
+// jinjaEqual compares numbers by value, whatever their type, and anything
+// else structurally.
+func jinjaEqual(a, b any) bool {
+	x, aNum := jinjaFloat(a)
+	y, bNum := jinjaFloat(b)
+	if aNum || bNum {
+		return aNum && bNum && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jinjaFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func sortedMapKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,171 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderJinja2(t *testing.T) {
+	vars := map[string]any{
+		"ip":       "100.64.0.2",
+		"port":     7200,
+		"name":     "Stirling",
+		"tag":      "{{ base_tag }}-alpine",
+		"base_tag": "1.2",
+		"networks": []any{"proxy", "internal"},
+		"app":      map[string]any{"ports": []any{80, 443}, "debug": false, "env": "prod"},
+		"ratio":    1.5,
+		"replicas": 3.0,
+		"big":      1e20,
+		"empty":    "",
+		"nothing":  nil,
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{{ ip }}:{{ port }}:8080", "100.64.0.2:7200:8080"},
+		{"{{ name | lower }}", "stirling"},
+		{"{{ missing | default('latest') }}", "latest"},
+		{"{{ missing.nested | d(80) }}", "80"},
+		{"{{ missing | default }}", ""},
+		{"{{ empty | default('x') }}|{{ empty | default('x', true) }}", "|x"},
+		{"{{ networks | join(',') }}", "proxy,internal"},
+		{"{{ networks | join }}", "proxyinternal"},
+		{"{{ networks }}", "['proxy', 'internal']"},
+		{"{{ app.ports }}", "[80, 443]"},
+		{"{{ app.ports[1] }} {{ app['ports'][0] }} {{ app.ports.0 }}", "443 80 80"},
+		{"{{ app.debug }} {{ nothing }}", "False None"},
+		{"{{ ratio }} {{ replicas }} {{ big }}", "1.5 3.0 1e+20"},
+		{"image: app:{{ tag }}", "image: app:1.2-alpine"},
+		{"{{ (name) | lower }}", "stirling"},
+		{"{# comment #}\nx", "x"},
+		{"{% if app.env == 'prod' and 'proxy' != name %}yes{% else %}no{% endif %}", "yes"},
+		{"{% if port == 7200.0 %}same{% endif %}", "same"},
+		{"{% if missing is defined %}a{% elif app.debug %}b{% else %}c{% endif %}", "c"},
+		{"{% if missing is undefined and app is not undefined %}ok{% endif %}", "ok"},
+		{"{% if not app.debug or missing %}on{% endif %}", "on"},
+		{"{% if missing | default(false) %}on{% else %}off{% endif %}", "off"},
+		{"{{ 'yes' | bool }} {{ 'off' | bool }} {{ port | bool }} {{ app.debug | bool }}", "True False False False"},
+		{"{% if 'True' | bool %}on{% endif %}", "on"},
+		{"{% for n in networks %}{{ loop.index }}={{ n }}{% if not loop.last %},{% endif %}{% endfor %}", "1=proxy,2=internal"},
+		{"{% for k in app %}{{ k }} {% endfor %}", "debug env ports "},
+		{"{% for x in nothing %}x{% endfor %}done", "done"},
+		{"a\n  {%- if true %} b{% endif -%}\n  c", "a bc"},
+		{"{% if true %}\nline\n{% endif %}\nnext", "line\nnext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, unresolved, err := RenderJinja2(tt.input, vars)
+			require.NoError(t, err)
+			assert.Empty(t, unresolved)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestRenderJinja2Unresolved(t *testing.T) {
+	vars := map[string]any{
+		"x":       1,
+		"ref":     "{{ nope }}",
+		"loop":    "{{ loop }}",
+		"broken":  "{{ unclosed",
+		"ports":   []any{80},
+		"servers": map[string]any{"a": 1},
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{{ ansible_default_ipv4.address }}:80", "PLACEHOLDER:80"},
+		{"{{ lookup('env', 'HOME') }}", "PLACEHOLDER"},
+		{"{{ x | to_nice_yaml }}", "PLACEHOLDER"},
+		{"{{ x * 2 }}", "PLACEHOLDER"},
+		{"{{ x + 1 }}", "PLACEHOLDER"},
+		{"{{ 'on' if x else 'off' }}", "PLACEHOLDER"},
+		{"{{ 'a,b'.split(',') }}", "PLACEHOLDER"},
+		{"{{ range(3) }}", "PLACEHOLDER"},
+		{"{{ [1, 2] }}", "PLACEHOLDER"},
+		{"{{ x | join(',') }}", "PLACEHOLDER"},
+		{"{{ missing | lower }}", "PLACEHOLDER"},
+		{"{{ missing | join(',') }}", "PLACEHOLDER"},
+		{"{{ ports[5] }}", "PLACEHOLDER"},
+		{"{{ ports['a'] }}", "PLACEHOLDER"},
+		{"{{ ports[x] }}", "PLACEHOLDER"},
+		{"{{ x.y }}", "PLACEHOLDER"},
+		{"{{ x == missing }}", "PLACEHOLDER"},
+		{"{{ x is string }}", "PLACEHOLDER"},
+		{"{{ ref }}", "PLACEHOLDER"},
+		{"{{ loop }}", "PLACEHOLDER"},
+		{"{{ broken }}", "PLACEHOLDER"},
+		{"{% if undefined_flag %}a{% else %}b{% endif %}", "ab"},
+		{"{% if not undefined_flag %}kept{% endif %}", "kept"},
+		{"{% if x == 2 %}a{% elif undefined_flag %}b{% else %}c{% endif %}", "bc"},
+		{"{% if undefined_flag %}{% if x %}{{ x }}{% endif %}{% endif %}", "PLACEHOLDER"},
+		{"{% for x in undefined_list %}- {{ x }}{% endfor %}", "- PLACEHOLDER"},
+		{"{% for x in x %}kept{% endfor %}", "kept"},
+		{"{% for k, v in servers.items() %}kept{% endfor %}", "kept"},
+		{"{% for k in {'b': 2} %}kept{% endfor %}", "kept"},
+		{"{% set y = 2 %}kept", "kept"},
+		{"{% include 'other.j2' %}\nkept", "kept"},
+		{"{% raw %}kept{% endraw %}", "kept"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, unresolved, err := RenderJinja2(tt.input, vars)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+			require.NotEmpty(t, unresolved)
+			// Reported as written, so the warning points at the template.
+			assert.Contains(t, tt.input, unresolved[0])
+		})
+	}
+}
+
+func TestRenderJinja2KeepsWhatResolves(t *testing.T) {
+	got, unresolved, err := RenderJinja2(
+		"{% set x = 1 %}\nimage: {{ image }}\nport: {{ port | int }}\n{% if tls %}\ntls: on\n{% endif %}\n",
+		map[string]any{"image": "nginx", "port": "80", "tls": true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "image: nginx\nport: PLACEHOLDER\ntls: on\n", got)
+	assert.Equal(t, []string{"{% set x = 1 %}", "{{ port | int }}"}, unresolved)
+}
+
+func TestRenderJinja2UnresolvableBlocksKeepTheirBody(t *testing.T) {
+	got, unresolved, err := RenderJinja2(
+		"services:\n{% if enable_traefik | bool %}\n  traefik:\n    image: traefik:{{ traefik_version }}\n"+
+			"    ports:\n{% for p in ports | default([]) %}\n      - \"{{ p }}\"\n{% endfor %}\n{% endif %}\n",
+		map[string]any{"enable_traefik": "yes", "traefik_version": "v3.1"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "services:\n  traefik:\n    image: traefik:v3.1\n    ports:\n      - \"PLACEHOLDER\"\n", got)
+	assert.Equal(t, []string{"{% for p in ports | default([]) %}"}, unresolved)
+
+	got, unresolved, err = RenderJinja2(
+		"{% if traefik_enabled | bool %}\ntraefik:\n  image: traefik:{{ traefik_version }}\n{% endif %}\n",
+		map[string]any{"traefik_version": "v3.1"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "traefik:\n  image: traefik:PLACEHOLDER\n", got)
+	assert.Equal(t, []string{"{% if traefik_enabled | bool %}"}, unresolved)
+}
+
+func TestRenderJinja2Malformed(t *testing.T) {
+	for _, input := range []string{
+		"{{ unclosed",
+		"{% if x %}no end",
+		"{% for x in y %}{% endif %}",
+		"{% endfor %}",
+		"{% else %}",
+		"{% if x %}{% for y in z %}{% endif %}",
+		"{{ 'unterminated }}",
+	} {
+		_, _, err := RenderJinja2(input, nil)
+		assert.Error(t, err, input)
+	}
+}
@@ -2,10 +2,15 @@ package util
 
 import "regexp"
 
-var jinjaVarPattern = regexp.MustCompile(`\{\{[^}]*\}\}`)
+var (
+	jinjaVarPattern = regexp.MustCompile(`\{\{[^}]*\}\}`)
+	jinjaTagPattern = regexp.MustCompile(`(?s)\{%.*?%\}|\{#.*?#\}`)
+)
 
 // StripJinja2 replaces Jinja2 {{ var }} expressions with a placeholder value
-// so the YAML can be parsed by a standard YAML parser.
+// and drops {% tags %} and {# comments #}, keeping every branch, so the YAML
+// can be parsed by a standard YAML parser.
 func StripJinja2(content string) string {
+	content = jinjaTagPattern.ReplaceAllString(content, "")
 	return jinjaVarPattern.ReplaceAllString(content, "PLACEHOLDER")
 }
@@ -27,6 +27,10 @@ func TestStripJinja2(t *testing.T) {
 			"port: {{ netdata_port }}",
 			"port: PLACEHOLDER",
 		},
+		{
+			"{% if tls %}\n- 443{# https #}\n{% endif %}",
+			"\n- 443\n",
+		},
 	}
 
 	for _, tt := range tests {
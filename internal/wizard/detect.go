@@ -45,6 +45,9 @@ func Detect(d Detector) DetectionResult {
 		"hosts.yml",
 		"inventory/hosts.yml",
 		"../inventory/hosts.yml",
+		"hosts.ini",
+		"inventory/hosts.ini",
+		"inventory/hosts",
 	}
 	for _, p := range inventoryPaths {
 		if _, err := d.Stat(p); err == nil {
@@ -0,0 +1,6 @@
+#!/bin/sh
+# Dynamic inventory script: prints the same inventory as hosts.yml
+case "$1" in
+  --list) cat "$(dirname "$0")/list.json" ;;
+  *) echo '{}' ;;
+esac